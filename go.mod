@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/go-gl/mathgl v1.0.0
+	github.com/tetratelabs/wazero v1.3.1
 	github.com/unitoftime/gl v0.0.0-20220419140725-98e3994f0517
 	github.com/unitoftime/glfw v0.0.0-20220429113551-fe7f9333c9a5
 )
@@ -0,0 +1,454 @@
+package noot
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// --------------------------------------------------------------------------------
+// - AST
+// --------------------------------------------------------------------------------
+
+// Node is anything in the tree. Passes over the tree go through Walk, Inspect or Rewrite rather than adding methods here
+type Node interface {
+	Pos() Position // The first character of the node
+	End() Position // Just past the last character of the node
+}
+
+// after returns the position just past a token that starts at pos. Tokens never span lines
+func after(pos Position, lit string) Position {
+	return Position{pos.line, pos.column + utf8.RuneCountInString(lit)}
+}
+
+type FileNode struct {
+	filename string
+	nodes []Node
+	origins map[Node]string // For a file made by Link, the file each declaration was written in
+}
+func (n *FileNode) Pos() Position { return Position{1, 1} }
+func (n *FileNode) End() Position {
+	if len(n.nodes) == 0 {
+		return n.Pos()
+	}
+	return n.nodes[len(n.nodes)-1].End()
+}
+
+// PackageNode names the package a file belongs to: package name. It has to be the first thing in the file
+type PackageNode struct {
+	pos Position
+	name string
+	namePos Position
+}
+func (n *PackageNode) Pos() Position { return n.pos }
+func (n *PackageNode) End() Position { return after(n.namePos, n.name) }
+
+// ImportNode makes the exported functions of another package visible: import "path". path is unquoted, the literal as written is kept for End
+type ImportNode struct {
+	pos Position
+	path string
+	lit string
+	pathPos Position
+}
+func (n *ImportNode) Pos() Position { return n.pos }
+func (n *ImportNode) End() Position { return after(n.pathPos, n.lit) }
+
+type FuncNode struct {
+	funcPos Position // Position of the func keyword
+	recv *ArgNode // The receiver of a method, nil for plain functions
+	pos Position // Position of the name
+	funcName string
+	arguments Node
+	returnType string // Empty if the function doesn't return anything
+	returnPos Position
+	body Node
+}
+func (n *FuncNode) Pos() Position { return n.funcPos }
+func (n *FuncNode) End() Position { return n.body.End() }
+
+type CurlyScope struct {
+	pos Position
+	nodes []Node
+	rbrace Position
+}
+func (n *CurlyScope) Pos() Position { return n.pos }
+func (n *CurlyScope) End() Position { return after(n.rbrace, "}") }
+
+type ReturnNode struct {
+	pos Position
+	expr Node
+}
+func (n *ReturnNode) Pos() Position { return n.pos }
+func (n *ReturnNode) End() Position {
+	if n.expr == nil {
+		return after(n.pos, "return")
+	}
+	return n.expr.End()
+}
+
+type Arg struct {
+	name string
+	kind string
+	pos Position
+	kindPos Position
+}
+type ArgNode struct {
+	lparen Position
+	args []Arg
+	rparen Position
+}
+func (n *ArgNode) Pos() Position { return n.lparen }
+func (n *ArgNode) End() Position { return after(n.rparen, ")") }
+
+// BadNode is a placeholder for a piece of source that failed to parse. It covers everything the parser skipped, end is where parsing picked back up
+type BadNode struct {
+	pos Position
+	end Position
+}
+func (n *BadNode) Pos() Position { return n.pos }
+func (n *BadNode) End() Position { return n.end }
+
+// TypeNode declares a new named type: type X int, or type X struct { fields }
+type TypeNode struct {
+	pos Position
+	name string
+	namePos Position
+	kind string // "struct" for structs, which can't clash with a type name because it is a keyword
+	kindPos Position
+	fields []Arg
+	rbrace Position // The closing brace of a struct
+}
+func (n *TypeNode) Pos() Position { return n.pos }
+func (n *TypeNode) End() Position {
+	if n.isStruct() {
+		return after(n.rbrace, "}")
+	}
+	return after(n.kindPos, n.kind)
+}
+
+func (n *TypeNode) isStruct() bool {
+	return n.kind == "struct"
+}
+
+// VarNode declares a variable, either `var x int = expr` or `x := expr`. Either the kind or the expr may be missing, but not both
+type VarNode struct {
+	pos Position
+	name string
+	namePos Position
+	kind string
+	kindPos Position
+	expr Node
+	short bool // Declared with :=
+}
+func (n *VarNode) Pos() Position { return n.pos }
+func (n *VarNode) End() Position {
+	if n.expr == nil {
+		return after(n.kindPos, n.kind)
+	}
+	return n.expr.End()
+}
+
+// AssignNode stores a new value into an existing variable: x = expr
+type AssignNode struct {
+	pos Position
+	name string
+	expr Node
+}
+func (n *AssignNode) Pos() Position { return n.pos }
+func (n *AssignNode) End() Position { return n.expr.End() }
+
+// FieldAssignNode stores a new value into a field: x.f = expr
+type FieldAssignNode struct {
+	target *SelectorNode
+	expr Node
+}
+func (n *FieldAssignNode) Pos() Position { return n.target.Pos() }
+func (n *FieldAssignNode) End() Position { return n.expr.End() }
+
+// IfNode is `if cond { then } else els`, where els is nil, another IfNode or a CurlyScope
+type IfNode struct {
+	pos Position
+	cond Node
+	then Node
+	els Node
+}
+func (n *IfNode) Pos() Position { return n.pos }
+func (n *IfNode) End() Position {
+	if n.els == nil {
+		return n.then.End()
+	}
+	return n.els.End()
+}
+
+// ForNode loops over body while cond is true. A nil cond loops forever
+type ForNode struct {
+	pos Position
+	cond Node
+	body Node
+}
+func (n *ForNode) Pos() Position { return n.pos }
+func (n *ForNode) End() Position { return n.body.End() }
+
+// BranchNode is a break or continue statement
+type BranchNode struct {
+	pos Position
+	keyword string
+}
+func (n *BranchNode) Pos() Position { return n.pos }
+func (n *BranchNode) End() Position { return after(n.pos, n.keyword) }
+
+type Operator uint8
+const (
+	OpNone Operator = iota
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+
+	// Comparisons
+	OpEql
+	OpNeq
+	OpLss
+	OpGtr
+	OpLeq
+	OpGeq
+
+	// Logical
+	OpAnd
+	OpOr
+	OpNot
+)
+
+var operators = []string{
+	OpNone: "?",
+	OpAdd: "+",
+	OpSub: "-",
+	OpMul: "*",
+	OpDiv: "/",
+
+	OpEql: "==",
+	OpNeq: "!=",
+	OpLss: "<",
+	OpGtr: ">",
+	OpLeq: "<=",
+	OpGeq: ">=",
+
+	OpAnd: "&&",
+	OpOr: "||",
+	OpNot: "!",
+}
+
+func (o Operator) String() string {
+	return operators[o]
+}
+
+// binaryOps maps infix tokens to their operator and binding power. Higher binds tighter
+var binaryOps = map[Token]struct{
+	op Operator
+	prec int
+}{
+	LOR: {OpOr, 1},
+	LAND: {OpAnd, 2},
+
+	EQL: {OpEql, 3},
+	NEQ: {OpNeq, 3},
+	LSS: {OpLss, 3},
+	GTR: {OpGtr, 3},
+	LEQ: {OpLeq, 3},
+	GEQ: {OpGeq, 3},
+
+	ADD: {OpAdd, 4},
+	SUB: {OpSub, 4},
+	MUL: {OpMul, 5},
+	DIV: {OpDiv, 5},
+}
+
+// ExprNode is a parenthesised sub-expression
+type ExprNode struct {
+	pos Position
+	expr Node
+	rparen Position
+}
+func (n *ExprNode) Pos() Position { return n.pos }
+func (n *ExprNode) End() Position { return after(n.rparen, ")") }
+
+// BinaryExprNode is an infix operation: left op right
+type BinaryExprNode struct {
+	pos Position // Position of the operator
+	op Operator
+	left, right Node
+}
+func (n *BinaryExprNode) Pos() Position { return n.left.Pos() }
+func (n *BinaryExprNode) End() Position { return n.right.End() }
+
+// PrefixExprNode is a unary operation: -x or !x
+type PrefixExprNode struct {
+	pos Position
+	op Operator
+	expr Node
+}
+func (n *PrefixExprNode) Pos() Position { return n.pos }
+func (n *PrefixExprNode) End() Position { return n.expr.End() }
+
+// CallExprNode calls a function: fn(args...)
+type CallExprNode struct {
+	pos Position // Position of the (
+	fn Node
+	args []Node
+	rparen Position
+}
+func (n *CallExprNode) Pos() Position { return n.fn.Pos() }
+func (n *CallExprNode) End() Position { return after(n.rparen, ")") }
+
+// SelectorNode picks a field or method out of a value: expr.name
+type SelectorNode struct {
+	expr Node
+	name string
+	namePos Position
+}
+func (n *SelectorNode) Pos() Position { return n.expr.Pos() }
+func (n *SelectorNode) End() Position { return after(n.namePos, n.name) }
+
+// CompositeLitNode builds a struct value: T{a: 1, b: 2} or T{1, 2}
+type CompositeLitNode struct {
+	pos Position // Position of the type name
+	kind string
+	lbrace Position
+	fields []FieldInit
+	rbrace Position
+}
+func (n *CompositeLitNode) Pos() Position { return n.pos }
+func (n *CompositeLitNode) End() Position { return after(n.rbrace, "}") }
+
+// FieldInit is one element of a composite literal. name is empty for elements given by position
+type FieldInit struct {
+	name string
+	namePos Position
+	expr Node
+}
+
+// ExprStmtNode is an expression used as a statement. Only calls are allowed to do this
+type ExprStmtNode struct {
+	expr Node
+}
+func (n *ExprStmtNode) Pos() Position { return n.expr.Pos() }
+func (n *ExprStmtNode) End() Position { return n.expr.End() }
+
+// UnaryNode is a single operand token: an identifier or an integer literal
+type UnaryNode struct {
+	token PackedToken
+}
+func (n *UnaryNode) Pos() Position { return n.token.pos }
+func (n *UnaryNode) End() Position { return after(n.token.pos, n.token.str) }
+
+// --------------------------------------------------------------------------------
+// - S-Expressions
+// --------------------------------------------------------------------------------
+// A compact lisp-style dump of the tree, mostly useful for eyeballing the shape of expressions and for tests
+
+func sexpr(node Node) string {
+	switch n := node.(type) {
+	case *FileNode:
+		parts := []string{}
+		for i := range n.nodes {
+			parts = append(parts, sexpr(n.nodes[i]))
+		}
+		return strings.Join(parts, "\n")
+	case *PackageNode:
+		return "(package " + n.name + ")"
+	case *ImportNode:
+		return "(import " + n.lit + ")"
+	case *FuncNode:
+		s := "(func "
+		if n.recv != nil {
+			s += sexpr(n.recv) + " "
+		}
+		s += n.funcName + " " + sexpr(n.arguments)
+		if n.returnType != "" {
+			s += " " + n.returnType
+		}
+		return s + " " + sexpr(n.body) + ")"
+	case *ArgNode:
+		s := "(args"
+		for _, arg := range n.args {
+			s += " (" + arg.name + " " + arg.kind + ")"
+		}
+		return s + ")"
+	case *TypeNode:
+		s := "(type " + n.name + " " + n.kind
+		for _, f := range n.fields {
+			s += " (" + f.name + " " + f.kind + ")"
+		}
+		return s + ")"
+	case *CurlyScope:
+		s := "(block"
+		for i := range n.nodes {
+			s += " " + sexpr(n.nodes[i])
+		}
+		return s + ")"
+	case *ReturnNode:
+		if n.expr == nil {
+			return "(return)"
+		}
+		return "(return " + sexpr(n.expr) + ")"
+	case *VarNode:
+		if n.short {
+			return "(:= " + n.name + " " + sexpr(n.expr) + ")"
+		}
+		s := "(var " + n.name
+		if n.kind != "" {
+			s += " " + n.kind
+		}
+		if n.expr != nil {
+			s += " " + sexpr(n.expr)
+		}
+		return s + ")"
+	case *AssignNode:
+		return "(= " + n.name + " " + sexpr(n.expr) + ")"
+	case *FieldAssignNode:
+		return "(= " + sexpr(n.target) + " " + sexpr(n.expr) + ")"
+	case *IfNode:
+		s := "(if " + sexpr(n.cond) + " " + sexpr(n.then)
+		if n.els != nil {
+			s += " " + sexpr(n.els)
+		}
+		return s + ")"
+	case *ForNode:
+		if n.cond == nil {
+			return "(for " + sexpr(n.body) + ")"
+		}
+		return "(for " + sexpr(n.cond) + " " + sexpr(n.body) + ")"
+	case *BranchNode:
+		return "(" + n.keyword + ")"
+	case *ExprStmtNode:
+		return sexpr(n.expr)
+	case *UnaryNode:
+		return n.token.str
+	case *ExprNode:
+		return sexpr(n.expr)
+	case *PrefixExprNode:
+		return "(" + n.op.String() + " " + sexpr(n.expr) + ")"
+	case *BinaryExprNode:
+		return "(" + n.op.String() + " " + sexpr(n.left) + " " + sexpr(n.right) + ")"
+	case *CallExprNode:
+		s := "(call " + sexpr(n.fn)
+		for _, arg := range n.args {
+			s += " " + sexpr(arg)
+		}
+		return s + ")"
+	case *SelectorNode:
+		return "(. " + sexpr(n.expr) + " " + n.name + ")"
+	case *CompositeLitNode:
+		s := "(lit " + n.kind
+		for _, f := range n.fields {
+			if f.name == "" {
+				s += " " + sexpr(f.expr)
+			} else {
+				s += " (" + f.name + " " + sexpr(f.expr) + ")"
+			}
+		}
+		return s + ")"
+	case *BadNode:
+		return "(bad)"
+	}
+	return "?"
+}
@@ -0,0 +1,1024 @@
+package noot
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+)
+
+// --------------------------------------------------------------------------------
+// - Type Checker
+// --------------------------------------------------------------------------------
+
+type Kind uint8
+const (
+	KindInvalid Kind = iota
+	KindInt
+	KindFloat
+	KindBool
+	KindString
+	KindStruct
+
+	// Literals don't have a type of their own until they are combined with something that does, so 1 + x is whatever type x is
+	KindUntypedInt
+	KindUntypedFloat
+)
+
+// Type is a builtin or declared type. Types are compared by pointer, so a declared `type X int` is different to int even though they have the same kind
+type Type struct {
+	name string
+	kind Kind
+	fields []field // For structs. A type declared as another struct type shares its fields
+	methods map[string]*FuncNode
+}
+
+type field struct {
+	name string
+	t *Type
+}
+
+func (t *Type) field(name string) (*Type, bool) {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f.t, true
+		}
+	}
+	return nil, false
+}
+
+func (t *Type) String() string {
+	return t.name
+}
+
+func (t *Type) isNumeric() bool {
+	switch t.kind {
+	case KindInt, KindFloat, KindUntypedInt, KindUntypedFloat:
+		return true
+	}
+	return false
+}
+
+func (t *Type) isUntyped() bool {
+	return t.kind == KindUntypedInt || t.kind == KindUntypedFloat
+}
+
+var (
+	TypeInvalid = &Type{name: "invalid type", kind: KindInvalid}
+	TypeInt = &Type{name: "int", kind: KindInt}
+	TypeFloat = &Type{name: "float", kind: KindFloat}
+	TypeBool = &Type{name: "bool", kind: KindBool}
+	TypeString = &Type{name: "string", kind: KindString}
+
+	TypeUntypedInt = &Type{name: "untyped int", kind: KindUntypedInt}
+	TypeUntypedFloat = &Type{name: "untyped float", kind: KindUntypedFloat}
+
+	// The result of calling a function that doesn't return anything
+	typeNone = &Type{name: "no value", kind: KindInvalid}
+)
+
+// Checker resolves every name in a file and works out the type of every expression. The types it finds are kept in exprTypes so that later passes don't have to redo the work.
+type Checker struct {
+	errors []Diagnostic
+	types map[string]*Type
+	funcs map[string]*FuncNode
+	exprTypes map[Node]*Type
+	untyped map[Node]*Type // The type untyped constants had before convertUntyped gave them the type they are used as
+	consts map[Node]value // The values of numeric constant expressions, so that overflows and divisions by zero are caught before anything runs
+
+	// Imports, set up by a Loader before Check. imports maps the name each imported package is used by to the package, which is nil if it couldn't be loaded. pkgRefs records which selectors are calls into other packages
+	packages map[string]*Package // By import path
+	imports map[string]*Package
+	usedImports map[string]bool
+	pkgRefs map[*SelectorNode]*Package
+	owners map[*FuncNode]*Checker // The checker of the package each function was declared in, shared by every package a Loader loads
+
+	// State for the function currently being checked
+	ret *Type
+	loops int // How many loops we are nested inside
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		types: map[string]*Type{
+			"int": TypeInt,
+			"float": TypeFloat,
+			"bool": TypeBool,
+			"string": TypeString,
+		},
+		funcs: make(map[string]*FuncNode),
+		exprTypes: make(map[Node]*Type),
+		untyped: make(map[Node]*Type),
+		consts: make(map[Node]value),
+		imports: make(map[string]*Package),
+		usedImports: make(map[string]bool),
+		pkgRefs: make(map[*SelectorNode]*Package),
+	}
+}
+
+// owner is the checker that f's parameter and result types have to be resolved in
+func (c *Checker) owner(f *FuncNode) *Checker {
+	if o, ok := c.owners[f]; ok {
+		return o
+	}
+	return c
+}
+
+func (c *Checker) errorf(pos Position, format string, args ...any) {
+	c.errors = append(c.errors, Diagnostic{pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+// notef adds a note to the last error
+func (c *Checker) notef(format string, args ...any) {
+	last := &c.errors[len(c.errors)-1]
+	last.notes = append(last.notes, fmt.Sprintf(format, args...))
+}
+
+// suggest adds a note to the last error if name looks like a typo of one of the candidates
+func (c *Checker) suggest(name string, candidates []string) {
+	if guess := closest(name, candidates); guess != "" {
+		c.notef("did you mean %s?", guess)
+	}
+}
+
+func (c *Checker) funcNames() []string {
+	names := []string{}
+	for name := range c.funcs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// scope maps variable names to their types. Lookups walk outwards through the parents
+type scope struct {
+	parent *scope
+	vars map[string]*Type
+}
+
+// isUniverse reports whether name resolves to one of the predeclared constants
+func (s *scope) isUniverse(name string) bool {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.vars[name]; ok {
+			return s == universe
+		}
+	}
+	return false
+}
+
+// names lists every variable that is visible
+func (s *scope) names() []string {
+	names := []string{}
+	for ; s != nil; s = s.parent {
+		for name := range s.vars {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *scope) lookup(name string) (*Type, bool) {
+	for ; s != nil; s = s.parent {
+		if t, ok := s.vars[name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// universe holds the predeclared constants
+var universe = &scope{
+	vars: map[string]*Type{
+		"true": TypeBool,
+		"false": TypeBool,
+	},
+}
+
+// Check type checks the whole file. Declarations are collected first so that their order in the file doesn't matter
+func (c *Checker) Check(file *FileNode) []Diagnostic {
+	imports := []*ImportNode{}
+	decls := make(map[string]*TypeNode) // The first declaration of each type, later ones are reported and then ignored
+	types := []*TypeNode{}
+	header := true // Whether only the package clause and imports have been seen so far
+	for i, node := range file.nodes {
+		switch node.(type) {
+		case *ImportNode:
+		case *PackageNode:
+			header = header && i == 0
+		default:
+			header = false
+		}
+		switch n := node.(type) {
+		case *PackageNode:
+			if i > 0 {
+				c.errorf(n.pos, "package clause must come first")
+			}
+		case *ImportNode:
+			if !header {
+				c.errorf(n.pos, "imports must come before other declarations")
+			}
+			imports = append(imports, n)
+		case *TypeNode:
+			if _, exists := c.types[n.name]; exists {
+				c.errorf(n.pos, "%s redeclared", n.name)
+				continue
+			}
+			c.types[n.name] = &Type{name: n.name, kind: KindInvalid}
+			decls[n.name] = n
+			types = append(types, n)
+		case *FuncNode:
+			if n.recv != nil {
+				continue // Methods are collected once the types are known
+			}
+			if _, exists := c.funcs[n.funcName]; exists {
+				c.errorf(n.pos, "%s redeclared", n.funcName)
+				continue
+			}
+			c.funcs[n.funcName] = n
+		case *BadNode:
+		default:
+			c.errorf(n.Pos(), "statement outside function body")
+		}
+	}
+	c.declareImports(imports)
+
+	// Declared types take the kind of their underlying type. This is done as a second step so that types can refer to types declared after them
+	for _, n := range types {
+		if !n.isStruct() && c.resolveType(n.kind, n.kindPos) == TypeInvalid {
+			continue
+		}
+		kind := c.declKind(n, decls, make(map[string]bool))
+		if kind == KindInvalid {
+			c.errorf(n.pos, "invalid recursive type %s", n.name)
+		}
+		c.types[n.name].kind = kind
+	}
+	c.declareFields(types, decls)
+
+	for _, node := range file.nodes {
+		if n, ok := node.(*FuncNode); ok && n.recv != nil {
+			c.declareMethod(n)
+		}
+	}
+
+	for _, node := range file.nodes {
+		if n, ok := node.(*FuncNode); ok {
+			c.checkFunc(n)
+		}
+	}
+
+	for _, n := range imports {
+		if pkg := c.packages[n.path]; pkg != nil && !c.usedImports[pkg.name] {
+			c.errorf(n.pathPos, "%s imported and not used", n.lit)
+		}
+	}
+	return c.errors
+}
+
+// declareImports makes each imported package visible by its name. Paths the loader didn't resolve still declare a name, from the last element of the path, so that uses of it aren't reported a second time
+func (c *Checker) declareImports(imports []*ImportNode) {
+	for _, n := range imports {
+		pkg := c.packages[n.path]
+		name := path.Base(n.path)
+		if pkg != nil {
+			name = pkg.name
+		}
+		if _, exists := c.imports[name]; exists {
+			c.errorf(n.pathPos, "%s redeclared in this file", name)
+			continue
+		}
+		if f, exists := c.funcs[name]; exists && pkg != nil {
+			c.errorf(f.pos, "%s already declared through import of package %s", name, n.lit)
+		}
+		c.imports[name] = pkg
+	}
+}
+
+// packageRef reports whether sel refers to a function in an imported package, pkg.F. Variables with the same name as the package hide it
+func (c *Checker) packageRef(sel *SelectorNode, s *scope) (*Package, string, bool) {
+	ident, ok := sel.expr.(*UnaryNode)
+	if !ok || ident.token.token != IDENT {
+		return nil, "", false
+	}
+	name := ident.token.str
+	if _, isVar := s.lookup(name); isVar {
+		return nil, "", false
+	}
+	pkg, ok := c.imports[name]
+	if ok {
+		c.usedImports[name] = true
+	}
+	return pkg, name, ok
+}
+
+// declareFields resolves the fields of every struct type. Types declared as another struct type get the same fields
+func (c *Checker) declareFields(types []*TypeNode, decls map[string]*TypeNode) {
+	for _, n := range types {
+		if !n.isStruct() {
+			continue
+		}
+		t := c.types[n.name]
+		for _, f := range n.fields {
+			if _, exists := t.field(f.name); exists {
+				c.errorf(f.pos, "%s redeclared in struct %s", f.name, n.name)
+				continue
+			}
+			t.fields = append(t.fields, field{f.name, c.resolveType(f.kind, f.kindPos)})
+		}
+	}
+
+	for _, n := range types {
+		if n.isStruct() || c.types[n.name].kind != KindStruct {
+			continue
+		}
+		under := decls[n.kind]
+		for under != nil && !under.isStruct() {
+			under = decls[under.kind]
+		}
+		if under != nil {
+			c.types[n.name].fields = c.types[under.name].fields
+		}
+	}
+
+	// A struct can't contain itself, it would never end. Each cycle is reported once, at the first type found on it
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[*Type]int)
+	var visit func(t *Type, pos Position)
+	visit = func(t *Type, pos Position) {
+		switch state[t] {
+		case visiting:
+			c.errorf(pos, "invalid recursive type %s", t.name)
+			return
+		case done:
+			return
+		}
+		state[t] = visiting
+		for _, f := range t.fields {
+			if f.t.kind == KindStruct {
+				visit(f.t, pos)
+			}
+		}
+		state[t] = done
+	}
+	for _, n := range types {
+		if n.isStruct() {
+			visit(c.types[n.name], n.pos)
+		}
+	}
+}
+
+// declareMethod adds a method to the method set of its receiver's type
+func (c *Checker) declareMethod(f *FuncNode) {
+	if len(f.recv.args) != 1 {
+		c.errorf(f.recv.lparen, "method %s must have exactly one receiver", f.funcName)
+		return
+	}
+	recv := f.recv.args[0]
+	t := c.resolveType(recv.kind, recv.kindPos)
+	if t == TypeInvalid {
+		return
+	}
+	if t.kind != KindStruct {
+		c.errorf(recv.kindPos, "invalid receiver type %s (methods can only be declared on struct types)", t)
+		return
+	}
+	if _, exists := t.methods[f.funcName]; exists {
+		c.errorf(f.pos, "method %s.%s already declared", t, f.funcName)
+		return
+	}
+	if _, exists := t.field(f.funcName); exists {
+		c.errorf(f.pos, "field and method with the same name %s", f.funcName)
+		return
+	}
+	if t.methods == nil {
+		t.methods = make(map[string]*FuncNode)
+	}
+	t.methods[f.funcName] = f
+}
+
+// declKind follows a chain of type declarations down to a builtin kind. Cycles give KindInvalid
+func (c *Checker) declKind(n *TypeNode, decls map[string]*TypeNode, seen map[string]bool) Kind {
+	if n.isStruct() {
+		return KindStruct
+	}
+	if seen[n.name] {
+		return KindInvalid
+	}
+	seen[n.name] = true
+	if under, ok := decls[n.kind]; ok {
+		return c.declKind(under, decls, seen)
+	}
+	if t, ok := c.types[n.kind]; ok {
+		return t.kind
+	}
+	return KindInvalid
+}
+
+// resolveTypeQuiet is resolveType for types that have already been reported if they are undefined
+func (c *Checker) resolveTypeQuiet(name string) *Type {
+	if t, ok := c.types[name]; ok {
+		return t
+	}
+	return TypeInvalid
+}
+
+func (c *Checker) resolveType(name string, pos Position) *Type {
+	t, ok := c.types[name]
+	if !ok {
+		c.errorf(pos, "undefined type: %s", name)
+		names := []string{}
+		for name := range c.types {
+			names = append(names, name)
+		}
+		c.suggest(name, names)
+		return TypeInvalid
+	}
+	return t
+}
+
+func (c *Checker) checkFunc(f *FuncNode) {
+	s := &scope{universe, make(map[string]*Type)}
+	args := f.arguments.(*ArgNode).args
+	if f.recv != nil {
+		args = append(append([]Arg{}, f.recv.args...), args...)
+	}
+	for _, arg := range args {
+		if _, exists := s.vars[arg.name]; exists {
+			c.errorf(arg.pos, "duplicate argument %s", arg.name)
+		}
+		s.vars[arg.name] = c.resolveType(arg.kind, arg.kindPos)
+	}
+
+	c.ret = nil
+	if f.returnType != "" {
+		c.ret = c.resolveType(f.returnType, f.returnPos)
+	}
+	c.loops = 0
+
+	body, ok := f.body.(*CurlyScope)
+	if !ok {
+		return // The body didn't parse, that has already been reported
+	}
+	c.checkStmts(body.nodes, s)
+
+	if c.ret != nil && !terminates(body) {
+		c.errorf(f.pos, "missing return at end of %s", f.funcName)
+	}
+}
+
+// terminates reports whether a statement is guaranteed to end in a return. This follows Go's rules: a block terminates if its last statement does, an if terminates if both branches do and a loop terminates if it has no condition and nothing breaks out of it
+func terminates(node Node) bool {
+	switch n := node.(type) {
+	case *ReturnNode:
+		return true
+	case *CurlyScope:
+		return len(n.nodes) > 0 && terminates(n.nodes[len(n.nodes)-1])
+	case *IfNode:
+		return n.els != nil && terminates(n.then) && terminates(n.els)
+	case *ForNode:
+		return n.cond == nil && !hasBreak(n.body)
+	}
+	return false
+}
+
+// hasBreak reports whether there is a break that targets the enclosing loop. Breaks inside nested loops belong to those loops instead
+func hasBreak(node Node) bool {
+	found := false
+	Inspect(node, func(n Node) bool {
+		switch n := n.(type) {
+		case *ForNode:
+			return false
+		case *BranchNode:
+			found = found || n.keyword == "break"
+		}
+		return !found
+	})
+	return found
+}
+
+func (c *Checker) checkStmts(nodes []Node, s *scope) {
+	for _, node := range nodes {
+		c.checkStmt(node, s)
+	}
+}
+
+func (c *Checker) checkStmt(node Node, s *scope) {
+	switch n := node.(type) {
+	case *ReturnNode:
+		if n.expr == nil {
+			if c.ret != nil {
+				c.errorf(n.pos, "not enough return values")
+			}
+			return
+		}
+		t := c.checkExpr(n.expr, s)
+		if c.ret == nil {
+			c.errorf(n.pos, "too many return values")
+			return
+		}
+		if !c.assignable(t, c.ret) {
+			c.errorf(n.pos, "cannot return %s as %s", t, c.ret)
+			return
+		}
+		c.convertUntyped(n.expr, c.ret)
+	case *VarNode:
+		if _, exists := s.vars[n.name]; exists {
+			c.errorf(n.pos, "%s redeclared in this block", n.name)
+		}
+
+		var t *Type
+		if n.kind != "" {
+			t = c.resolveType(n.kind, n.kindPos)
+		}
+		if n.expr != nil {
+			et := c.checkExpr(n.expr, s)
+			if t == nil {
+				t = defaultType(et)
+			} else if !c.assignable(et, t) {
+				c.errorf(n.pos, "cannot use %s as %s in variable declaration", et, t)
+			}
+			c.convertUntyped(n.expr, t)
+		}
+		s.vars[n.name] = t
+	case *AssignNode:
+		t, ok := s.lookup(n.name)
+		if !ok {
+			c.errorf(n.pos, "undefined: %s", n.name)
+			if guess := closest(n.name, s.names()); guess != "" {
+				c.notef("did you mean %s?", guess)
+			} else {
+				c.notef("use := to declare a new variable")
+			}
+			c.checkExpr(n.expr, s)
+			return
+		}
+		if s.isUniverse(n.name) {
+			c.errorf(n.pos, "cannot assign to %s", n.name)
+		}
+		et := c.checkExpr(n.expr, s)
+		if !c.assignable(et, t) {
+			c.errorf(n.pos, "cannot assign %s to %s of type %s", et, n.name, t)
+			return
+		}
+		c.convertUntyped(n.expr, t)
+	case *FieldAssignNode:
+		if !c.addressable(n.target.expr, s) {
+			c.errorf(n.target.Pos(), "cannot assign to %s", formatExpr(n.target))
+		}
+		t := c.checkExpr(n.target, s)
+		et := c.checkExpr(n.expr, s)
+		if !c.assignable(et, t) {
+			c.errorf(n.Pos(), "cannot assign %s to %s of type %s", et, formatExpr(n.target), t)
+			return
+		}
+		c.convertUntyped(n.expr, t)
+	case *IfNode:
+		c.checkCond(n.cond, s, "if")
+		c.checkStmt(n.then, s)
+		if n.els != nil {
+			c.checkStmt(n.els, s)
+		}
+	case *ForNode:
+		if n.cond != nil {
+			c.checkCond(n.cond, s, "for")
+		}
+		c.loops++
+		c.checkStmt(n.body, s)
+		c.loops--
+	case *ExprStmtNode:
+		// Skips checkExpr because calls with no value are fine here
+		c.exprTypes[n.expr] = c.exprType(n.expr, s)
+	case *BranchNode:
+		if c.loops == 0 {
+			c.errorf(n.pos, "%s is not in a loop", n.keyword)
+		}
+	case *CurlyScope:
+		c.checkStmts(n.nodes, &scope{s, make(map[string]*Type)})
+	case *TypeNode:
+		c.errorf(n.pos, "type declarations are only allowed at the top level")
+	case *FuncNode:
+		c.errorf(n.pos, "function declarations are only allowed at the top level")
+	case *PackageNode:
+		c.errorf(n.pos, "package clause is only allowed at the top of a file")
+	case *ImportNode:
+		c.errorf(n.pos, "imports are only allowed at the top level")
+	}
+}
+
+// addressable reports whether the fields of node can be assigned to: it has to be a variable, or a field of one
+func (c *Checker) addressable(node Node, s *scope) bool {
+	switch n := node.(type) {
+	case *UnaryNode:
+		_, isVar := s.lookup(n.token.str)
+		return n.token.token == IDENT && isVar && !s.isUniverse(n.token.str)
+	case *SelectorNode:
+		return c.addressable(n.expr, s)
+	case *ExprNode:
+		return c.addressable(n.expr, s)
+	}
+	return false
+}
+
+func (c *Checker) checkCond(cond Node, s *scope, keyword string) {
+	t := c.checkExpr(cond, s)
+	if t != TypeInvalid && t.kind != KindBool {
+		c.errorf(cond.Pos(), "non-bool %s used as %s condition", t, keyword)
+	}
+}
+
+// defaultType is the type an untyped literal gets when there is nothing else to decide it
+func defaultType(t *Type) *Type {
+	switch t.kind {
+	case KindUntypedInt:
+		return TypeInt
+	case KindUntypedFloat:
+		return TypeFloat
+	}
+	return t
+}
+
+// assignable reports whether a value of type from can be used where to is expected
+func (c *Checker) assignable(from, to *Type) bool {
+	if from == TypeInvalid || to == TypeInvalid {
+		return true // Already reported
+	}
+	if from == to {
+		return true
+	}
+	switch from.kind {
+	case KindUntypedInt:
+		return to.kind == KindInt || to.kind == KindFloat
+	case KindUntypedFloat:
+		return to.kind == KindFloat
+	}
+	return false
+}
+
+// convertUntyped gives untyped literals the type they ended up being used as
+func (c *Checker) convertUntyped(node Node, t *Type) {
+	old, ok := c.exprTypes[node]
+	if !ok || !old.isUntyped() {
+		return
+	}
+	c.exprTypes[node] = t
+	c.untyped[node] = old
+	switch n := node.(type) {
+	case *ExprNode:
+		c.convertUntyped(n.expr, t)
+	case *PrefixExprNode:
+		c.convertUntyped(n.expr, t)
+	case *BinaryExprNode:
+		c.convertUntyped(n.left, t)
+		c.convertUntyped(n.right, t)
+	}
+}
+
+// checkExpr works out the type of an expression that is being used as a value
+func (c *Checker) checkExpr(node Node, s *scope) *Type {
+	t := c.exprType(node, s)
+	if t == typeNone {
+		c.errorf(node.Pos(), "%s (no value) used as value", describeCall(node))
+		t = TypeInvalid
+	}
+	c.exprTypes[node] = t
+	return t
+}
+
+func describeCall(node Node) string {
+	if call, ok := node.(*CallExprNode); ok {
+		return formatExpr(call.fn) + "()"
+	}
+	return "expression"
+}
+
+// checkCall resolves the function being called and checks the arguments against its parameters
+func (c *Checker) checkCall(n *CallExprNode, s *scope) *Type {
+	for _, arg := range n.args {
+		c.checkExpr(arg, s)
+	}
+
+	if sel, ok := n.fn.(*SelectorNode); ok {
+		if pkg, name, ok := c.packageRef(sel, s); ok {
+			return c.checkPackageCall(n, sel, pkg, name)
+		}
+		recv := c.checkExpr(sel.expr, s)
+		if recv == TypeInvalid {
+			return TypeInvalid
+		}
+		f, ok := recv.methods[sel.name]
+		if !ok {
+			if t, isField := recv.field(sel.name); isField {
+				c.errorf(sel.namePos, "cannot call non-function %s (field of type %s)", formatExpr(sel), t)
+			} else {
+				c.errorf(sel.namePos, "%s undefined (type %s has no method %s)", formatExpr(sel), recv, sel.name)
+			}
+			return TypeInvalid
+		}
+		return c.checkArgs(n, f, formatExpr(sel))
+	}
+
+	ident, ok := n.fn.(*UnaryNode)
+	if !ok || ident.token.token != IDENT {
+		c.errorf(n.fn.Pos(), "cannot call non-function")
+		return TypeInvalid
+	}
+	name := ident.token.str
+	if t, isVar := s.lookup(name); isVar {
+		c.errorf(ident.token.pos, "cannot call non-function %s (variable of type %s)", name, t)
+		return TypeInvalid
+	}
+	f, ok := c.funcs[name]
+	if !ok {
+		c.errorf(ident.token.pos, "undefined: %s", name)
+		c.suggest(name, c.funcNames())
+		return TypeInvalid
+	}
+	return c.checkArgs(n, f, name)
+}
+
+// checkPackageCall checks a call to a function in another package. Only exported functions can be called from outside their package
+func (c *Checker) checkPackageCall(n *CallExprNode, sel *SelectorNode, pkg *Package, name string) *Type {
+	if pkg == nil {
+		return TypeInvalid // It didn't load, which has already been reported
+	}
+	c.pkgRefs[sel] = pkg
+	if f, ok := pkg.exports[sel.name]; ok {
+		return c.checkArgs(n, f, formatExpr(sel))
+	}
+	if _, ok := pkg.checker.funcs[sel.name]; ok {
+		c.errorf(sel.namePos, "%s not exported by package %s", sel.name, name)
+		return TypeInvalid
+	}
+	c.errorf(sel.namePos, "undefined: %s", formatExpr(sel))
+	names := []string{}
+	for export := range pkg.exports {
+		names = append(names, export)
+	}
+	c.suggest(sel.name, names)
+	return TypeInvalid
+}
+
+// checkArgs checks the arguments of a call against the parameters of f, and gives the type of the result
+func (c *Checker) checkArgs(n *CallExprNode, f *FuncNode, name string) *Type {
+	owner := c.owner(f)
+	params := f.arguments.(*ArgNode).args
+	if len(n.args) != len(params) {
+		c.errorf(n.pos, "wrong number of arguments in call to %s: have %d, want %d", name, len(n.args), len(params))
+	} else {
+		for i, arg := range n.args {
+			want := owner.resolveTypeQuiet(params[i].kind)
+			have := c.exprTypes[arg]
+			if !c.assignable(have, want) {
+				c.errorf(arg.Pos(), "cannot use %s as %s in argument to %s", have, want, name)
+				continue
+			}
+			c.convertUntyped(arg, want)
+		}
+	}
+
+	if f.returnType == "" {
+		return typeNone
+	}
+	return owner.resolveTypeQuiet(f.returnType)
+}
+
+func (c *Checker) exprType(node Node, s *scope) *Type {
+	switch n := node.(type) {
+	case *UnaryNode:
+		switch n.token.token {
+		case INT:
+			v, err := strconv.Atoi(n.token.str)
+			if err != nil {
+				c.errorf(n.token.pos, "constant %s overflows int", n.token.str)
+				return TypeInvalid
+			}
+			c.consts[n] = value{kind: KindInt, untyped: true, i: v}
+			return TypeUntypedInt
+		case FLOAT:
+			f, err := strconv.ParseFloat(n.token.str, 64)
+			if err != nil {
+				c.errorf(n.token.pos, "constant %s overflows float", n.token.str)
+				return TypeInvalid
+			}
+			c.consts[n] = value{kind: KindFloat, untyped: true, f: f}
+			return TypeUntypedFloat
+		case STRING:
+			return TypeString
+		case IDENT:
+			t, ok := s.lookup(n.token.str)
+			if _, isPkg := c.imports[n.token.str]; !ok && isPkg {
+				c.usedImports[n.token.str] = true
+				c.errorf(n.token.pos, "use of package %s without selector", n.token.str)
+				return TypeInvalid
+			}
+			if !ok {
+				c.errorf(n.token.pos, "undefined: %s", n.token.str)
+				c.suggest(n.token.str, s.names())
+				return TypeInvalid
+			}
+			return t
+		}
+	case *ExprNode:
+		t := c.checkExpr(n.expr, s)
+		if v, ok := c.consts[n.expr]; ok {
+			c.consts[n] = v
+		}
+		return t
+	case *PrefixExprNode:
+		t := c.checkExpr(n.expr, s)
+		if t == TypeInvalid {
+			return t
+		}
+		if n.op == OpNot && t.kind != KindBool || n.op == OpSub && !t.isNumeric() {
+			c.errorf(n.pos, "invalid operation: %s%s", n.op, t)
+			return TypeInvalid
+		}
+		if v, ok := c.consts[n.expr]; ok && n.op == OpSub {
+			if v.kind == KindInt && v.i == math.MinInt {
+				c.errorf(n.pos, "constant %s overflows int", formatExpr(n))
+				return TypeInvalid
+			}
+			v.i, v.f = -v.i, -v.f
+			c.consts[n] = v
+		}
+		return t
+	case *BinaryExprNode:
+		t := c.binaryType(n, c.checkExpr(n.left, s), c.checkExpr(n.right, s))
+		if t == TypeInvalid || !t.isNumeric() {
+			return t
+		}
+		return c.constBinary(n, t)
+	case *CallExprNode:
+		return c.checkCall(n, s)
+	case *SelectorNode:
+		if pkg, _, ok := c.packageRef(n, s); ok {
+			if pkg == nil {
+				return TypeInvalid
+			}
+			if _, ok := pkg.checker.funcs[n.name]; ok {
+				c.errorf(n.namePos, "function %s must be called", formatExpr(n))
+			} else {
+				c.errorf(n.namePos, "undefined: %s", formatExpr(n))
+			}
+			return TypeInvalid
+		}
+		t := c.checkExpr(n.expr, s)
+		if t == TypeInvalid {
+			return t
+		}
+		if ft, ok := t.field(n.name); ok {
+			return ft
+		}
+		if _, ok := t.methods[n.name]; ok {
+			c.errorf(n.namePos, "method %s must be called", formatExpr(n))
+		} else {
+			c.errorf(n.namePos, "%s undefined (type %s has no field or method %s)", formatExpr(n), t, n.name)
+		}
+		return TypeInvalid
+	case *CompositeLitNode:
+		return c.checkCompositeLit(n, s)
+	case *BadNode:
+		return TypeInvalid
+	}
+	c.errorf(Position{}, "unexpected %T in expression", node)
+	return TypeInvalid
+}
+
+func (c *Checker) checkCompositeLit(n *CompositeLitNode, s *scope) *Type {
+	t := c.resolveType(n.kind, n.pos)
+	if t != TypeInvalid && t.kind != KindStruct {
+		c.errorf(n.pos, "invalid composite literal type %s", t)
+		t = TypeInvalid
+	}
+
+	keyed := len(n.fields) > 0 && n.fields[0].name != ""
+	seen := make(map[string]bool)
+	for i, f := range n.fields {
+		et := c.checkExpr(f.expr, s)
+		if t == TypeInvalid {
+			continue
+		}
+		if (f.name != "") != keyed {
+			pos := f.expr.Pos()
+			if f.name != "" {
+				pos = f.namePos
+			}
+			c.errorf(pos, "mixture of field:value and value elements in struct literal")
+			return TypeInvalid
+		}
+
+		var want *Type
+		if keyed {
+			ft, ok := t.field(f.name)
+			if !ok {
+				c.errorf(f.namePos, "unknown field %s in struct literal of type %s", f.name, t)
+				continue
+			}
+			if seen[f.name] {
+				c.errorf(f.namePos, "duplicate field name %s in struct literal", f.name)
+				continue
+			}
+			seen[f.name] = true
+			want = ft
+		} else {
+			if i >= len(t.fields) {
+				c.errorf(f.expr.Pos(), "too many values in struct literal of type %s", t)
+				return TypeInvalid
+			}
+			want = t.fields[i].t
+		}
+		if !c.assignable(et, want) {
+			c.errorf(f.expr.Pos(), "cannot use %s as %s in struct literal", et, want)
+			continue
+		}
+		c.convertUntyped(f.expr, want)
+	}
+	if t != TypeInvalid && !keyed && len(n.fields) > 0 && len(n.fields) < len(t.fields) {
+		c.errorf(n.rbrace, "too few values in struct literal of type %s", t)
+	}
+	return t
+}
+
+// constBinary reports a division by a constant zero, and works out operations on two constants so that ones that overflow can be reported. Go doesn't allow either
+func (c *Checker) constBinary(n *BinaryExprNode, t *Type) *Type {
+	b, ok := c.consts[n.right]
+	if ok && n.op == OpDiv && (b.kind == KindInt && b.i == 0 || b.kind == KindFloat && b.f == 0) {
+		c.errorf(n.pos, "invalid operation: division by zero")
+		return TypeInvalid
+	}
+	a, ok2 := c.consts[n.left]
+	if !ok || !ok2 || n.op < OpAdd || n.op > OpDiv {
+		return t
+	}
+	v, err := binary(n.pos, n.op, a, b)
+	switch {
+	case err != nil:
+		return t
+	case v.kind == KindInt && a.kind == KindInt && b.kind == KindInt && overflows(n.op, a.i, b.i, v.i):
+		c.errorf(n.pos, "constant %s overflows int", formatExpr(n))
+		return TypeInvalid
+	case v.kind == KindFloat && math.IsInf(v.f, 0):
+		c.errorf(n.pos, "constant %s overflows float", formatExpr(n))
+		return TypeInvalid
+	}
+	c.consts[n] = v
+	return t
+}
+
+func (c *Checker) binaryType(n *BinaryExprNode, left, right *Type) *Type {
+	if left == TypeInvalid || right == TypeInvalid {
+		return TypeInvalid
+	}
+
+	// Work out the common type of both sides. Untyped literals take on the type of the other side
+	var t *Type
+	switch {
+	case left == right:
+		t = left
+	case left.isUntyped() && right.isUntyped():
+		t = TypeUntypedFloat // One must be an int and the other a float
+	case left.isUntyped() && c.assignable(left, right):
+		t = right
+		c.convertUntyped(n.left, right)
+	case right.isUntyped() && c.assignable(right, left):
+		t = left
+		c.convertUntyped(n.right, left)
+	default:
+		c.errorf(n.pos, "mismatched types %s and %s", left, right)
+		return TypeInvalid
+	}
+
+	switch n.op {
+	case OpEql, OpNeq:
+		c.convertUntyped(n.left, defaultType(t))
+		c.convertUntyped(n.right, defaultType(t))
+		return TypeBool
+	case OpLss, OpGtr, OpLeq, OpGeq:
+		if !t.isNumeric() && t.kind != KindString {
+			c.errorf(n.pos, "operator %s not defined on %s", n.op, t)
+			return TypeInvalid
+		}
+		c.convertUntyped(n.left, defaultType(t))
+		c.convertUntyped(n.right, defaultType(t))
+		return TypeBool
+	case OpAnd, OpOr:
+		if t.kind != KindBool {
+			c.errorf(n.pos, "operator %s not defined on %s", n.op, t)
+			return TypeInvalid
+		}
+		return t
+	case OpAdd:
+		if t.kind == KindString {
+			return t
+		}
+	}
+	if !t.isNumeric() {
+		c.errorf(n.pos, "operator %s not defined on %s", n.op, t)
+		return TypeInvalid
+	}
+	return t
+}
+
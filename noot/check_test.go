@@ -0,0 +1,144 @@
+package noot
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkString(t *testing.T, src string) []Diagnostic {
+	t.Helper()
+	file, errs := parseString(t, src)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	return NewChecker().Check(file)
+}
+
+func TestCheckValid(t *testing.T) {
+	srcs := []string{
+		"type X int\nfunc F(x X) X {\n\treturn x + 1\n}\n",
+		"func F(x int, y int) int {\n\treturn -x * (y - 2) / 3\n}\n",
+		"func F(x float) float {\n\treturn x * 1.5 + 2\n}\n",
+		"func F(s string) string {\n\treturn s + \"!\"\n}\n",
+		"func F() bool {\n\treturn true\n}\n",
+		"func F() float {\n\treturn 1 + 2.5\n}\n",
+		"func F(x int) int {\n\treturn 9223372036854775806 + 1 + -9223372036854775807 / 2 - x / 1\n}\n",
+		"func F(x int) bool {\n\treturn x < 2 && !(x == 1) || false\n}\n",
+		"func F(s string) bool {\n\treturn s < \"b\"\n}\n",
+		"func F(x int) int {\n\ty := 1.5\n\tvar z float = 2\n\tz = y * z\n\tfor {\n\t\tif x > 0 {\n\t\t\tbreak\n\t\t}\n\t}\n\treturn x\n}\n",
+		"func F(x int) int {\n\tif x > 0 {\n\t\treturn 1\n\t} else {\n\t\treturn 2\n\t}\n}\n",
+		"func F(x int) int {\n\tfor {\n\t\treturn 1\n\t}\n}\n",
+		"func F(x int) int {\n\tif true {\n\t\tx := 2.5\n\t\tx = x * 2\n\t}\n\treturn x\n}\n",
+		"func F(x int) int {\n\tG(x)\n\treturn H(x, 2.5) + 1\n}\nfunc G(x int) {\n\tif x > 0 {\n\t\treturn\n\t}\n}\nfunc H(x int, y float) int {\n\treturn F(x - 1)\n}\n",
+		// Declaration order doesn't matter
+		"func F(a A) A {\n\treturn a\n}\ntype A B\ntype B int\n",
+		"type V struct {\n\tX float\n\tY float\n}\nfunc (v V) Dot(o V) float {\n\treturn v.X * o.X + v.Y * o.Y\n}\nfunc F() bool {\n\tv := V{1, 2}\n\tv.X = 3\n\treturn v.Dot(V{X: 1}) > 2 && v != V{}\n}\n",
+		"type Box struct {\n\tMin P\n\tMax P\n}\ntype P V\ntype V struct {\n\tX int\n}\nfunc F(b Box) int {\n\tb.Max.X = b.Min.X + 1\n\treturn b.Max.X\n}\n",
+	}
+	for _, src := range srcs {
+		if errs := checkString(t, src); len(errs) != 0 {
+			t.Errorf("%q: unexpected errors: %v", src, errs)
+		}
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"func F(x int) int {\n\treturn y\n}\n", "2:9: undefined: y"},
+		{"func F(x Z) int {\n\treturn 1\n}\n", "1:10: undefined type: Z"},
+		{"func F(x int) Z {\n\treturn 1\n}\n", "1:15: undefined type: Z"},
+		{"type X int\nfunc F(x X, y int) int {\n\treturn x + y\n}\n", "3:11: mismatched types X and int"},
+		{"func F(x int) int {\n\treturn x + 1.5\n}\n", "2:11: mismatched types int and untyped float"},
+		{"func F(x int) string {\n\treturn x\n}\n", "2:2: cannot return int as string"},
+		{"func F(x int) {\n\treturn x\n}\n", "2:2: too many return values"},
+		{"func F(s string) string {\n\treturn s - s\n}\n", "2:11: operator - not defined on string"},
+		{"func F(b bool) bool {\n\treturn -b\n}\n", "2:9: invalid operation: -bool"},
+		{"func F() int {\n\treturn 99999999999999999999\n}\n", "2:9: constant 99999999999999999999 overflows int"},
+		{"func F() float {\n\treturn 1e999\n}\n", "2:9: constant 1e999 overflows float"},
+		{"func F() int {\n\treturn 9223372036854775807 + 1\n}\n", "2:29: constant 9223372036854775807 + 1 overflows int"},
+		{"func F() int {\n\treturn -(-9223372036854775807 - 1)\n}\n", "2:9: constant -(-9223372036854775807 - 1) overflows int"},
+		{"func F() float {\n\treturn 1e308 * 10\n}\n", "2:15: constant 1e308 * 10 overflows float"},
+		{"func F(x int) int {\n\treturn x / (2 - 2)\n}\n", "2:11: invalid operation: division by zero"},
+		{"func F(x float) float {\n\treturn x / 0.0\n}\n", "2:11: invalid operation: division by zero"},
+		{"func F(x int) int {\n}\n", "1:6: missing return at end of F"},
+		{"func F(x int, x int) int {\n\treturn x\n}\n", "1:15: duplicate argument x"},
+		{"func F() int {\n\treturn 1\n}\nfunc F() int {\n\treturn 2\n}\n", "4:6: F redeclared"},
+		{"type A B\ntype B A\n", "1:1: invalid recursive type A"},
+		{"type A int\ntype A struct {\n\tX int\n}\nfunc main() int {\n\treturn 1\n}\n", "2:1: A redeclared"},
+		{"type A struct {\n\tX int\n}\ntype A int\ntype B A\nfunc F(b B) int {\n\treturn b.X\n}\n", "4:1: A redeclared"},
+		{"func F(x int) int {\n\tif x {\n\t}\n\treturn x\n}\n", "2:5: non-bool int used as if condition"},
+		{"func F(x int) int {\n\tfor x + 1 {\n\t}\n\treturn x\n}\n", "2:6: non-bool int used as for condition"},
+		{"func F(x int) bool {\n\treturn x && true\n}\n", "2:11: mismatched types int and bool"},
+		{"func F(x bool) bool {\n\treturn x < true\n}\n", "2:11: operator < not defined on bool"},
+		{"func F(x int) int {\n\treturn !x\n}\n", "2:9: invalid operation: !int"},
+		{"func F(x int) int {\n\tx := 1\n\treturn x\n}\n", "2:2: x redeclared in this block"},
+		{"func F(x int) int {\n\ty = 1\n\treturn x\n}\n", "2:2: undefined: y"},
+		{"func F(x int) int {\n\tx = \"a\"\n\treturn x\n}\n", "2:2: cannot assign string to x of type int"},
+		{"func F(x int) int {\n\tvar y string = x\n\treturn x\n}\n", "2:2: cannot use int as string in variable declaration"},
+		{"func F(x int) int {\n\ttrue = false\n\treturn x\n}\n", "2:2: cannot assign to true"},
+		{"func F(x int) int {\n\tbreak\n\treturn x\n}\n", "2:2: break is not in a loop"},
+		{"func F(x int) int {\n\tif x > 1 {\n\t\treturn 1\n\t}\n}\n", "1:6: missing return at end of F"},
+		{"func F(x int) int {\n\tfor {\n\t\tbreak\n\t}\n}\n", "1:6: missing return at end of F"},
+		{"x := 1\n", "1:1: statement outside function body"},
+		{"func F(x int) int {\n\treturn G(x)\n}\n", "2:9: undefined: G"},
+		{"func F(x int) int {\n\treturn x(1)\n}\n", "2:9: cannot call non-function x (variable of type int)"},
+		{"func F(x int) int {\n\treturn F(x, 1)\n}\n", "2:10: wrong number of arguments in call to F: have 2, want 1"},
+		{"func F(x int) int {\n\treturn F(\"a\")\n}\n", "2:11: cannot use string as int in argument to F"},
+		{"func F(x int) int {\n\treturn G() + 1\n}\nfunc G() {\n}\n", "2:9: G() (no value) used as value"},
+		{"func F(x int) int {\n\treturn\n}\n", "2:2: not enough return values"},
+
+		// Structs and methods
+		{"type A struct {\n\tb B\n}\ntype B struct {\n\ta A\n}\n", "1:1: invalid recursive type A"},
+		{"type A struct {\n\tx int\n\tx int\n}\n", "3:2: x redeclared in struct A"},
+		{"type V struct {\n\tX int\n}\nfunc F(v V) int {\n\treturn v.Y\n}\n", "5:11: v.Y undefined (type V has no field or method Y)"},
+		{"func F(x int) int {\n\treturn x.Y\n}\n", "2:11: x.Y undefined (type int has no field or method Y)"},
+		{"type V struct {\n\tX int\n}\nfunc (v V) M() int {\n\treturn v.M\n}\n", "5:11: method v.M must be called"},
+		{"type V struct {\n\tX int\n}\nfunc F(v V) int {\n\treturn v.X()\n}\n", "5:11: cannot call non-function v.X (field of type int)"},
+		{"type V struct {\n\tX int\n}\nfunc (v V) X() int {\n\treturn 1\n}\n", "4:12: field and method with the same name X"},
+		{"type V struct {\n}\nfunc (v V) M() {\n}\nfunc (w V) M() {\n}\n", "5:12: method V.M already declared"},
+		{"type I int\nfunc (i I) M() {\n}\n", "2:9: invalid receiver type I (methods can only be declared on struct types)"},
+		{"func (a int, b int) M() {\n}\n", "1:6: method M must have exactly one receiver"},
+		{"type V struct {\n\tX int\n}\nfunc F(v V) int {\n\tv.X = true\n\treturn 1\n}\n", "5:2: cannot assign bool to v.X of type int"},
+		{"type V struct {\n\tX int\n}\nfunc F() V {\n\tF().X = 1\n\treturn V{}\n}\n", "5:2: cannot assign to F().X"},
+		{"type V struct {\n\tX int\n}\nfunc F() V {\n\treturn V{Y: 1}\n}\n", "5:11: unknown field Y in struct literal of type V"},
+		{"type V struct {\n\tX int\n}\nfunc F() V {\n\treturn V{X: 1, X: 2}\n}\n", "5:17: duplicate field name X in struct literal"},
+		{"type V struct {\n\tX int\n\tY int\n}\nfunc F() V {\n\treturn V{1, Y: 2}\n}\n", "6:14: mixture of field:value and value elements in struct literal"},
+		{"type V struct {\n\tX int\n\tY int\n}\nfunc F() V {\n\treturn V{1}\n}\n", "6:12: too few values in struct literal of type V"},
+		{"type V struct {\n\tX int\n}\nfunc F() V {\n\treturn V{1, 2}\n}\n", "5:14: too many values in struct literal of type V"},
+		{"type V struct {\n\tX int\n}\nfunc F() V {\n\treturn V{true}\n}\n", "5:11: cannot use bool as int in struct literal"},
+		{"func F() int {\n\treturn int{}\n}\n", "2:9: invalid composite literal type int"},
+		{"type V struct {\n\tX int\n}\nfunc F(a V, b V) bool {\n\treturn a < b\n}\n", "5:11: operator < not defined on V"},
+	}
+	for _, test := range tests {
+		errs := checkString(t, test.src)
+		if len(errs) == 0 {
+			t.Errorf("%q: expected error %s", test.src, test.want)
+			continue
+		}
+		if got := errs[0].Error(); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestCheckReportsEveryError(t *testing.T) {
+	errs := checkString(t, `func F(x int) int {
+	return a + b
+}
+
+func G(x Q) string {
+	return x
+}
+`)
+	msgs := []string{}
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	want := "2:9: undefined: a | 2:13: undefined: b | 5:10: undefined type: Q"
+	if got := strings.Join(msgs, " | "); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
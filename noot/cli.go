@@ -0,0 +1,510 @@
+// Adapted from: https://github.com/aaronraff/blog-code/blob/master/how-to-write-a-lexer-in-go/lexer.go
+
+// Run this: go run ./cmd/noot ast input.test > output.dot && dot -Tpdf output.dot > output.pdf
+// Or run a program: go run ./cmd/noot run file.test [args]
+
+package noot
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const usage = `usage: noot <command> [arguments]
+
+commands:
+  tokens FILE                          print the tokens in FILE
+  ast [--format=dot|json|sexpr] FILE   print the syntax tree of FILE
+  check FILE                           report parse and type errors in FILE
+  fmt [-d] [-w] FILE...                format FILEs, printing the result or with -d a diff, -w writes it back
+  run FILE [args]                      run the main function in FILE and print its result
+  opt [--passes=LIST] [--dot=DIR] FILE optimise FILE and print the result, --dot writes each pass's trees to DIR
+  go [--package=NAME] FILE             translate FILE to Go source
+  wasm FILE                            compile FILE to a WebAssembly module, written to stdout
+  repl                                 start an interactive session
+  lsp                                  run a language server on stdin and stdout
+
+FILE may be - to read from stdin. Packages that FILE imports are found relative to the directory it is in.
+`
+
+// Exit codes: 1 means the input had problems (diagnostics or a runtime error), 2 means noot was used wrong
+const (
+	exitOk = 0
+	exitDiagnostics = 1
+	exitUsage = 2
+)
+
+// Main runs the noot command with the arguments after the program name and returns the exit code
+func Main(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	cmd := args[0]
+	args = args[1:]
+	var code int
+	switch cmd {
+	case "tokens":
+		code = cmdTokens(args)
+	case "ast":
+		code = cmdAst(args)
+	case "check":
+		code = cmdCheck(args)
+	case "fmt":
+		code = cmdFmt(args)
+	case "run":
+		code = cmdRun(args)
+	case "opt":
+		code = cmdOpt(args)
+	case "go":
+		code = cmdGo(args)
+	case "wasm":
+		code = cmdWasm(args)
+	case "repl":
+		if err := NewRepl(os.Stdout).Run(os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = exitDiagnostics
+		}
+	case "lsp":
+		if err := NewLSPServer(os.Stdin, os.Stdout).Serve(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = exitDiagnostics
+		}
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "noot: unknown command %q\n\n%s", cmd, usage)
+		code = exitUsage
+	}
+	return code
+}
+
+// source is a loaded input file. name is what diagnostics are reported against
+type source struct {
+	name string
+	data []byte
+	files map[string]*SourceFile // The files of the packages it imports, once it has been checked
+}
+
+// readSource reads the file, or stdin if the filename is -
+func readSource(filename string) (*source, error) {
+	if filename == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		return &source{name: "<stdin>", data: data}, err
+	}
+	data, err := os.ReadFile(filename)
+	return &source{name: filename, data: data}, err
+}
+
+func (s *source) tokens() []PackedToken {
+	return lexAll(bytes.NewReader(s.data))
+}
+
+func (s *source) parse() (*FileNode, []Diagnostic) {
+	parser := Parser{}
+	return parser.ParseFile(s.name, NewLexStream(NewByteLexer(s.data)))
+}
+
+// check parses and type checks the source. The checker only runs if the parse was clean. Programs that import packages are loaded from the directory the file is in and linked into one file
+func (s *source) check() (*FileNode, *Checker, []Diagnostic) {
+	file, errs := s.parse()
+	checker := NewChecker()
+	if len(errs) > 0 || !hasImports(file) {
+		if len(errs) == 0 {
+			errs = checker.Check(file)
+		}
+		return file, checker, errs
+	}
+
+	loader := NewLoader(filepath.Dir(s.name))
+	pkg, errs := loader.LoadFile(s.name, s.data)
+	s.files = loader.sources
+	if len(errs) > 0 {
+		return file, checker, errs
+	}
+	return Link(pkg)
+}
+
+func hasImports(file *FileNode) bool {
+	for _, node := range file.nodes {
+		if _, ok := node.(*ImportNode); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// report prints the diagnostics with the lines they are on, in colour if stderr is a terminal
+func (s *source) report(errs []Diagnostic) {
+	color := UseColor(os.Stderr)
+	main := NewSourceFile(s.name, s.data)
+	for _, d := range errs {
+		f := main
+		if imported, ok := s.files[d.file]; ok {
+			f = imported
+		}
+		NewRenderer(f, color).Render(os.Stderr, d)
+	}
+}
+
+// oneFile loads the single FILE argument that most commands take
+func oneFile(cmd string, args []string) (*source, bool) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: noot %s FILE\n", cmd)
+		return nil, false
+	}
+	src, err := readSource(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+	return src, true
+}
+
+func cmdTokens(args []string) int {
+	src, ok := oneFile("tokens", args)
+	if !ok {
+		return exitUsage
+	}
+
+	if !printTokens(os.Stdout, src.tokens()) {
+		return exitDiagnostics
+	}
+	return exitOk
+}
+
+// printTokens writes one token per line. It reports false if any of them were ILLEGAL
+func printTokens(w io.Writer, tokens []PackedToken) bool {
+	ok := true
+	for _, t := range tokens {
+		fmt.Fprintf(w, "%d:%d\t%s\t%s\n", t.pos.line, t.pos.column, t.token, t.str)
+		if t.token == ILLEGAL {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func cmdAst(args []string) int {
+	flags := flag.NewFlagSet("ast", flag.ContinueOnError)
+	format := flags.String("format", "dot", "output format: dot, json or sexpr")
+	files, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	src, ok := oneFile("ast [--format=dot|json|sexpr]", files)
+	if !ok {
+		return exitUsage
+	}
+
+	// Broken files still print whatever could be parsed
+	if *format != "dot" && *format != "json" && *format != "sexpr" {
+		fmt.Fprintf(os.Stderr, "noot: unknown format %q\n", *format)
+		return exitUsage
+	}
+	file, errs := src.parse()
+	if err := writeAST(os.Stdout, file, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitDiagnostics
+	}
+
+	src.report(errs)
+	if len(errs) > 0 {
+		return exitDiagnostics
+	}
+	return exitOk
+}
+
+// writeAST dumps the tree in one of the formats that `noot ast` supports
+func writeAST(w io.Writer, file *FileNode, format string) error {
+	switch format {
+	case "dot":
+		buf := bytes.Buffer{}
+		WriteGraphviz(file, &buf)
+		_, err := w.Write(buf.Bytes())
+		return err
+	case "json":
+		out, err := MarshalAST(file)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	case "sexpr":
+		_, err := fmt.Fprintln(w, sexpr(file))
+		return err
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// parseInterspersed parses flags that come before or after the positional arguments, the flag package normally stops at the first positional one
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func cmdCheck(args []string) int {
+	src, ok := oneFile("check", args)
+	if !ok {
+		return exitUsage
+	}
+
+	_, _, errs := src.check()
+	src.report(errs)
+	if len(errs) > 0 {
+		return exitDiagnostics
+	}
+	return exitOk
+}
+
+func cmdFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	diff := flags.Bool("d", false, "print a diff instead of the formatted source")
+	write := flags.Bool("w", false, "write the formatted source back to the file")
+	files, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: noot fmt [-d] [-w] FILE...")
+		return exitUsage
+	}
+
+	code := exitOk
+	for _, filename := range files {
+		if *write && filename == "-" {
+			fmt.Fprintln(os.Stderr, "noot: cannot use -w with stdin")
+			return exitUsage
+		}
+		src, err := readSource(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+
+		out, errs := Format(src.data)
+		if len(errs) > 0 {
+			src.report(errs)
+			code = exitDiagnostics
+			continue
+		}
+
+		if *diff {
+			fmt.Print(unifiedDiff(src.name, src.data, out))
+		}
+		if *write && !bytes.Equal(src.data, out) {
+			if err := os.WriteFile(filename, out, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = exitDiagnostics
+			}
+		}
+		if !*diff && !*write {
+			os.Stdout.Write(out)
+		}
+	}
+	return code
+}
+
+func cmdRun(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: noot run FILE [args]")
+		return exitUsage
+	}
+	src, ok := oneFile("run", args[:1])
+	if !ok {
+		return exitUsage
+	}
+
+	file, checker, errs := src.check()
+	src.report(errs)
+	if len(errs) > 0 {
+		return exitDiagnostics
+	}
+
+	entry, ok := checker.funcs["main"]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: no main function\n", src.name)
+		return exitDiagnostics
+	}
+	if err := runMain(file, checker, entry, args[1:]); err != nil {
+		switch err := err.(type) {
+		case *RuntimeError:
+			src.report([]Diagnostic{err.Diagnostic()})
+		case Diagnostics:
+			src.report(err)
+		default:
+			fmt.Fprintf(os.Stderr, "%s:%s\n", src.name, err)
+		}
+		return exitDiagnostics
+	}
+	return exitOk
+}
+
+func cmdOpt(args []string) int {
+	flags := flag.NewFlagSet("opt", flag.ContinueOnError)
+	list := flags.String("passes", strings.Join(PassNames(), ","), "comma separated passes to run, in order")
+	dir := flags.String("dot", "", "directory to write a graphviz file of the tree before and after each pass to")
+	files, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	src, ok := oneFile("opt [--passes=LIST] [--dot=DIR]", files)
+	if !ok {
+		return exitUsage
+	}
+
+	file, checker, errs := src.check()
+	src.report(errs)
+	if len(errs) > 0 {
+		return exitDiagnostics
+	}
+
+	o := NewOptimizer(checker)
+	names := []string{}
+	if *list != "" {
+		names = strings.Split(*list, ",")
+	}
+	if err := o.SetPasses(names); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	// Files are numbered so that they list in the order the passes ran: 1-fold-before.dot, 1-fold-after.dot, 2-...
+	var writeErr error
+	if *dir != "" {
+		if err := os.MkdirAll(*dir, 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitDiagnostics
+		}
+		step := 0
+		o.SetTrace(func(pass string, after bool, file *FileNode) {
+			stage := "after"
+			if !after {
+				stage = "before"
+				step++
+			}
+			buf := bytes.Buffer{}
+			WriteGraphviz(file, &buf)
+			path := filepath.Join(*dir, fmt.Sprintf("%d-%s-%s.dot", step, pass, stage))
+			if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil && writeErr == nil {
+				writeErr = err
+			}
+		})
+	}
+	o.Optimize(file)
+	if writeErr != nil {
+		fmt.Fprintln(os.Stderr, writeErr)
+		return exitDiagnostics
+	}
+	os.Stdout.Write(formatFile(file))
+	return exitOk
+}
+
+func cmdGo(args []string) int {
+	flags := flag.NewFlagSet("go", flag.ContinueOnError)
+	pkg := flags.String("package", "main", "package name of the generated file")
+	files, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	src, ok := oneFile("go [--package=NAME]", files)
+	if !ok {
+		return exitUsage
+	}
+
+	file, _, errs := src.check()
+	src.report(errs)
+	if len(errs) > 0 {
+		return exitDiagnostics
+	}
+	out, err := GenerateGo(file, *pkg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", src.name, err)
+		return exitDiagnostics
+	}
+	os.Stdout.Write(out)
+	return exitOk
+}
+
+func cmdWasm(args []string) int {
+	src, ok := oneFile("wasm", args)
+	if !ok {
+		return exitUsage
+	}
+
+	file, checker, errs := src.check()
+	if len(errs) == 0 {
+		var out []byte
+		out, errs = GenerateWasm(file, checker)
+		if len(errs) == 0 {
+			os.Stdout.Write(out)
+			return exitOk
+		}
+	}
+	src.report(errs)
+	return exitDiagnostics
+}
+
+// runMain runs the file's main function and prints whatever it returns. The command line arguments are converted to main's parameter types
+func runMain(file *FileNode, checker *Checker, entry *FuncNode, args []string) error {
+	params := entry.arguments.(*ArgNode).args
+	if len(args) != len(params) {
+		return fmt.Errorf("%s: main expects %d arguments, got %d", entry.pos, len(params), len(args))
+	}
+	program, errs := compileChecked(file, checker, nil)
+	if len(errs) > 0 {
+		return Diagnostics(errs)
+	}
+	vm := NewVM(program)
+
+	values := make([]int, len(args))
+	for i := range args {
+		v, err := parseArg(vm, args[i], checker.types[params[i].kind])
+		if err != nil {
+			return fmt.Errorf("%s: argument %s: %v", params[i].pos, params[i].name, err)
+		}
+		values[i] = v
+	}
+	results, err := vm.call("main", values)
+	if err != nil {
+		return err
+	}
+
+	if entry.returnType != "" {
+		fmt.Println(fromVMSlots(results, checker.types[entry.returnType], vm.strings))
+	}
+	return nil
+}
+
+// parseArg converts a command line argument to a VM value of type t. Strings are added to vm's strings
+func parseArg(vm *VM, arg string, t *Type) (int, error) {
+	switch t.kind {
+	case KindString:
+		return vm.intern(arg), nil
+	case KindInt:
+		return strconv.Atoi(arg)
+	case KindFloat:
+		f, err := strconv.ParseFloat(arg, 64)
+		return floatToValue(f), err
+	case KindBool:
+		b, err := strconv.ParseBool(arg)
+		return boolToInt(b), err
+	}
+	return 0, fmt.Errorf("%s arguments are not supported", t)
+}
@@ -0,0 +1,12 @@
+// The noot command. See noot.Main for what it can do
+package main
+
+import (
+	"os"
+
+	"github.com/unitoftime/experiments/noot"
+)
+
+func main() {
+	os.Exit(noot.Main(os.Args[1:]))
+}
@@ -0,0 +1,682 @@
+package noot
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// --------------------------------------------------------------------------------
+// - Bytecode Compiler
+// --------------------------------------------------------------------------------
+// Compiles a parsed (and ideally type checked) FileNode into a Program for the VM. Without the checker's types everything is compiled as an int.
+// Structs are laid out flat: a struct value takes one slot for every field of a builtin type, in declaration order, and nested structs are inlined. Loading or storing a struct moves all of its slots, so structs are copied like they are in Go. Methods are functions that take the receiver's slots before their arguments.
+
+type compiler struct {
+	program *Program
+	checker *Checker
+	types map[Node]*Type
+	untyped map[Node]*Type
+	intConst bool // Compiling an untyped int constant that is used as a float, which is worked out with ints
+	chunk *Chunk
+	scopes []map[string]uint16 // Local slots of each block we are inside, innermost last
+	loops []*loop
+	funcs map[*FuncNode]int // Index of every function and method in program.funcs
+	origins map[Node]string
+	file string // Where the declaration being compiled came from, if it was linked in
+	errors []Diagnostic
+}
+
+// maxOperand is the largest value a uint16 operand can hold
+const maxOperand = 0xFFFF
+
+// loop tracks the jumps of the loop being compiled. continue jumps straight back to the start, breaks have to be patched once the end of the loop is known
+type loop struct {
+	start int
+	breaks []int
+}
+
+func (c *compiler) errorf(pos Position, format string, args ...any) {
+	c.errors = append(c.errors, Diagnostic{file: c.file, pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+// Compile compiles every function in the file. Functions are numbered in the order they are declared
+func Compile(file *FileNode) (*Program, []Diagnostic) {
+	return compileChecked(file, nil, nil)
+}
+
+// compileChecked compiles a file that has been through the checker, so floats get float instructions and structs can be laid out. Calls to functions that aren't in the file go to hosts
+func compileChecked(file *FileNode, checker *Checker, hosts []*hostFunc) (*Program, []Diagnostic) {
+	c := compiler{
+		program: &Program{
+			index: make(map[string]int),
+			hosts: hosts,
+			strings: []string{""},
+		},
+		checker: checker,
+		funcs: make(map[*FuncNode]int),
+		origins: file.origins,
+	}
+	if checker != nil {
+		c.types = checker.exprTypes
+		c.untyped = checker.untyped
+	} else {
+		// Without types nothing says how big a struct is
+		for _, node := range file.nodes {
+			if n, ok := node.(*TypeNode); ok && structTypes(file)[n.name] {
+				c.file = c.origins[n]
+				c.errorf(n.pos, "struct type %s can only be compiled after type checking", n.name)
+			}
+		}
+		if len(c.errors) > 0 {
+			return c.program, c.errors
+		}
+	}
+
+	// Number the functions up front so that calls can refer to functions declared later in the file
+	funcs := []*FuncNode{}
+	for _, node := range file.nodes {
+		if f, ok := node.(*FuncNode); ok {
+			if f.recv == nil {
+				c.program.index[f.funcName] = len(funcs)
+			}
+			c.funcs[f] = len(funcs)
+			funcs = append(funcs, f)
+		}
+	}
+
+	for _, f := range funcs {
+		c.program.funcs = append(c.program.funcs, c.compileFunc(f))
+	}
+	return c.program, c.errors
+}
+
+// structTypes finds the declared types that are structs, directly or through another declared type
+func structTypes(file *FileNode) map[string]bool {
+	decls := make(map[string]*TypeNode)
+	for _, node := range file.nodes {
+		if n, ok := node.(*TypeNode); ok {
+			decls[n.name] = n
+		}
+	}
+	structs := make(map[string]bool)
+	for name, n := range decls {
+		for i := 0; n != nil && i <= len(decls); i++ {
+			if n.isStruct() {
+				structs[name] = true
+				break
+			}
+			n = decls[n.kind]
+		}
+	}
+	return structs
+}
+
+// typeNamed resolves a type name, or gives nil without a checker
+func (c *compiler) typeNamed(name string) *Type {
+	if c.checker == nil || name == "" {
+		return nil
+	}
+	return c.checker.resolveTypeQuiet(name)
+}
+
+// slots is how many slots a value of type t takes up. Everything but a struct takes one, including the types we know nothing about
+func slots(t *Type) int {
+	if t == nil || t.kind != KindStruct {
+		return 1
+	}
+	n := 0
+	for _, f := range t.fields {
+		n += slots(f.t)
+	}
+	return n
+}
+
+// leaves gives the type of each slot of a value of type t
+func leaves(t *Type, types []*Type) []*Type {
+	if t == nil || t.kind != KindStruct {
+		return append(types, t)
+	}
+	for _, f := range t.fields {
+		types = leaves(f.t, types)
+	}
+	return types
+}
+
+// fieldSlot gives where a field starts within the slots of its struct, and its type
+func fieldSlot(t *Type, name string) (int, *Type) {
+	offset := 0
+	for _, f := range t.fields {
+		if f.name == name {
+			return offset, f.t
+		}
+		offset += slots(f.t)
+	}
+	return 0, nil
+}
+
+func (c *compiler) compileFunc(f *FuncNode) *Chunk {
+	c.file = c.origins[f]
+	c.chunk = &Chunk{
+		name: f.funcName,
+		file: c.file,
+		results: 1,
+	}
+	c.scopes = []map[string]uint16{make(map[string]uint16)}
+	params := f.arguments.(*ArgNode).args
+	if f.recv != nil {
+		c.chunk.name = f.recv.args[0].kind + "." + f.funcName
+		params = append(append([]Arg{}, f.recv.args...), params...)
+	}
+	for i := range params {
+		c.declare(params[i].name, slots(c.typeNamed(params[i].kind)))
+	}
+	c.chunk.arity = c.chunk.numLocals
+	if f.returnType != "" {
+		c.chunk.results = slots(c.typeNamed(f.returnType))
+	}
+
+	// The body shares the arguments' scope, like in Go
+	if body, ok := f.body.(*CurlyScope); ok {
+		for i := range body.nodes {
+			c.compileStmt(body.nodes[i])
+		}
+	}
+
+	// Functions with no return type can fall off the end, everything else is caught by the type checker. The VM always wants something to return though
+	if body, ok := f.body.(*CurlyScope); !ok || !terminates(body) {
+		c.zero(f.pos, c.chunk.results)
+		c.chunk.emit(f.pos, byte(CodeReturn))
+	}
+
+	// Slots, constant indices and jump targets are uint16 operands, which would wrap around rather than fail
+	switch {
+	case c.chunk.numLocals > maxOperand+1:
+		c.errorf(f.pos, "too many local variables in %s: the VM can address at most %d slots", c.chunk.name, maxOperand+1)
+	case len(c.chunk.constants) > maxOperand+1:
+		c.errorf(f.pos, "too many constants in %s: the VM can hold at most %d", c.chunk.name, maxOperand+1)
+	case len(c.chunk.code) > maxOperand:
+		c.errorf(f.pos, "%s is too long: the VM can jump within at most %d bytes of code", c.chunk.name, maxOperand)
+	}
+	return c.chunk
+}
+
+// reserve sets aside n slots for locals. Slots aren't reused when a block ends, so numLocals is the total size of every variable in the function
+func (c *compiler) reserve(n int) uint16 {
+	slot := uint16(c.chunk.numLocals)
+	c.chunk.numLocals += n
+	return slot
+}
+
+// declare gives a new variable the n slots it needs, and returns the first
+func (c *compiler) declare(name string, n int) uint16 {
+	slot := c.reserve(n)
+	c.scopes[len(c.scopes)-1][name] = slot
+	return slot
+}
+
+// load pushes the n slots starting at slot
+func (c *compiler) load(pos Position, slot uint16, n int) {
+	for i := 0; i < n; i++ {
+		c.chunk.emitArg(pos, CodeLoad, slot+uint16(i))
+	}
+}
+
+// store pops n values into the slots starting at slot. The last slot is on top of the stack
+func (c *compiler) store(pos Position, slot uint16, n int) {
+	for i := n - 1; i >= 0; i-- {
+		c.chunk.emitArg(pos, CodeStore, slot+uint16(i))
+	}
+}
+
+// zero pushes n zeros, which is the zero value of anything that takes n slots
+func (c *compiler) zero(pos Position, n int) {
+	for i := 0; i < n; i++ {
+		c.chunk.emitArg(pos, CodeConst, c.chunk.addConstant(0))
+	}
+}
+
+func (c *compiler) resolve(name string) (uint16, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if slot, ok := c.scopes[i][name]; ok {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+// emitJump emits a jump with a placeholder target and returns the offset of the operand to patch
+func (c *compiler) emitJump(pos Position, op Opcode) int {
+	return c.chunk.emitArg(pos, op, 0xFFFF) + 1
+}
+
+// here returns the offset of the next instruction, as a jump target
+func (c *compiler) here() uint16 {
+	return uint16(len(c.chunk.code))
+}
+
+func (c *compiler) compileStmt(node Node) {
+	switch n := node.(type) {
+	case *CurlyScope:
+		c.scopes = append(c.scopes, make(map[string]uint16))
+		for i := range n.nodes {
+			c.compileStmt(n.nodes[i])
+		}
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	case *ReturnNode:
+		if n.expr != nil {
+			c.compileExpr(n.expr)
+		} else {
+			c.zero(n.pos, c.chunk.results)
+		}
+		c.chunk.emit(n.pos, byte(CodeReturn))
+	case *ExprStmtNode:
+		c.compileExpr(n.expr)
+		for i := slots(c.types[n.expr]); i > 0; i-- {
+			c.chunk.emit(n.Pos(), byte(CodePop))
+		}
+	case *VarNode:
+		t := c.typeNamed(n.kind)
+		if n.kind == "" {
+			t = c.types[n.expr]
+		}
+		if n.expr != nil {
+			c.compileExpr(n.expr)
+		} else {
+			c.zero(n.pos, slots(t))
+		}
+		// Declared after the expression so that `x := x + 1` in a new block reads the outer x
+		c.store(n.pos, c.declare(n.name, slots(t)), slots(t))
+	case *AssignNode:
+		c.compileExpr(n.expr)
+		slot, ok := c.resolve(n.name)
+		if !ok {
+			c.errorf(n.pos, "undefined: %s", n.name)
+		}
+		c.store(n.pos, slot, slots(c.types[n.expr]))
+	case *IfNode:
+		c.compileExpr(n.cond)
+		skipThen := c.emitJump(n.pos, CodeJumpFalse)
+		c.compileStmt(n.then)
+		if n.els == nil {
+			c.chunk.patch(skipThen, c.here())
+			return
+		}
+		skipElse := c.emitJump(n.pos, CodeJump)
+		c.chunk.patch(skipThen, c.here())
+		c.compileStmt(n.els)
+		c.chunk.patch(skipElse, c.here())
+	case *ForNode:
+		l := &loop{start: len(c.chunk.code)}
+		c.loops = append(c.loops, l)
+		if n.cond != nil {
+			c.compileExpr(n.cond)
+			l.breaks = append(l.breaks, c.emitJump(n.pos, CodeJumpFalse))
+		}
+		c.compileStmt(n.body)
+		c.chunk.emitArg(n.pos, CodeJump, uint16(l.start))
+		for _, b := range l.breaks {
+			c.chunk.patch(b, c.here())
+		}
+		c.loops = c.loops[:len(c.loops)-1]
+	case *BranchNode:
+		if len(c.loops) == 0 {
+			c.errorf(n.pos, "%s is not in a loop", n.keyword)
+			return
+		}
+		l := c.loops[len(c.loops)-1]
+		if n.keyword == "continue" {
+			c.chunk.emitArg(n.pos, CodeJump, uint16(l.start))
+		} else {
+			l.breaks = append(l.breaks, c.emitJump(n.pos, CodeJump))
+		}
+	case *FieldAssignNode:
+		c.compileExpr(n.expr)
+		slot, t, ok := c.fieldAddr(n.target)
+		if !ok {
+			c.errorf(n.Pos(), "cannot assign to %s", formatExpr(n.target))
+			return
+		}
+		c.store(n.target.namePos, slot, slots(t))
+	default:
+		c.errorf(node.Pos(), "cannot compile %T", node)
+	}
+}
+
+// fieldAddr finds the slots of a field of a variable, like v.a.b, and gives the first one along with the field's type. It fails for fields of anything but a variable, such as a call's result
+func (c *compiler) fieldAddr(sel *SelectorNode) (uint16, *Type, bool) {
+	var slot uint16
+	switch x := sel.expr.(type) {
+	case *UnaryNode:
+		s, ok := c.resolve(x.token.str)
+		if x.token.token != IDENT || !ok {
+			return 0, nil, false
+		}
+		slot = s
+	case *SelectorNode:
+		s, _, ok := c.fieldAddr(x)
+		if !ok {
+			return 0, nil, false
+		}
+		slot = s
+	case *ExprNode:
+		return c.fieldAddr(&SelectorNode{expr: x.expr, name: sel.name, namePos: sel.namePos})
+	default:
+		return 0, nil, false
+	}
+	t := c.types[sel.expr]
+	if t == nil || t.kind != KindStruct {
+		return 0, nil, false
+	}
+	offset, ft := fieldSlot(t, sel.name)
+	return slot + uint16(offset), ft, true
+}
+
+// isString reports whether the checker found node to be a string
+func (c *compiler) isString(node Node) bool {
+	t := c.types[node]
+	return t != nil && t.kind == KindString
+}
+
+// isFloat reports whether the checker found node to be a float
+func (c *compiler) isFloat(node Node) bool {
+	t := c.types[node]
+	return !c.intConst && t != nil && (t.kind == KindFloat || t.kind == KindUntypedFloat)
+}
+
+var floatCodes = map[Operator]Opcode{
+	OpAdd: CodeAddF,
+	OpSub: CodeSubF,
+	OpMul: CodeMulF,
+	OpDiv: CodeDivF,
+	OpEql: CodeEqF,
+	OpNeq: CodeNeqF,
+	OpLss: CodeLtF,
+	OpGtr: CodeGtF,
+	OpLeq: CodeLeF,
+	OpGeq: CodeGeF,
+}
+
+var stringCodes = map[Operator]Opcode{
+	OpAdd: CodeConcat,
+	OpEql: CodeEqS,
+	OpNeq: CodeNeqS,
+	OpLss: CodeLtS,
+	OpGtr: CodeGtS,
+	OpLeq: CodeLeS,
+	OpGeq: CodeGeS,
+}
+
+var arithCodes = map[Operator]Opcode{
+	OpAdd: CodeAdd,
+	OpSub: CodeSub,
+	OpMul: CodeMul,
+	OpDiv: CodeDiv,
+	OpEql: CodeEq,
+	OpNeq: CodeNeq,
+	OpLss: CodeLt,
+	OpGtr: CodeGt,
+	OpLeq: CodeLe,
+	OpGeq: CodeGe,
+}
+
+func (c *compiler) compileExpr(node Node) {
+	// An untyped int constant is worked out with ints even when it is used as a float, like Go, so `var x float = 1 / 2` is 0. Only the result is converted. Literals on their own are simply compiled as float constants
+	if _, lit := node.(*UnaryNode); !lit && !c.intConst && c.untyped[node] == TypeUntypedInt && c.isFloat(node) {
+		c.intConst = true
+		c.compileExpr(node)
+		c.intConst = false
+		c.chunk.emit(node.Pos(), byte(CodeIntToFloat))
+		return
+	}
+
+	switch n := node.(type) {
+	case *UnaryNode:
+		pos := n.token.pos
+		switch n.token.token {
+		case INT:
+			v, err := strconv.Atoi(n.token.str)
+			if err != nil {
+				c.errorf(n.token.pos, "invalid integer %s", n.token.str)
+			}
+			if c.isFloat(n) {
+				v = floatToValue(float64(v)) // An untyped constant used as a float
+			}
+			c.chunk.emitArg(pos, CodeConst, c.chunk.addConstant(v))
+		case FLOAT:
+			f, err := strconv.ParseFloat(n.token.str, 64)
+			if err != nil {
+				c.errorf(n.token.pos, "invalid float %s", n.token.str)
+			}
+			c.chunk.emitArg(pos, CodeConst, c.chunk.addConstant(floatToValue(f)))
+		case STRING:
+			str, err := strconv.Unquote(n.token.str)
+			if err != nil {
+				c.errorf(n.token.pos, "invalid string %s", n.token.str)
+			}
+			idx := c.program.addString(str)
+			if idx == maxOperand+1 {
+				c.errorf(pos, "too many strings: the VM can hold at most %d", maxOperand+1) // Only the first one that doesn't fit is reported
+			}
+			c.chunk.emitArg(pos, CodeString, uint16(idx))
+		case IDENT:
+			slot, ok := c.resolve(n.token.str)
+			if ok {
+				c.load(pos, slot, slots(c.types[n]))
+				return
+			}
+			switch n.token.str {
+			case "true":
+				c.chunk.emitArg(pos, CodeConst, c.chunk.addConstant(1))
+			case "false":
+				c.chunk.emitArg(pos, CodeConst, c.chunk.addConstant(0))
+			default:
+				c.errorf(n.token.pos, "undefined: %s", n.token.str)
+			}
+		default:
+			c.errorf(n.token.pos, "cannot compile %s", n.token)
+		}
+	case *ExprNode:
+		c.compileExpr(n.expr)
+	case *PrefixExprNode:
+		c.compileExpr(n.expr)
+		if n.op == OpNot {
+			c.chunk.emit(n.pos, byte(CodeNot))
+		} else if c.isFloat(n.expr) {
+			c.chunk.emit(n.pos, byte(CodeNegF))
+		} else {
+			c.chunk.emit(n.pos, byte(CodeNeg))
+		}
+	case *BinaryExprNode:
+		if n.op == OpAnd || n.op == OpOr {
+			c.compileLogical(n)
+			return
+		}
+		if t := c.types[n.left]; t != nil && t.kind == KindStruct {
+			c.compileStructEq(n, t)
+			return
+		}
+		c.compileExpr(n.left)
+		c.compileExpr(n.right)
+		if c.isString(n.left) {
+			c.chunk.emit(n.pos, byte(stringCodes[n.op]))
+		} else if c.isFloat(n.left) {
+			c.chunk.emit(n.pos, byte(floatCodes[n.op]))
+		} else {
+			c.chunk.emit(n.pos, byte(arithCodes[n.op]))
+		}
+	case *CallExprNode:
+		// A method gets its receiver before the arguments
+		argc := 0
+		var method *FuncNode
+		if sel, ok := n.fn.(*SelectorNode); ok {
+			recv := c.types[sel.expr]
+			if recv != nil {
+				method = recv.methods[sel.name]
+			}
+			if method == nil {
+				c.errorf(sel.namePos, "%s is not a method", formatExpr(sel))
+				return
+			}
+			c.compileExpr(sel.expr)
+			argc += slots(recv)
+		}
+		for i := range n.args {
+			c.compileExpr(n.args[i])
+			argc += slots(c.types[n.args[i]])
+		}
+		if method != nil {
+			c.emitCall(n.pos, CodeCall, c.funcs[method], argc)
+			return
+		}
+
+		ident, _ := n.fn.(*UnaryNode)
+		if ident == nil {
+			c.errorf(n.pos, "cannot call non-function")
+			return
+		}
+		if fn, ok := c.program.index[ident.token.str]; ok {
+			c.emitCall(n.pos, CodeCall, fn, argc)
+			return
+		}
+		for fn, host := range c.program.hosts {
+			if host.name == ident.token.str {
+				c.emitCall(n.pos, CodeCallHost, fn, argc)
+				return
+			}
+		}
+		c.errorf(ident.token.pos, "undefined: %s", ident.token.str)
+	case *SelectorNode:
+		if slot, t, ok := c.fieldAddr(n); ok {
+			c.load(n.namePos, slot, slots(t))
+			return
+		}
+		t := c.types[n.expr]
+		if t == nil || t.kind != KindStruct {
+			c.errorf(n.namePos, "%s is not a field of a struct", formatExpr(n))
+			return
+		}
+		// The field of anything but a variable, like a call's result, is picked out after storing the whole struct in a temporary
+		size := slots(t)
+		c.compileExpr(n.expr)
+		tmp := c.reserve(size)
+		c.store(n.namePos, tmp, size)
+		offset, ft := fieldSlot(t, n.name)
+		c.load(n.namePos, tmp+uint16(offset), slots(ft))
+	case *CompositeLitNode:
+		c.compileCompositeLit(n)
+	default:
+		c.errorf(node.Pos(), "cannot compile %T", node)
+	}
+}
+
+// emitCall emits a call to function fn with argc slots of arguments, which have to fit in the one byte operand
+func (c *compiler) emitCall(pos Position, op Opcode, fn int, argc int) {
+	if argc > 255 {
+		c.errorf(pos, "too many arguments: the VM can pass at most 255 values")
+	}
+	if fn > maxOperand {
+		c.errorf(pos, "too many functions: the VM can call at most %d", maxOperand+1)
+	}
+	c.chunk.emit(pos, byte(op), byte(fn), byte(fn>>8), byte(argc))
+}
+
+// compileCompositeLit pushes the fields of a struct literal in declaration order, with zeros for the fields it leaves out. Keyed fields written in a different order are evaluated in the order they are written, into a temporary, like Go does
+func (c *compiler) compileCompositeLit(n *CompositeLitNode) {
+	t := c.types[n]
+	if t == nil || t.kind != KindStruct {
+		c.errorf(n.pos, "%s is not a struct type", n.kind)
+		return
+	}
+
+	inits := make([]Node, len(t.fields))
+	ordered := true
+	last := -1
+	for i, init := range n.fields {
+		j := i
+		for k := range t.fields {
+			if init.name != "" && t.fields[k].name == init.name {
+				j = k
+			}
+		}
+		ordered = ordered && j > last
+		last = j
+		inits[j] = init.expr
+	}
+	if ordered {
+		for i, f := range t.fields {
+			if inits[i] != nil {
+				c.compileExpr(inits[i])
+			} else {
+				c.zero(n.pos, slots(f.t))
+			}
+		}
+		return
+	}
+
+	size := slots(t)
+	tmp := c.reserve(size)
+	c.zero(n.pos, size)
+	c.store(n.pos, tmp, size)
+	for _, init := range n.fields {
+		offset, ft := fieldSlot(t, init.name)
+		c.compileExpr(init.expr)
+		c.store(init.namePos, tmp+uint16(offset), slots(ft))
+	}
+	c.load(n.rbrace, tmp, size)
+}
+
+// compileStructEq compares two structs a slot at a time, floats as floats and strings by their contents. Both are stored in temporaries first so that their slots can be loaded in pairs
+//   a == b:  a; STORE x; b; STORE y; LOAD x0; LOAD y0; EQ; JUMP_FALSE differ; ...; CONST 1; JUMP end; differ: CONST 0; end:
+func (c *compiler) compileStructEq(n *BinaryExprNode, t *Type) {
+	size := slots(t)
+	c.compileExpr(n.left)
+	left := c.reserve(size)
+	c.store(n.pos, left, size)
+	c.compileExpr(n.right)
+	right := c.reserve(size)
+	c.store(n.pos, right, size)
+
+	same, differ := 1, 0
+	if n.op == OpNeq {
+		same, differ = 0, 1
+	}
+	jumps := []int{}
+	for i, leaf := range leaves(t, nil) {
+		c.chunk.emitArg(n.pos, CodeLoad, left+uint16(i))
+		c.chunk.emitArg(n.pos, CodeLoad, right+uint16(i))
+		if leaf != nil && leaf.kind == KindFloat {
+			c.chunk.emit(n.pos, byte(CodeEqF))
+		} else if leaf != nil && leaf.kind == KindString {
+			c.chunk.emit(n.pos, byte(CodeEqS))
+		} else {
+			c.chunk.emit(n.pos, byte(CodeEq))
+		}
+		jumps = append(jumps, c.emitJump(n.pos, CodeJumpFalse))
+	}
+	c.chunk.emitArg(n.pos, CodeConst, c.chunk.addConstant(same))
+	toEnd := c.emitJump(n.pos, CodeJump)
+	for _, j := range jumps {
+		c.chunk.patch(j, c.here())
+	}
+	c.chunk.emitArg(n.pos, CodeConst, c.chunk.addConstant(differ))
+	c.chunk.patch(toEnd, c.here())
+}
+
+// compileLogical compiles && and || so that the right hand side is only evaluated when it is needed
+//   a && b:  a; JUMP_FALSE short; b; JUMP end; short: CONST 0; end:
+//   a || b:  a; NOT; JUMP_FALSE short; b; JUMP end; short: CONST 1; end:
+func (c *compiler) compileLogical(n *BinaryExprNode) {
+	pos := n.pos
+	c.compileExpr(n.left)
+	short := 0
+	if n.op == OpOr {
+		c.chunk.emit(pos, byte(CodeNot))
+		short = 1
+	}
+	toShort := c.emitJump(pos, CodeJumpFalse)
+	c.compileExpr(n.right)
+	toEnd := c.emitJump(pos, CodeJump)
+	c.chunk.patch(toShort, c.here())
+	c.chunk.emitArg(pos, CodeConst, c.chunk.addConstant(short))
+	c.chunk.patch(toEnd, c.here())
+}
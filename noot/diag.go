@@ -0,0 +1,243 @@
+package noot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --------------------------------------------------------------------------------
+// - Source Files and Diagnostic Rendering
+// --------------------------------------------------------------------------------
+// Positions are lines and columns counted in runes, which is what people read. SourceFile keeps the text of a file with a table of where each line starts, so positions can be turned into byte offsets and back, and the line an error is on can be printed under it:
+//   prog.noot:3:13: error: mismatched types int and float
+//     3 |     return x + 1.5
+//       |              ^
+//       = note: ...
+
+// SourceFile is the text of a file, with the byte offset of the start of each line
+type SourceFile struct {
+	name string
+	src []byte
+	lines []int
+}
+
+func NewSourceFile(name string, src []byte) *SourceFile {
+	f := &SourceFile{name: name, src: src, lines: []int{0}}
+	for i, b := range src {
+		if b == '\n' {
+			f.lines = append(f.lines, i+1)
+		}
+	}
+	return f
+}
+
+func (f *SourceFile) Name() string {
+	return f.name
+}
+
+// LineCount is the number of lines, counting a last line without a newline
+func (f *SourceFile) LineCount() int {
+	return len(f.lines)
+}
+
+// Line gives the text of line n, counting from 1, without its line ending. Lines outside the file are empty
+func (f *SourceFile) Line(n int) string {
+	if n < 1 || n > len(f.lines) {
+		return ""
+	}
+	start, end := f.lines[n-1], len(f.src)
+	if n < len(f.lines) {
+		end = f.lines[n] - 1
+	}
+	return strings.TrimSuffix(string(f.src[start:end]), "\r")
+}
+
+// Offset is the byte offset of a position. Column 0, which inserted semicolons have, is the start of the line, and columns past the end of a line are clamped to it
+func (f *SourceFile) Offset(pos Position) int {
+	if pos.line < 1 {
+		return 0
+	}
+	if pos.line > len(f.lines) {
+		return len(f.src)
+	}
+	off := f.lines[pos.line-1]
+	for col := 1; col < pos.column && off < len(f.src) && f.src[off] != '\n'; col++ {
+		_, size := utf8.DecodeRune(f.src[off:])
+		off += size
+	}
+	return off
+}
+
+// Position is the position of a byte offset
+func (f *SourceFile) Position(offset int) Position {
+	line := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset })
+	start := f.lines[line-1]
+	if offset > len(f.src) {
+		offset = len(f.src)
+	}
+	return Position{line, utf8.RuneCount(f.src[start:offset]) + 1}
+}
+
+// tokenWidth is how many runes the token at pos covers, stopping at the end of the line. Tokens with no text of their own, like EOF, get one
+func (f *SourceFile) tokenWidth(pos Position) int {
+	off := f.Offset(pos)
+	end := len(f.src)
+	if pos.line < len(f.lines) {
+		end = f.lines[pos.line] - 1
+	}
+	span := NewByteLexer(f.src[off:end]).Scan()
+	if span.end == span.start || span.token == EOF {
+		return 1
+	}
+	return utf8.RuneCount(f.src[off : off+span.end])
+}
+
+// ----
+// Rendering
+// ----
+
+// Renderer prints diagnostics against the source file they came from
+type Renderer struct {
+	file *SourceFile
+	color bool
+}
+
+// NewRenderer makes a renderer for diagnostics in file, with ANSI colours if color is set
+func NewRenderer(file *SourceFile, color bool) *Renderer {
+	return &Renderer{file, color}
+}
+
+// UseColor reports whether w is a terminal that colours should be written to. Setting NO_COLOR turns them off
+func UseColor(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold = "\x1b[1m"
+	ansiRed = "\x1b[1;31m"
+	ansiGreen = "\x1b[1;32m"
+	ansiBlue = "\x1b[1;34m"
+)
+
+func (r *Renderer) paint(style, text string) string {
+	if !r.color {
+		return text
+	}
+	return style + text + ansiReset
+}
+
+// Render writes the diagnostic, the line it is on with the token it is at underlined, and its notes
+func (r *Renderer) Render(w io.Writer, d Diagnostic) {
+	fmt.Fprintf(w, "%s %s %s\n", r.paint(ansiBold, r.file.name+":"+d.pos.String()+":"), r.paint(ansiRed, "error:"), r.paint(ansiBold, d.msg))
+
+	if d.pos.line >= 1 && d.pos.line <= r.file.LineCount() {
+		line := r.file.Line(d.pos.line)
+		number := fmt.Sprint(d.pos.line)
+		gutter := strings.Repeat(" ", len(number))
+		fmt.Fprintf(w, "%s %s\n", r.paint(ansiBlue, number+" |"), line)
+
+		// The underline copies the tabs before it so that it lines up however wide they are
+		indent, width := []rune{}, 0
+		if d.pos.column == 0 {
+			// Only the line is known, so underline all of it
+			text := strings.TrimLeftFunc(line, unicode.IsSpace)
+			for _, c := range line[:len(line)-len(text)] {
+				indent = append(indent, c)
+			}
+			width = utf8.RuneCountInString(strings.TrimRightFunc(text, unicode.IsSpace))
+		} else {
+			for i, c := range []rune(line) {
+				if i >= d.pos.column-1 {
+					break
+				}
+				if c != '\t' {
+					c = ' '
+				}
+				indent = append(indent, c)
+			}
+			for len(indent) < d.pos.column-1 {
+				indent = append(indent, ' ') // Past the end of the line, where EOF is
+			}
+			width = r.file.tokenWidth(d.pos)
+		}
+		if width < 1 {
+			width = 1
+		}
+		underline := "^" + strings.Repeat("~", width-1)
+		fmt.Fprintf(w, "%s %s%s\n", r.paint(ansiBlue, gutter+" |"), string(indent), r.paint(ansiGreen, underline))
+	}
+
+	for _, note := range d.notes {
+		fmt.Fprintf(w, "%s %s\n", r.paint(ansiBlue, strings.Repeat(" ", len(fmt.Sprint(d.pos.line)))+" ="), r.paint(ansiBold, "note: ")+note)
+	}
+}
+
+// RenderAll renders every diagnostic, in order
+func (r *Renderer) RenderAll(w io.Writer, errs []Diagnostic) {
+	for _, d := range errs {
+		r.Render(w, d)
+	}
+}
+
+// ----
+// Hints
+// ----
+
+// closest finds the candidate that name is most likely a typo of, or "" if none are close enough
+func closest(name string, candidates []string) string {
+	best, bestDist := "", len(name)/3+1 // Short names are too much alike to guess between
+	if bestDist > 3 {
+		bestDist = 3
+	}
+	sort.Strings(candidates) // So ties always go the same way
+	for _, c := range candidates {
+		if d := editDistance(name, c); c != name && d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the number of runes that have to be inserted, deleted, changed or swapped with their neighbour to turn a into b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d := rows[i-1][j-1] + cost
+			if rows[i-1][j]+1 < d {
+				d = rows[i-1][j] + 1
+			}
+			if rows[i][j-1]+1 < d {
+				d = rows[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && rows[i-2][j-2]+1 < d {
+				d = rows[i-2][j-2] + 1
+			}
+			rows[i][j] = d
+		}
+	}
+	return rows[len(ra)][len(rb)]
+}
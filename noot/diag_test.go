@@ -0,0 +1,146 @@
+package noot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSourceFileOffsets(t *testing.T) {
+	f := NewSourceFile("f.noot", []byte("ab\r\n\tçd\n\nx"))
+	if f.LineCount() != 4 {
+		t.Errorf("got %d lines, want 4", f.LineCount())
+	}
+	lines := []string{"", "ab", "\tçd", "", "x", ""}
+	for n, want := range lines {
+		if got := f.Line(n); got != want {
+			t.Errorf("line %d: got %q, want %q", n, got, want)
+		}
+	}
+
+	tests := []struct {
+		pos Position
+		offset int
+	}{
+		{Position{1, 1}, 0},
+		{Position{1, 2}, 1},
+		{Position{2, 0}, 4},
+		{Position{2, 3}, 7}, // After the tab and the two byte ç
+		{Position{2, 4}, 8},
+		{Position{4, 1}, 10},
+		{Position{4, 2}, 11}, // Just after the end, where EOF is
+	}
+	for _, test := range tests {
+		if got := f.Offset(test.pos); got != test.offset {
+			t.Errorf("Offset(%s) = %d, want %d", test.pos, got, test.offset)
+		}
+		if test.pos.column == 0 {
+			continue
+		}
+		if got := f.Position(test.offset); got != test.pos {
+			t.Errorf("Position(%d) = %s, want %s", test.offset, got, test.pos)
+		}
+	}
+	if got := f.Offset(Position{2, 50}); got != 8 {
+		t.Errorf("a column past the end of a line should stop at its end, got %d", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	src := "func f(x int) float {\n\treturn x + 1.5\n}\n"
+	f := NewSourceFile("f.noot", []byte(src))
+	tests := []struct {
+		name string
+		d Diagnostic
+		want string
+	}{
+		{"token", Diagnostic{pos: Position{2, 2}, msg: "bad return"}, `
+f.noot:2:2: error: bad return
+2 | 	return x + 1.5
+  | 	^~~~~~
+`},
+		{"notes", Diagnostic{pos: Position{2, 13}, msg: "bad float", notes: []string{"one", "two"}}, `
+f.noot:2:13: error: bad float
+2 | 	return x + 1.5
+  | 	           ^~~
+  = note: one
+  = note: two
+`},
+		{"whole line", Diagnostic{pos: Position{2, 0}, msg: "division by zero"}, `
+f.noot:2:0: error: division by zero
+2 | 	return x + 1.5
+  | 	^~~~~~~~~~~~~~
+`},
+		{"end of line", Diagnostic{pos: Position{1, 22}, msg: "expected }"}, `
+f.noot:1:22: error: expected }
+1 | func f(x int) float {
+  |                      ^
+`},
+		{"outside the file", Diagnostic{pos: Position{9, 1}, msg: "lost"}, `
+f.noot:9:1: error: lost
+`},
+	}
+	for _, test := range tests {
+		buf := bytes.Buffer{}
+		NewRenderer(f, false).Render(&buf, test.d)
+		if got := buf.String(); got != test.want[1:] {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want[1:])
+		}
+	}
+
+	buf := bytes.Buffer{}
+	NewRenderer(f, true).Render(&buf, Diagnostic{pos: Position{2, 2}, msg: "bad return"})
+	if !strings.Contains(buf.String(), ansiRed+"error:"+ansiReset) || !strings.Contains(buf.String(), ansiGreen+"^~~~~~"+ansiReset) {
+		t.Errorf("expected colours, got %q", buf.String())
+	}
+}
+
+func TestCheckHints(t *testing.T) {
+	src := `func helper(count int) int {
+	total := count
+	totl = 1
+	fresh = 2
+	return helpr(coutn)
+}
+
+func g() flaot {
+	return 1
+}
+`
+	_, _, errs := (&source{name: "hints.noot", data: []byte(src)}).check()
+	got := []string{}
+	for _, e := range errs {
+		got = append(got, e.Error()+" "+strings.Join(e.notes, "; "))
+	}
+	want := []string{
+		"3:2: undefined: totl did you mean total?",
+		"4:2: undefined: fresh use := to declare a new variable",
+		"5:15: undefined: coutn did you mean count?",
+		"5:9: undefined: helpr did you mean helper?",
+		"8:10: undefined type: flaot did you mean float?",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"flaot", "float", 1},
+		{"héllo", "hello", 1},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+	if got := closest("x", []string{"y", "z"}); got != "" {
+		t.Errorf("short names shouldn't be guessed at, got %q", got)
+	}
+}
@@ -0,0 +1,131 @@
+package noot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// --------------------------------------------------------------------------------
+// - Diff
+// --------------------------------------------------------------------------------
+// A small line based unified diff for `noot fmt -d`. It finds the longest common subsequence of lines, which is quadratic but source files are small.
+
+const diffContext = 3
+
+// unifiedDiff returns the changes from a to b in unified diff format, or an empty string if they are the same
+func unifiedDiff(name string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Walk the table to get the edit script, one entry per line of output
+	edits := []diffEdit{}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, diffEdit{' ', x[i], i, j})
+			i++
+			j++
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, diffEdit{'-', x[i], i, j})
+			i++
+		default:
+			edits = append(edits, diffEdit{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("--- %s.orig\n+++ %s\n", name, name))
+
+	// Each hunk starts diffContext lines before a change. Changes that are close enough for their context to touch go in the same hunk
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		from := k - diffContext
+		if from < 0 {
+			from = 0
+		}
+		end := k
+		for {
+			for end < len(edits) && edits[end].op != ' ' {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].op == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		to := end + diffContext
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		countA, countB := 0, 0
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				countA++
+			}
+			if e.op != '-' {
+				countB++
+			}
+		}
+		buf.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(edits[from].a+1, countA), hunkRange(edits[from].b+1, countB)))
+		for _, e := range edits[from:to] {
+			buf.WriteString(string(e.op) + e.text + "\n")
+		}
+		k = to
+	}
+	return buf.String()
+}
+
+type diffEdit struct {
+	op byte // ' ', '-' or '+'
+	text string
+	a, b int // Number of lines of each side before this one
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
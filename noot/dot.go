@@ -0,0 +1,177 @@
+package noot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// --------------------------------------------------------------------------------
+// - Graphviz
+// --------------------------------------------------------------------------------
+// Nodes are numbered n0, n1, ... in the order they are walked, so the same tree always gives the same IDs no matter what is in the names. Every node is labelled with its line:col and filled by kind, and each function gets its own cluster.
+
+// Fill colours by kind of node
+const (
+	dotDecl = "lightblue"
+	dotStmt = "lightyellow"
+	dotExpr = "palegreen"
+	dotIdent = "white"
+	dotLiteral = "lightgrey"
+	dotBad = "salmon"
+)
+
+// WriteGraphviz writes the tree as a dot graph
+func WriteGraphviz(file *FileNode, buf *bytes.Buffer) {
+	buf.WriteString("strict digraph {\n")
+	buf.WriteString("\tnode [shape=box style=filled fillcolor=white fontname=monospace]\n")
+	buf.WriteString(fmt.Sprintf("\tfile [label=%s shape=folder]\n", dotQuote(file.filename)))
+
+	w := &dotWriter{buf: buf}
+	for i := range file.nodes {
+		Walk(&graphviz{w: w, parent: "file", indent: "\t"}, file.nodes[i])
+	}
+	buf.WriteString("}\n")
+}
+
+type dotWriter struct {
+	buf *bytes.Buffer
+	next int // Number of the next node ID
+}
+
+// graphviz is a Visitor that draws each node with an edge from its parent. Nodes that don't add anything to the picture (blocks, parens) are skipped and their children hang off the parent instead
+type graphviz struct {
+	w *dotWriter
+	parent string // ID of the node to draw edges from
+	edge string // Label for the edges to children, if any
+	indent string
+	cluster bool // This visitor is for the children of a function, so it has to close the cluster when they are done
+	clusterEdge string // The edge into the function, written once its cluster is closed
+}
+
+// add draws a node and its edge from the parent and returns a visitor for its children
+func (g *graphviz) add(node Node, label, color string) *graphviz {
+	id := g.node(node, label, color)
+	g.w.buf.WriteString(g.edgeTo(id))
+	return &graphviz{w: g.w, parent: id, indent: g.indent}
+}
+
+// node draws a node without any edges and gives its ID. The position in the label is where the node's own token is, so operators are labelled with the operator rather than their left operand
+func (g *graphviz) node(node Node, label, color string) string {
+	id := fmt.Sprintf("n%d", g.w.next)
+	g.w.next++
+
+	pos := node.Pos()
+	if n, ok := node.(*BinaryExprNode); ok {
+		pos = n.pos
+	}
+	label = dotQuote(label + "\n" + pos.String())
+	g.w.buf.WriteString(fmt.Sprintf("%s%s [label=%s fillcolor=%s]\n", g.indent, id, label, color))
+	return id
+}
+
+func (g *graphviz) edgeTo(id string) string {
+	if g.edge != "" {
+		return fmt.Sprintf("%s%s -> %s [label=%s]\n", g.indent, g.parent, id, dotQuote(g.edge))
+	}
+	return fmt.Sprintf("%s%s -> %s\n", g.indent, g.parent, id)
+}
+
+// child returns a visitor that draws edges with the given label
+func (g *graphviz) child(edge string) *graphviz {
+	return &graphviz{w: g.w, parent: g.parent, edge: edge, indent: g.indent}
+}
+
+func (g *graphviz) Visit(node Node) Visitor {
+	switch n := node.(type) {
+	case nil:
+		if g.cluster {
+			g.w.buf.WriteString(g.indent[1:] + "}\n")
+			g.w.buf.WriteString(g.clusterEdge)
+		}
+		return nil
+	case *FuncNode:
+		// Graphviz puts a node in the first cluster it is mentioned in, so the edge from the parent has to wait until the cluster is closed
+		g.w.buf.WriteString(fmt.Sprintf("%ssubgraph cluster_%d {\n", g.indent, g.w.next))
+		g.w.buf.WriteString(fmt.Sprintf("%s\tlabel=%s\n", g.indent, dotQuote("func "+n.funcName)))
+		inner := &graphviz{w: g.w, indent: g.indent + "\t"}
+		id := inner.node(n, "func "+n.funcName, dotDecl)
+		return &graphviz{w: g.w, parent: id, indent: inner.indent, cluster: true, clusterEdge: g.edgeTo(id)}
+	case *CurlyScope, *ExprNode, *ExprStmtNode:
+		return g.child(g.edge) // A copy, so that only the function's own visitor closes its cluster
+	case *ArgNode:
+		args := []string{}
+		for _, arg := range n.args {
+			args = append(args, arg.name+" "+arg.kind)
+		}
+		return g.add(n, "args: "+strings.Join(args, ", "), dotDecl)
+	case *PackageNode:
+		return g.add(n, "package "+n.name, dotDecl)
+	case *ImportNode:
+		return g.add(n, "import "+n.lit, dotDecl)
+	case *TypeNode:
+		label := "type " + n.name + " " + n.kind
+		for _, f := range n.fields {
+			label += "\n" + f.name + " " + f.kind
+		}
+		return g.add(n, label, dotDecl)
+	case *BadNode:
+		return g.add(n, "bad", dotBad)
+	case *ReturnNode:
+		return g.add(n, "return", dotStmt)
+	case *VarNode:
+		if n.short {
+			return g.add(n, n.name+" :=", dotStmt)
+		}
+		return g.add(n, strings.TrimSpace("var "+n.name+" "+n.kind), dotStmt)
+	case *AssignNode:
+		return g.add(n, n.name+" =", dotStmt)
+	case *FieldAssignNode:
+		return g.add(n, "=", dotStmt)
+	case *IfNode:
+		// The children are walked here so that the edges can say which part of the if they are
+		children := g.add(n, "if", dotStmt)
+		Walk(children.child("cond"), n.cond)
+		Walk(children.child("then"), n.then)
+		if n.els != nil {
+			Walk(children.child("else"), n.els)
+		}
+		return nil
+	case *ForNode:
+		return g.add(n, "for", dotStmt)
+	case *BranchNode:
+		return g.add(n, n.keyword, dotStmt)
+	case *BinaryExprNode:
+		return g.add(n, n.op.String(), dotExpr)
+	case *PrefixExprNode:
+		return g.add(n, n.op.String(), dotExpr)
+	case *CallExprNode:
+		return g.add(n, "call", dotExpr)
+	case *SelectorNode:
+		return g.add(n, "."+n.name, dotExpr)
+	case *CompositeLitNode:
+		children := g.add(n, n.kind+"{}", dotExpr)
+		for _, f := range n.fields {
+			if f.name != "" {
+				Walk(children.child(f.name), f.expr)
+			} else {
+				Walk(children, f.expr)
+			}
+		}
+		return nil
+	case *UnaryNode:
+		if n.token.token == IDENT {
+			return g.add(n, n.token.str, dotIdent)
+		}
+		return g.add(n, n.token.str, dotLiteral)
+	}
+	return g
+}
+
+// dotQuote makes s into a quoted dot string. Newlines become dot's centred line break
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,78 @@
+package noot
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func graphvizString(t *testing.T, name, src string) string {
+	t.Helper()
+	parser := Parser{}
+	file, _ := parser.ParseFile(name, NewLexStream(NewLexer(strings.NewReader(src))))
+	buf := bytes.Buffer{}
+	WriteGraphviz(file, &buf)
+	return buf.String()
+}
+
+func TestGraphvizUniqueIDs(t *testing.T) {
+	// The same statement twice on one line used to give both returns the same name, and the dots in the filename made an invalid ID
+	src := `func f(x int) int {
+	if x { return x } else { return x }
+}
+func g() { f(1); f(1) }
+`
+	out := graphvizString(t, "my.file.test", src)
+	if !strings.Contains(out, `file [label="my.file.test" shape=folder]`) {
+		t.Errorf("filename isn't quoted:\n%s", out)
+	}
+
+	defined := map[string]bool{}
+	for _, m := range regexp.MustCompile(`(?m)^\s*(\w+) \[label=`).FindAllStringSubmatch(out, -1) {
+		if defined[m[1]] {
+			t.Errorf("node %s defined twice", m[1])
+		}
+		defined[m[1]] = true
+	}
+	for _, m := range regexp.MustCompile(`(\w+) -> (\w+)`).FindAllStringSubmatch(out, -1) {
+		if !defined[m[1]] || !defined[m[2]] {
+			t.Errorf("edge %s refers to an undefined node", m[0])
+		}
+	}
+}
+
+func TestGraphvizClusters(t *testing.T) {
+	out := graphvizString(t, "test", "type X int\nfunc f() { g() }\nfunc g() {}\n")
+	if n := strings.Count(out, "subgraph cluster_"); n != 2 {
+		t.Errorf("expected 2 clusters, got %d:\n%s", n, out)
+	}
+	if strings.Count(out, "{") != strings.Count(out, "}") {
+		t.Errorf("unbalanced braces:\n%s", out)
+	}
+	// The edges into functions come after their clusters, or graphviz would put the file node in the first cluster
+	if !strings.Contains(out, "\t}\n\tfile -> n1\n") || strings.Contains(out, "\t\tfile ->") {
+		t.Errorf("edges from the file should be outside the clusters:\n%s", out)
+	}
+	// Things outside functions stay outside the clusters
+	if !strings.Contains(out, "\tn0 [label=\"type X int\\n1:1\" fillcolor=lightblue]\n\tfile -> n0\n\tsubgraph") {
+		t.Errorf("type should come before the first cluster:\n%s", out)
+	}
+}
+
+func TestGraphvizLabels(t *testing.T) {
+	out := graphvizString(t, "test", "func f() {\n\tif true { g(\"a \\\"b\\\"\") }\n\treturn 10 + 2\n}\n")
+	for _, want := range []string{
+		`label="func f"`,
+		`[label="if\n2:2" fillcolor=lightyellow]`,
+		`[label="cond"]`,
+		`[label="then"]`,
+		`[label="\"a \\\"b\\\"\"\n2:14" fillcolor=lightgrey]`,
+		`[label="g\n2:12" fillcolor=white]`,
+		`[label="+\n3:12" fillcolor=palegreen]`, // The operator, not the left operand
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,736 @@
+package noot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// --------------------------------------------------------------------------------
+// - Interpreter
+// --------------------------------------------------------------------------------
+// This is a plain tree-walking interpreter. It runs directly over the parsed FileNode. It doesn't look at the checker's types, so values carry their kind with them: declared types decide the kind of what is stored in variables, parameters, results and fields, and untyped constants take the kind of what they meet, like in Go.
+
+type RuntimeError struct {
+	file string // Only known for programs made by Link
+	pos Position
+	msg string
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Diagnostic().Error()
+}
+
+// Diagnostic is the error as a Diagnostic, for rendering
+func (e *RuntimeError) Diagnostic() Diagnostic {
+	return Diagnostic{file: e.file, pos: e.pos, msg: e.msg}
+}
+
+func runtimeErrorf(pos Position, format string, args ...any) *RuntimeError {
+	return &RuntimeError{pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+// value is a runtime value. Ints and bools are stored in i, bools as 1 and 0
+type value struct {
+	kind Kind
+	untyped bool // A constant that hasn't been given a type yet
+	i int
+	f float64
+	s string
+	typ string // The declared type of a struct
+	fields []value // In declaration order
+}
+
+func (v value) String() string {
+	switch v.kind {
+	case KindFloat:
+		return fmt.Sprint(v.f)
+	case KindBool:
+		return fmt.Sprint(v.i != 0)
+	case KindString:
+		return v.s
+	case KindStruct:
+		fields := make([]string, len(v.fields))
+		for i := range v.fields {
+			fields[i] = v.fields[i].String()
+		}
+		return v.typ + "{" + strings.Join(fields, ", ") + "}"
+	}
+	return fmt.Sprint(v.i)
+}
+
+func boolValue(b bool) value {
+	return value{kind: KindBool, i: boolToInt(b)}
+}
+
+// as converts an int or float to the kind k. Anything else is already the right kind, because the checker has seen it
+func (v value) as(k Kind) value {
+	switch {
+	case k == KindFloat && v.kind == KindInt:
+		return value{kind: KindFloat, f: float64(v.i)}
+	case k == KindInt && v.kind == KindFloat:
+		return value{kind: KindInt, i: int(v.f)}
+	}
+	v.untyped = false
+	return v
+}
+
+// copy gives a struct its own fields, so that assigning to them doesn't change the value it was copied from
+func (v value) copy() value {
+	if v.kind == KindStruct {
+		fields := make([]value, len(v.fields))
+		for i := range v.fields {
+			fields[i] = v.fields[i].copy()
+		}
+		v.fields = fields
+	}
+	return v
+}
+
+// vmValue is a value in the form the VM uses, see fromVM
+func (v value) vmValue() int {
+	if v.kind == KindFloat {
+		return floatToValue(v.f)
+	}
+	return v.i
+}
+
+// fromVM converts a value in the form the VM uses, where floats are stored as their bits, to the kind k
+func fromVM(x int, k Kind) value {
+	if k == KindFloat {
+		return value{kind: KindFloat, f: valueToFloat(x)}
+	}
+	return value{kind: k, i: x}
+}
+
+// fromVMSlots converts the slots of a VM value of type t, which are more than one for a struct. Strings are looked up in strs
+func fromVMSlots(xs []int, t *Type, strs []string) value {
+	switch t.kind {
+	case KindString:
+		return value{kind: KindString, s: strs[xs[0]]}
+	case KindStruct:
+		v := value{kind: KindStruct, typ: t.name, fields: make([]value, len(t.fields))}
+		for i, f := range t.fields {
+			n := slots(f.t)
+			v.fields[i] = fromVMSlots(xs[:n], f.t, strs)
+			xs = xs[n:]
+		}
+		return v
+	}
+	return fromVM(xs[0], t.kind)
+}
+
+// maxCallDepth stops runaway recursion before it takes down the Go stack
+const maxCallDepth = 10000
+
+type Interpreter struct {
+	funcs map[string]*FuncNode
+	methods map[string]map[string]*FuncNode // By receiver type, then name
+	types map[string]*TypeNode
+	origins map[Node]string
+	depth int
+}
+
+func NewInterpreter(file *FileNode) *Interpreter {
+	in := &Interpreter{
+		funcs: make(map[string]*FuncNode),
+		methods: make(map[string]map[string]*FuncNode),
+		types: make(map[string]*TypeNode),
+		origins: file.origins,
+	}
+	for _, node := range file.nodes {
+		switch n := node.(type) {
+		case *FuncNode:
+			if n.recv == nil {
+				in.funcs[n.funcName] = n
+				continue
+			}
+			if len(n.recv.args) != 1 {
+				continue
+			}
+			recv := n.recv.args[0].kind
+			if in.methods[recv] == nil {
+				in.methods[recv] = make(map[string]*FuncNode)
+			}
+			in.methods[recv][n.funcName] = n
+		case *TypeNode:
+			in.types[n.name] = n
+		}
+	}
+	return in
+}
+
+// Call runs the function with the given name, binding args to its parameters in order. Arguments and the result are passed the same way as to the VM: bools are 1 and 0, and floats are their IEEE 754 bits
+func (in *Interpreter) Call(name string, args ...int) (int, error) {
+	f, ok := in.funcs[name]
+	if !ok {
+		return 0, fmt.Errorf("undefined function: %s", name)
+	}
+
+	params := f.arguments.(*ArgNode).args
+	if len(params) != len(args) {
+		return 0, fmt.Errorf("%s expects %d arguments, got %d", name, len(params), len(args))
+	}
+	values := make([]value, len(args))
+	for i := range params {
+		k, _ := in.underlying(params[i].kind)
+		if k == KindStruct || k == KindString {
+			return 0, fmt.Errorf("argument %s of %s: %s values can't be passed to Call", params[i].name, name, unpassable(k))
+		}
+		values[i] = fromVM(args[i], k)
+	}
+
+	v, err := in.call(f, nil, values)
+	if err != nil {
+		return 0, err
+	}
+	if v.kind == KindStruct || v.kind == KindString {
+		return 0, fmt.Errorf("%s returns a %s, which Call can't return", name, unpassable(v.kind))
+	}
+	return v.vmValue(), nil
+}
+
+// unpassable names the kinds of value that don't fit in the int Call passes
+func unpassable(k Kind) string {
+	if k == KindString {
+		return "string"
+	}
+	return "struct"
+}
+
+// call runs a function or, if recv isn't nil, a method
+func (in *Interpreter) call(f *FuncNode, recv *value, args []value) (value, error) {
+	name := f.funcName
+	params := f.arguments.(*ArgNode).args
+	env := &env{vars: make(map[string]value, len(params)+1)}
+	if recv != nil {
+		env.vars[f.recv.args[0].name] = *recv
+	}
+	for i := range params {
+		env.vars[params[i].name] = in.convert(args[i], params[i].kind)
+	}
+
+	body, ok := f.body.(*CurlyScope)
+	if !ok {
+		return value{}, fmt.Errorf("%s has no body", name)
+	}
+
+	if in.depth >= maxCallDepth {
+		return value{}, fmt.Errorf("stack overflow calling %s", name)
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+
+	// The body shares the arguments' scope, like in Go
+	val, ctl, err := in.execStmts(body.nodes, env)
+	if err != nil {
+		if rerr, ok := err.(*RuntimeError); ok && rerr.file == "" {
+			rerr.file = in.origins[f] // The innermost call has the file the error happened in
+		}
+		return value{}, err
+	}
+	if f.returnType == "" {
+		return val, nil
+	}
+	if ctl != ctlReturn {
+		return value{}, fmt.Errorf("%s finished without returning a value", name)
+	}
+	return in.convert(val, f.returnType), nil
+}
+
+// underlying follows declared types down to the kind they are based on. For structs it also gives the declaration with the fields
+func (in *Interpreter) underlying(kind string) (Kind, *TypeNode) {
+	for seen := 0; seen <= len(in.types); seen++ {
+		t, ok := in.types[kind]
+		if !ok {
+			break
+		}
+		if t.isStruct() {
+			return KindStruct, t
+		}
+		kind = t.kind
+	}
+	switch kind {
+	case "float":
+		return KindFloat, nil
+	case "bool":
+		return KindBool, nil
+	case "string":
+		return KindString, nil
+	}
+	return KindInt, nil
+}
+
+// convert gives an untyped constant the type it is being stored as
+func (in *Interpreter) convert(v value, kind string) value {
+	k, _ := in.underlying(kind)
+	return v.as(k)
+}
+
+func (in *Interpreter) zero(kind string) value {
+	k, decl := in.underlying(kind)
+	if k != KindStruct {
+		return value{kind: k}
+	}
+	fields := make([]value, len(decl.fields))
+	for i, f := range decl.fields {
+		fields[i] = in.zero(f.kind)
+	}
+	return value{kind: KindStruct, typ: kind, fields: fields}
+}
+
+// fieldIndex finds a field of a struct type, or gives -1
+func (in *Interpreter) fieldIndex(typ, name string) int {
+	_, decl := in.underlying(typ)
+	if decl == nil {
+		return -1
+	}
+	for i, f := range decl.fields {
+		if f.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// env holds the variables of one block scope
+type env struct {
+	parent *env
+	vars map[string]value
+}
+
+// lookup finds the scope that name was declared in
+func (e *env) lookup(name string) (*env, bool) {
+	for ; e != nil; e = e.parent {
+		if _, ok := e.vars[name]; ok {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// control says how a statement finished: normally, or by jumping out with a return, break or continue
+type control uint8
+const (
+	ctlNone control = iota
+	ctlReturn
+	ctlBreak
+	ctlContinue
+)
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (in *Interpreter) execStmts(nodes []Node, e *env) (val value, ctl control, err error) {
+	for i := range nodes {
+		val, ctl, err = in.exec(nodes[i], e)
+		if err != nil || ctl != ctlNone {
+			return val, ctl, err
+		}
+	}
+	return value{}, ctlNone, nil
+}
+
+// exec runs a statement. If the statement jumps out with a return, break or continue then the caller has to stop executing and pass it up
+func (in *Interpreter) exec(node Node, e *env) (val value, ctl control, err error) {
+	switch n := node.(type) {
+	case *CurlyScope:
+		return in.execStmts(n.nodes, &env{e, make(map[string]value)})
+	case *ReturnNode:
+		if n.expr != nil {
+			val, err = in.eval(n.expr, e)
+		}
+		return val, ctlReturn, err
+	case *ExprStmtNode:
+		_, err = in.eval(n.expr, e)
+		return value{}, ctlNone, err
+	case *VarNode:
+		if n.expr == nil {
+			e.vars[n.name] = in.zero(n.kind)
+			return value{}, ctlNone, nil
+		}
+		val, err = in.eval(n.expr, e)
+		if n.kind != "" {
+			val = in.convert(val, n.kind)
+		}
+		e.vars[n.name] = val.as(val.kind)
+		return value{}, ctlNone, err
+	case *AssignNode:
+		val, err = in.eval(n.expr, e)
+		if err != nil {
+			return value{}, ctlNone, err
+		}
+		scope, ok := e.lookup(n.name)
+		if !ok {
+			return value{}, ctlNone, runtimeErrorf(n.pos, "undefined: %s", n.name)
+		}
+		scope.vars[n.name] = val.as(scope.vars[n.name].kind)
+		return value{}, ctlNone, nil
+	case *FieldAssignNode:
+		val, err = in.eval(n.expr, e)
+		if err != nil {
+			return value{}, ctlNone, err
+		}
+		field, err := in.fieldRef(n.target, e)
+		if err != nil {
+			return value{}, ctlNone, err
+		}
+		*field = val.as(field.kind)
+		return value{}, ctlNone, nil
+	case *IfNode:
+		cond, err := in.eval(n.cond, e)
+		if err != nil {
+			return value{}, ctlNone, err
+		}
+		if cond.i != 0 {
+			return in.exec(n.then, e)
+		} else if n.els != nil {
+			return in.exec(n.els, e)
+		}
+		return value{}, ctlNone, nil
+	case *ForNode:
+		for {
+			if n.cond != nil {
+				cond, err := in.eval(n.cond, e)
+				if err != nil {
+					return value{}, ctlNone, err
+				}
+				if cond.i == 0 {
+					return value{}, ctlNone, nil
+				}
+			}
+
+			val, ctl, err = in.exec(n.body, e)
+			if err != nil || ctl == ctlReturn {
+				return val, ctl, err
+			}
+			if ctl == ctlBreak {
+				return value{}, ctlNone, nil
+			}
+		}
+	case *BranchNode:
+		if n.keyword == "break" {
+			return value{}, ctlBreak, nil
+		}
+		return value{}, ctlContinue, nil
+	}
+	return value{}, ctlNone, fmt.Errorf("cannot execute %T", node)
+}
+
+// fieldRef finds the field that a selector refers to, so that it can be assigned to. The selector has to start from a variable
+func (in *Interpreter) fieldRef(n *SelectorNode, e *env) (*value, error) {
+	var v *value
+	switch x := n.expr.(type) {
+	case *SelectorNode:
+		var err error
+		v, err = in.fieldRef(x, e)
+		if err != nil {
+			return nil, err
+		}
+	case *UnaryNode:
+		scope, ok := e.lookup(x.token.str)
+		if !ok {
+			return nil, runtimeErrorf(x.token.pos, "undefined: %s", x.token.str)
+		}
+		root := scope.vars[x.token.str] // Shares its fields with the copy in the map
+		v = &root
+	case *ExprNode:
+		return in.fieldRef(&SelectorNode{expr: x.expr, name: n.name, namePos: n.namePos}, e)
+	default:
+		return nil, runtimeErrorf(n.Pos(), "cannot assign to %s", formatExpr(n))
+	}
+
+	i := in.fieldIndex(v.typ, n.name)
+	if i < 0 {
+		return nil, runtimeErrorf(n.namePos, "%s has no field %s", v.typ, n.name)
+	}
+	return &v.fields[i], nil
+}
+
+// eval evaluates an expression tree
+func (in *Interpreter) eval(node Node, e *env) (value, error) {
+	switch n := node.(type) {
+	case *UnaryNode:
+		return in.evalOperand(n, e)
+	case *ExprNode:
+		return in.eval(n.expr, e)
+	case *PrefixExprNode:
+		v, err := in.eval(n.expr, e)
+		if err != nil {
+			return value{}, err
+		}
+		switch {
+		case n.op == OpNot:
+			return boolValue(v.i == 0), nil
+		case v.kind == KindFloat:
+			v.f = -v.f
+		default:
+			v.i = -v.i
+		}
+		return v, nil
+	case *BinaryExprNode:
+		lhs, err := in.eval(n.left, e)
+		if err != nil {
+			return value{}, err
+		}
+
+		// && and || only evaluate the right hand side if they need it
+		if n.op == OpAnd && lhs.i == 0 || n.op == OpOr && lhs.i != 0 {
+			return lhs, nil
+		}
+
+		rhs, err := in.eval(n.right, e)
+		if err != nil {
+			return value{}, err
+		}
+		return binary(n.pos, n.op, lhs, rhs)
+	case *CallExprNode:
+		args := make([]value, len(n.args))
+		for i := range n.args {
+			v, err := in.eval(n.args[i], e)
+			if err != nil {
+				return value{}, err
+			}
+			args[i] = v
+		}
+
+		var v value
+		var err error
+		switch fn := n.fn.(type) {
+		case *UnaryNode:
+			f, ok := in.funcs[fn.token.str]
+			if !ok {
+				return value{}, runtimeErrorf(n.pos, "undefined function: %s", fn.token.str)
+			}
+			v, err = in.call(f, nil, args)
+		case *SelectorNode:
+			recv, rerr := in.eval(fn.expr, e)
+			if rerr != nil {
+				return value{}, rerr
+			}
+			f, ok := in.methods[recv.typ][fn.name]
+			if !ok {
+				return value{}, runtimeErrorf(fn.namePos, "%s has no method %s", recv.typ, fn.name)
+			}
+			v, err = in.call(f, &recv, args)
+		default:
+			return value{}, runtimeErrorf(n.pos, "cannot call non-function")
+		}
+		if err != nil {
+			if _, ok := err.(*RuntimeError); !ok {
+				err = runtimeErrorf(n.pos, "%s", err)
+			}
+		}
+		return v, err
+	case *SelectorNode:
+		v, err := in.eval(n.expr, e)
+		if err != nil {
+			return value{}, err
+		}
+		i := in.fieldIndex(v.typ, n.name)
+		if i < 0 {
+			return value{}, runtimeErrorf(n.namePos, "%s has no field %s", v.typ, n.name)
+		}
+		return v.fields[i], nil
+	case *CompositeLitNode:
+		v := in.zero(n.kind)
+		_, decl := in.underlying(n.kind)
+		if decl == nil {
+			return value{}, runtimeErrorf(n.pos, "%s is not a struct type", n.kind)
+		}
+		for i, f := range n.fields {
+			if f.name != "" {
+				i = in.fieldIndex(n.kind, f.name)
+			}
+			if i < 0 || i >= len(decl.fields) {
+				return value{}, runtimeErrorf(f.expr.Pos(), "too many values in %s literal", n.kind)
+			}
+			fv, err := in.eval(f.expr, e)
+			if err != nil {
+				return value{}, err
+			}
+			v.fields[i] = in.convert(fv, decl.fields[i].kind)
+		}
+		return v, nil
+	}
+	return value{}, fmt.Errorf("cannot evaluate %T", node)
+}
+
+func (in *Interpreter) evalOperand(n *UnaryNode, e *env) (value, error) {
+	switch n.token.token {
+	case INT:
+		v, err := strconv.Atoi(n.token.str)
+		if err != nil {
+			return value{}, runtimeErrorf(n.token.pos, "invalid integer %s", n.token.str)
+		}
+		return value{kind: KindInt, untyped: true, i: v}, nil
+	case FLOAT:
+		f, err := strconv.ParseFloat(n.token.str, 64)
+		if err != nil {
+			return value{}, runtimeErrorf(n.token.pos, "invalid float %s", n.token.str)
+		}
+		return value{kind: KindFloat, untyped: true, f: f}, nil
+	case STRING:
+		s, err := strconv.Unquote(n.token.str)
+		if err != nil {
+			return value{}, runtimeErrorf(n.token.pos, "invalid string %s", n.token.str)
+		}
+		return value{kind: KindString, s: s}, nil
+	case IDENT:
+		scope, ok := e.lookup(n.token.str)
+		if ok {
+			return scope.vars[n.token.str].copy(), nil
+		}
+		switch n.token.str {
+		case "true":
+			return boolValue(true), nil
+		case "false":
+			return boolValue(false), nil
+		}
+		return value{}, runtimeErrorf(n.token.pos, "undefined: %s", n.token.str)
+	}
+	return value{}, runtimeErrorf(n.token.pos, "expected operand, found %s", n.token)
+}
+
+// binary applies an operator to two values of the same type. An untyped constant is converted to the type of the other operand first, and two untyped constants of different kinds are both floats
+func binary(pos Position, op Operator, a, b value) (value, error) {
+	untyped := a.untyped && b.untyped
+	switch {
+	case a.untyped && !b.untyped:
+		a = a.as(b.kind)
+	case b.untyped && !a.untyped:
+		b = b.as(a.kind)
+	case a.kind != b.kind:
+		a, b = a.as(KindFloat), b.as(KindFloat)
+	}
+	comparison := op >= OpEql && op <= OpGeq
+
+	switch a.kind {
+	case KindFloat:
+		if comparison {
+			return boolValue(compareFloat(op, a.f, b.f)), nil
+		}
+		return value{kind: KindFloat, untyped: untyped, f: arithFloat(op, a.f, b.f)}, nil
+	case KindString:
+		if comparison {
+			return boolValue(compareString(op, a.s, b.s)), nil
+		}
+		return value{kind: KindString, s: a.s + b.s}, nil // + is the only operator strings have
+	case KindStruct:
+		eq := equal(a, b)
+		return boolValue(eq == (op == OpEql)), nil
+	}
+
+	v, err := arith(pos, op, a.i, b.i)
+	if comparison || a.kind == KindBool {
+		return boolValue(v != 0), err
+	}
+	return value{kind: a.kind, untyped: untyped, i: v}, err
+}
+
+func equal(a, b value) bool {
+	if a.kind != b.kind || a.i != b.i || a.f != b.f || a.s != b.s || len(a.fields) != len(b.fields) {
+		return false
+	}
+	for i := range a.fields {
+		if !equal(a.fields[i], b.fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func arithFloat(op Operator, a, b float64) float64 {
+	switch op {
+	case OpAdd:
+		return a + b
+	case OpSub:
+		return a - b
+	case OpMul:
+		return a * b
+	}
+	return a / b // Division by zero gives an infinity or NaN, like in Go
+}
+
+func compareFloat(op Operator, a, b float64) bool {
+	switch op {
+	case OpEql:
+		return a == b
+	case OpNeq:
+		return a != b
+	case OpLss:
+		return a < b
+	case OpGtr:
+		return a > b
+	case OpLeq:
+		return a <= b
+	}
+	return a >= b
+}
+
+func compareString(op Operator, a, b string) bool {
+	switch op {
+	case OpEql:
+		return a == b
+	case OpNeq:
+		return a != b
+	case OpLss:
+		return a < b
+	case OpGtr:
+		return a > b
+	case OpLeq:
+		return a <= b
+	}
+	return a >= b
+}
+
+// overflows reports whether an integer operation on a and b wrapped around to give v
+func overflows(op Operator, a, b, v int) bool {
+	switch op {
+	case OpAdd:
+		return (b > 0) != (v > a)
+	case OpSub:
+		return (b > 0) != (v < a)
+	case OpMul:
+		return a != 0 && (v/a != b || a == -1 && b == math.MinInt)
+	case OpDiv:
+		return a == math.MinInt && b == -1
+	}
+	return false
+}
+
+func arith(pos Position, op Operator, a, b int) (int, error) {
+	switch op {
+	case OpAdd:
+		return a + b, nil
+	case OpSub:
+		return a - b, nil
+	case OpMul:
+		return a * b, nil
+	case OpDiv:
+		if b == 0 {
+			return 0, runtimeErrorf(pos, "division by zero")
+		}
+		return a / b, nil
+	case OpEql:
+		return boolToInt(a == b), nil
+	case OpNeq:
+		return boolToInt(a != b), nil
+	case OpLss:
+		return boolToInt(a < b), nil
+	case OpGtr:
+		return boolToInt(a > b), nil
+	case OpLeq:
+		return boolToInt(a <= b), nil
+	case OpGeq:
+		return boolToInt(a >= b), nil
+	case OpAnd, OpOr:
+		return boolToInt(b != 0), nil // The left hand side has already been checked
+	}
+	return 0, runtimeErrorf(pos, "unknown operator %s", op)
+}
@@ -0,0 +1,328 @@
+package noot
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadInputTest(t testing.TB) *Interpreter {
+	t.Helper()
+	file, err := os.Open("input.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	parser := Parser{}
+	tree, errs := parser.ParseFile("input.test", &Tokens{lexAll(file)})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	return NewInterpreter(tree)
+}
+
+func TestInterpreterInputFile(t *testing.T) {
+	in := loadInputTest(t)
+
+	tests := []struct {
+		name string
+		x, y int
+		want int
+	}{
+		{"FunctionA", 1, 2, 2},
+		{"FunctionA", -5, 0, -4},
+		{"FunctionB", 1, 2, 12},
+		{"FunctionB", 10, 0, 21},
+		{"FunctionC", 1, 2, 4},
+		{"FunctionC", 0, 100, 3},
+	}
+	for _, test := range tests {
+		got, err := in.Call(test.name, test.x, test.y)
+		if err != nil {
+			t.Fatalf("%s(%d, %d): %v", test.name, test.x, test.y, err)
+		}
+		if got != test.want {
+			t.Errorf("%s(%d, %d) = %d, want %d", test.name, test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestInterpreterPrecedence(t *testing.T) {
+	tree, errs := parseString(t, `func F(x X, y Y) {
+	return x - 2 * y + 8 / 2 - 1
+}
+`)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	got, err := NewInterpreter(tree).Call("F", 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 10-2*3+8/2-1 {
+		t.Errorf("got %d, want %d", got, 10-2*3+8/2-1)
+	}
+}
+
+func TestInterpreterErrors(t *testing.T) {
+	tree, _ := parseString(t, `func F(x X) {
+	return x / 0
+}
+
+func G(x X) {
+	return z
+}
+`)
+	in := NewInterpreter(tree)
+	if _, err := in.Call("F", 1); err == nil {
+		t.Error("expected division by zero error")
+	}
+	if _, err := in.Call("G", 1); err == nil {
+		t.Error("expected undefined identifier error")
+	}
+	if _, err := in.Call("F", 1, 2); err == nil {
+		t.Error("expected arity error")
+	}
+	if _, err := in.Call("H"); err == nil {
+		t.Error("expected undefined function error")
+	}
+}
+
+// Programs with control flow, run through both the interpreter and the VM
+var controlFlowTests = []struct {
+	src  string
+	args []int
+	want int
+}{
+	{`func F(n int) int {
+	total := 0
+	i := 0
+	for i < n {
+		i = i + 1
+		if i == 3 {
+			continue
+		}
+		total = total + i
+	}
+	return total
+}`, []int{5}, 1 + 2 + 4 + 5},
+	{`func F(n int) int {
+	i := 0
+	for {
+		if i * i > n {
+			break
+		}
+		i = i + 1
+	}
+	return i
+}`, []int{50}, 8},
+	{`func F(x int) int {
+	if x < 0 {
+		return -1
+	} else if x == 0 {
+		return 0
+	}
+	return 1
+}`, []int{-7}, -1},
+	{`func F(x int) int {
+	y := x
+	if true {
+		y := 100
+		y = y + 1
+	}
+	return y
+}`, []int{3}, 3},
+	{`func F(x int) bool {
+	return x != 0 && 10 / x > 2 || x == 0
+}`, []int{0}, 1},
+	{`func F(x int) bool {
+	return x != 0 && 10 / x > 2 || x == 0
+}`, []int{5}, 0},
+	{`func F(n int) int {
+	count := 0
+	i := 0
+	for i < n {
+		j := 0
+		for j < n {
+			if j == i {
+				break
+			}
+			count = count + 1
+			j = j + 1
+		}
+		i = i + 1
+	}
+	return count
+}`, []int{4}, 0 + 1 + 2 + 3},
+	{`func F(x int) int {
+	var y int
+	var z = !(x >= 2) == (x <= 1)
+	if z {
+		y = 7
+	}
+	return y
+}`, []int{2}, 7},
+	{`func F(n int) int {
+	return fib(n)
+}
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n - 1) + fib(n - 2)
+}`, []int{15}, 610},
+	{`func F(n int) int {
+	total := 0
+	add(total, n)
+	return sum(1, n, 0)
+}
+func add(a int, b int) {
+	a = a + b
+	return
+}
+func sum(from int, to int, acc int) int {
+	if from > to {
+		return acc
+	}
+	return sum(from + 1, to, acc + from)
+}`, []int{100}, 5050},
+	{`func F(n int) bool {
+	return isEven(n)
+}
+func isEven(n int) bool {
+	if n == 0 {
+		return true
+	}
+	return isOdd(n - 1)
+}
+func isOdd(n int) bool {
+	if n == 0 {
+		return false
+	}
+	return isEven(n - 1)
+}`, []int{7}, 0},
+}
+
+func TestInterpreterControlFlow(t *testing.T) {
+	for _, test := range controlFlowTests {
+		tree, errs := parseString(t, test.src)
+		if len(errs) == 0 {
+			errs = NewChecker().Check(tree)
+		}
+		if len(errs) != 0 {
+			t.Fatalf("%s: %v", test.src, errs)
+		}
+
+		got, err := NewInterpreter(tree).Call("F", test.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if got != test.want {
+			t.Errorf("interpreter: %s\ngot %d, want %d", test.src, got, test.want)
+		}
+
+		program, errs := Compile(tree)
+		if len(errs) != 0 {
+			t.Fatalf("%s: %v", test.src, errs)
+		}
+		got, err = NewVM(program).Call("F", test.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if got != test.want {
+			t.Errorf("vm: %s\ngot %d, want %d\n%s", test.src, got, test.want, program.Disassemble())
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	tree, errs := parseString(t, "func F(n int) int {\n\treturn F(n + 1)\n}\n")
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if _, err := NewInterpreter(tree).Call("F", 0); err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("interpreter: expected stack overflow, got %v", err)
+	}
+
+	program, _ := Compile(tree)
+	if _, err := NewVM(program).Call("F", 0); err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("vm: expected stack overflow, got %v", err)
+	}
+}
+
+// Structs only run on the interpreter. Results are compared as strings so floats and structs can be checked too
+func TestInterpreterStructs(t *testing.T) {
+	decls := `type Vec2 struct {
+	X float
+	Y float
+}
+
+type Box struct {
+	Min Vec2
+	Max Vec2
+}
+
+type Point Vec2
+
+func (v Vec2) Len() float {
+	return sqrt(v.X * v.X + v.Y * v.Y)
+}
+
+func (v Vec2) Add(o Vec2) Vec2 {
+	v.X = v.X + o.X
+	v.Y = v.Y + o.Y
+	return v
+}
+
+func (b Box) Size() Vec2 {
+	return Vec2{b.Max.X - b.Min.X, b.Max.Y - b.Min.Y}
+}
+
+func sqrt(x float) float {
+	z := x
+	i := 0
+	for i < 30 {
+		z = (z + x / z) / 2
+		i = i + 1
+	}
+	return z
+}
+`
+	tests := []struct {
+		body string
+		result string
+		want string
+	}{
+		{"return Vec2{3, 4}.Len()", "float", "5"},
+		{"v := Vec2{X: 1}\n\treturn v.Add(Vec2{Y: 2}).Add(v)", "Vec2", "Vec2{2, 2}"},
+		// Structs are values: the copy and the receiver are changed, not the original
+		{"a := Vec2{1, 2}\n\tb := a\n\tb.X = 5\n\ta.Add(b)\n\treturn a", "Vec2", "Vec2{1, 2}"},
+		{"var b Box\n\tb.Max.Y = 3\n\tb.Max = b.Max.Add(Vec2{X: 2})\n\treturn b.Size()", "Vec2", "Vec2{2, 3}"},
+		{"var b Box\n\treturn b", "Box", "Box{Vec2{0, 0}, Vec2{0, 0}}"},
+		{"return Vec2{1, 2} == Vec2{X: 1, Y: 2} && Box{} != Box{Max: Vec2{Y: 1}}", "bool", "true"},
+		{"p := Point{1, 2}\n\tp.Y = 7 / 2\n\treturn p", "Point", "Point{1, 3}"},
+		// Untyped constants take the type of what they meet
+		{"v := Vec2{7, 0}\n\treturn v.X / 2 + 1 / 2", "float", "3.5"},
+		{"x := 7\n\treturn x / 2 * 2", "int", "6"},
+	}
+	for _, test := range tests {
+		src := decls + "func F() " + test.result + " {\n\t" + test.body + "\n}\n"
+		tree, errs := parseString(t, src)
+		if len(errs) == 0 {
+			errs = NewChecker().Check(tree)
+		}
+		if len(errs) != 0 {
+			t.Fatalf("%s: %v", test.body, errs)
+		}
+
+		in := NewInterpreter(tree)
+		got, err := in.call(in.funcs["F"], nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.body, err)
+		}
+		if got.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.body, got, test.want)
+		}
+	}
+}
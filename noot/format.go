@@ -0,0 +1,283 @@
+package noot
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// --------------------------------------------------------------------------------
+// - Formatter
+// --------------------------------------------------------------------------------
+// Prints a parsed file back out in the one canonical layout: tab indents, one statement per line, spaces around binary operators and opening braces on the same line. Comments aren't in the tree, so they are merged back in by position from the lexer's list as the tree is printed.
+// Every statement ends at the end of its line and nothing is ever split across lines, so the semicolons that the lexer inserts at newlines always land where the statements end.
+
+// Format reformats a noot source file. Files that don't parse are left alone and the errors are returned
+func Format(src []byte) ([]byte, []Diagnostic) {
+	lexer := NewByteLexer(src)
+	parser := Parser{}
+	file, errs := parser.ParseFile("", NewLexStream(lexer))
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	p := printer{comments: lexer.comments}
+	p.nodes(file.nodes, Position{line: math.MaxInt})
+	return p.buf.Bytes(), nil
+}
+
+// formatFile prints a tree in the canonical layout. There are no comments to merge back in, so it suits trees that have been rewritten rather than ones straight from the parser
+func formatFile(file *FileNode) []byte {
+	p := printer{}
+	p.nodes(file.nodes, Position{line: math.MaxInt})
+	return p.buf.Bytes()
+}
+
+type printer struct {
+	buf bytes.Buffer
+	indent int
+	comments []Comment // The comments that haven't been printed yet
+	last int // Source line of the last thing printed, zero at the start of a block where no blank line is wanted
+}
+
+func (p *printer) write(s ...string) {
+	for i := range s {
+		p.buf.WriteString(s[i])
+	}
+}
+
+func (p *printer) newline() {
+	p.write("\n")
+}
+
+func (p *printer) startLine() {
+	p.write(strings.Repeat("\t", p.indent))
+}
+
+// separate keeps one blank line between things that had at least one between them in the source
+func (p *printer) separate(line int) {
+	if p.last != 0 && line > p.last+1 {
+		p.newline()
+	}
+}
+
+func commentEnd(c Comment) int {
+	return c.pos.line + strings.Count(c.text, "\n")
+}
+
+func (p *printer) commentBefore(pos Position) bool {
+	return len(p.comments) > 0 && p.comments[0].pos.before(pos)
+}
+
+// commentsBefore prints the comments that come before pos, each on its own line
+func (p *printer) commentsBefore(pos Position) {
+	for p.commentBefore(pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.separate(c.pos.line)
+		p.startLine()
+		p.write(c.text)
+		p.newline()
+		p.last = commentEnd(c)
+	}
+}
+
+// trailing prints the comments on the given source line that come before limit on the end of the current line
+func (p *printer) trailing(line int, limit Position) {
+	for len(p.comments) > 0 && p.comments[0].pos.line == line && p.comments[0].pos.before(limit) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.write(" ", c.text)
+		p.last = commentEnd(c)
+	}
+}
+
+// nodes prints a list of statements or declarations, one per line. end is where the enclosing block closes
+func (p *printer) nodes(nodes []Node, end Position) {
+	p.last = 0
+	for _, node := range nodes {
+		p.commentsBefore(node.Pos())
+		p.separate(node.Pos().line)
+		p.startLine()
+		p.stmt(node)
+		p.last = node.End().line
+		p.trailing(node.End().line, end)
+		p.newline()
+	}
+	p.commentsBefore(end)
+}
+
+func (p *printer) block(n *CurlyScope) {
+	p.write("{")
+	limit := n.rbrace
+	if len(n.nodes) > 0 {
+		limit = n.nodes[0].Pos()
+	}
+	pending := len(p.comments)
+	p.trailing(n.pos.line, limit)
+
+	// Only a block with nothing at all inside goes on one line
+	if len(n.nodes) == 0 && pending == len(p.comments) && !p.commentBefore(n.rbrace) {
+		p.write("}")
+		return
+	}
+	p.newline()
+	p.indent++
+	p.nodes(n.nodes, n.rbrace)
+	p.indent--
+	p.startLine()
+	p.write("}")
+}
+
+// fields prints the body of a struct type, one field per line like the statements of a block
+func (p *printer) fields(n *TypeNode) {
+	p.write("{")
+	limit := n.rbrace
+	if len(n.fields) > 0 {
+		limit = n.fields[0].pos
+	}
+	pending := len(p.comments)
+	p.trailing(n.kindPos.line, limit)
+
+	if len(n.fields) == 0 && pending == len(p.comments) && !p.commentBefore(n.rbrace) {
+		p.write("}")
+		return
+	}
+	p.newline()
+	p.indent++
+	p.last = 0
+	for i, f := range n.fields {
+		p.commentsBefore(f.pos)
+		p.separate(f.pos.line)
+		p.startLine()
+		p.write(f.name, " ", f.kind)
+		p.last = f.pos.line
+
+		// Comments after a field belong to it, unless another field follows on the same line
+		limit := n.rbrace
+		if i+1 < len(n.fields) {
+			limit = n.fields[i+1].pos
+		}
+		p.trailing(f.pos.line, limit)
+		p.newline()
+	}
+	p.commentsBefore(n.rbrace)
+	p.indent--
+	p.startLine()
+	p.write("}")
+}
+
+func (p *printer) stmt(node Node) {
+	switch n := node.(type) {
+	case *PackageNode:
+		p.write("package ", n.name)
+	case *ImportNode:
+		p.write("import ", strconv.Quote(n.path))
+	case *TypeNode:
+		p.write("type ", n.name, " ", n.kind)
+		if n.isStruct() {
+			p.write(" ")
+			p.fields(n)
+		}
+	case *FuncNode:
+		p.write("func ")
+		if n.recv != nil {
+			p.write("(")
+			for i, arg := range n.recv.args {
+				if i > 0 {
+					p.write(", ")
+				}
+				p.write(arg.name, " ", arg.kind)
+			}
+			p.write(") ")
+		}
+		p.write(n.funcName, "(")
+		for i, arg := range n.arguments.(*ArgNode).args {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(arg.name, " ", arg.kind)
+		}
+		p.write(") ")
+		if n.returnType != "" {
+			p.write(n.returnType, " ")
+		}
+		p.block(n.body.(*CurlyScope))
+	case *ReturnNode:
+		p.write("return")
+		if n.expr != nil {
+			p.write(" ", formatExpr(n.expr))
+		}
+	case *VarNode:
+		if n.short {
+			p.write(n.name, " := ", formatExpr(n.expr))
+			return
+		}
+		p.write("var ", n.name)
+		if n.kind != "" {
+			p.write(" ", n.kind)
+		}
+		if n.expr != nil {
+			p.write(" = ", formatExpr(n.expr))
+		}
+	case *AssignNode:
+		p.write(n.name, " = ", formatExpr(n.expr))
+	case *FieldAssignNode:
+		p.write(formatExpr(n.target), " = ", formatExpr(n.expr))
+	case *IfNode:
+		p.write("if ", formatExpr(n.cond), " ")
+		p.block(n.then.(*CurlyScope))
+		switch els := n.els.(type) {
+		case *IfNode:
+			p.write(" else ")
+			p.stmt(els)
+		case *CurlyScope:
+			p.write(" else ")
+			p.block(els)
+		}
+	case *ForNode:
+		p.write("for ")
+		if n.cond != nil {
+			p.write(formatExpr(n.cond), " ")
+		}
+		p.block(n.body.(*CurlyScope))
+	case *BranchNode:
+		p.write(n.keyword)
+	case *ExprStmtNode:
+		p.write(formatExpr(n.expr))
+	case *CurlyScope:
+		p.block(n)
+	}
+}
+
+func formatExpr(node Node) string {
+	switch n := node.(type) {
+	case *UnaryNode:
+		return n.token.str
+	case *ExprNode:
+		return "(" + formatExpr(n.expr) + ")"
+	case *PrefixExprNode:
+		return n.op.String() + formatExpr(n.expr)
+	case *BinaryExprNode:
+		return formatExpr(n.left) + " " + n.op.String() + " " + formatExpr(n.right)
+	case *CallExprNode:
+		args := make([]string, len(n.args))
+		for i := range n.args {
+			args[i] = formatExpr(n.args[i])
+		}
+		return formatExpr(n.fn) + "(" + strings.Join(args, ", ") + ")"
+	case *SelectorNode:
+		return formatExpr(n.expr) + "." + n.name
+	case *CompositeLitNode:
+		fields := make([]string, len(n.fields))
+		for i, f := range n.fields {
+			fields[i] = formatExpr(f.expr)
+			if f.name != "" {
+				fields[i] = f.name + ": " + fields[i]
+			}
+		}
+		return n.kind + "{" + strings.Join(fields, ", ") + "}"
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+package noot
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+var formatTests = []struct {
+	name string
+	src string
+	want string
+}{
+	{
+		"spacing",
+		"func f(a int,b int)int{return a+b*( 2-1 )}\n",
+		"func f(a int, b int) int {\n\treturn a + b * (2 - 1)\n}\n",
+	},
+	{
+		"statements",
+		"func f(){\n  var x int\n  y:=-x;x=!true\n  if x>1{g(x,y)}else if x<0{}else{for{break}}\n  for x<3{continue}\n  return\n}\n",
+		"func f() {\n\tvar x int\n\ty := -x\n\tx = !true\n\tif x > 1 {\n\t\tg(x, y)\n\t} else if x < 0 {} else {\n\t\tfor {\n\t\t\tbreak\n\t\t}\n\t}\n\tfor x < 3 {\n\t\tcontinue\n\t}\n\treturn\n}\n",
+	},
+	{
+		"blank lines",
+		"\n\ntype X int\n\n\n\ntype Y int\nfunc f() {\n\n  x := 1\n\n\n  x = 2\n\n}\n\n",
+		"type X int\n\ntype Y int\nfunc f() {\n\tx := 1\n\n\tx = 2\n}\n",
+	},
+	{
+		"comments",
+		"// file\ntype X int // trailing\n/* block\n   comment */\nfunc f() { // brace\n  // inside\n  x := 1 /* after */ // and more\n\n  // before close\n}\nfunc g() { return } // one liner\nfunc h() {\n  // only a comment\n}\n// end\n",
+		"// file\ntype X int // trailing\n/* block\n   comment */\nfunc f() { // brace\n\t// inside\n\tx := 1 /* after */ // and more\n\n\t// before close\n}\nfunc g() {\n\treturn\n} // one liner\nfunc h() {\n\t// only a comment\n}\n// end\n",
+	},
+	{
+		"structs",
+		"type V struct{X float;Y float // why\n}\ntype E struct {  }\ntype B struct { // box\n  // corners\n  Min V\n\n  Max V }\nfunc (v V)Len()float{v.X=V{X:1,Y:2}.Y;return f(V{1,2}).X}\n",
+		"type V struct {\n\tX float\n\tY float // why\n}\ntype E struct {}\ntype B struct { // box\n\t// corners\n\tMin V\n\n\tMax V\n}\nfunc (v V) Len() float {\n\tv.X = V{X: 1, Y: 2}.Y\n\treturn f(V{1, 2}).X\n}\n",
+	},
+	{
+		"strings",
+		"func f() { g(\"a  b\",  \"\\\"q\\\"\") }\n",
+		"func f() {\n\tg(\"a  b\", \"\\\"q\\\"\")\n}\n",
+	},
+}
+
+func TestFormat(t *testing.T) {
+	for _, test := range formatTests {
+		out, errs := Format([]byte(test.src))
+		if len(errs) > 0 {
+			t.Errorf("%s: %v", test.name, errs)
+			continue
+		}
+		if string(out) != test.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", test.name, out, test.want)
+		}
+	}
+}
+
+// Formatting twice must change nothing, and the formatted source has to parse to the same tree
+func TestFormatIdempotent(t *testing.T) {
+	sources := []string{}
+	for _, test := range formatTests {
+		sources = append(sources, test.src)
+	}
+	data, err := os.ReadFile("input.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources = append(sources, string(data))
+
+	for _, src := range sources {
+		once, errs := Format([]byte(src))
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		twice, errs := Format(once)
+		if len(errs) > 0 {
+			t.Fatalf("formatted source doesn't parse: %v\n%s", errs, once)
+		}
+		if string(once) != string(twice) {
+			t.Errorf("not idempotent:\n%s\nthen:\n%s", once, twice)
+		}
+
+		before, _ := parseString(t, src)
+		after, _ := parseString(t, string(once))
+		if sexpr(before) != sexpr(after) {
+			t.Errorf("formatting changed the tree:\n%s\n%s", sexpr(before), sexpr(after))
+		}
+	}
+}
+
+func TestFormatKeepsEveryComment(t *testing.T) {
+	src := "func f(/* a */ x int) /* b */ { /* c */ return /* d */ x + /* e */ 1 } /* f */ // g\n"
+	out, errs := Format([]byte(src))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, c := range []string{"/* a */", "/* b */", "/* c */", "/* d */", "/* e */", "/* f */", "// g"} {
+		if strings.Count(string(out), c) != 1 {
+			t.Errorf("expected %s once in:\n%s", c, out)
+		}
+	}
+	again, _ := Format(out)
+	if string(again) != string(out) {
+		t.Errorf("not idempotent:\n%s\nthen:\n%s", out, again)
+	}
+}
+
+func TestFormatRejectsBrokenFiles(t *testing.T) {
+	out, errs := Format([]byte("func f( {\n"))
+	if len(errs) == 0 || out != nil {
+		t.Errorf("expected errors and no output, got %v %q", errs, out)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+	want := `--- f.orig
++++ f
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`
+	if got := unifiedDiff("f", []byte(a), []byte(b)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := unifiedDiff("f", []byte(a), []byte(a)); got != "" {
+		t.Errorf("expected no diff for equal input, got:\n%s", got)
+	}
+}
@@ -0,0 +1,92 @@
+package noot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Run these with `go test -fuzz=FuzzLex` or `go test -fuzz=FuzzParseFile`. Without -fuzz only the seeds are run, along with any failures saved in testdata/fuzz
+
+// addSeeds adds the golden sources and input.test to the corpus
+func addSeeds(f *testing.F) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "golden", "*.noot"))
+	for _, path := range append(paths, "input.test") {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	f.Add("")
+	f.Add("\"unterminated")
+	f.Add("/* unterminated")
+	f.Add("func (")
+}
+
+// The lexer has to finish on any input, with its tokens in order
+func FuzzLex(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		lexer := NewLexer(strings.NewReader(src))
+		prev := Position{1, 0}
+		// Every token but an inserted semicolon uses up at least one rune, and semicolons never come twice in a row
+		limit := 2*utf8.RuneCountInString(src) + 2
+		for i := 0; ; i++ {
+			if i > limit {
+				t.Fatalf("no EOF after %d tokens", i)
+			}
+			pos, tok, lit := lexer.Lex()
+			if pos.before(prev) {
+				t.Fatalf("%s %q at %s comes before the previous token at %s", tok, lit, pos, prev)
+			}
+			prev = pos
+			if tok == EOF {
+				break
+			}
+		}
+
+		for i := 1; i < len(lexer.comments); i++ {
+			if !lexer.comments[i-1].pos.before(lexer.comments[i].pos) {
+				t.Fatalf("comment at %s comes after the one at %s", lexer.comments[i-1].pos, lexer.comments[i].pos)
+			}
+		}
+	})
+}
+
+// The parser has to finish on any input without panicking, and every node has to start before it ends, fit inside its parent and come after the nodes before it
+func FuzzParseFile(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		parser := Parser{}
+		file, _ := parser.ParseFile("fuzz", NewLexStream(NewLexer(strings.NewReader(src))))
+
+		parents := []Node{}
+		last := []Position{{}} // Where the previous sibling ended, for each level
+		Inspect(file, func(n Node) bool {
+			if n == nil {
+				parents = parents[:len(parents)-1]
+				last = last[:len(last)-1]
+				return true
+			}
+			if n.End().before(n.Pos()) {
+				t.Fatalf("%T ends at %s, before it starts at %s", n, n.End(), n.Pos())
+			}
+			if len(parents) > 0 {
+				parent := parents[len(parents)-1]
+				if n.Pos().before(parent.Pos()) || parent.End().before(n.End()) {
+					t.Fatalf("%T at %s-%s is outside its parent %T at %s-%s", n, n.Pos(), n.End(), parent, parent.Pos(), parent.End())
+				}
+			}
+			if n.Pos().before(last[len(last)-1]) {
+				t.Fatalf("%T at %s starts before the node before it ends at %s", n, n.Pos(), last[len(last)-1])
+			}
+			last[len(last)-1] = n.End()
+			parents = append(parents, n)
+			last = append(last, Position{})
+			return true
+		})
+	})
+}
@@ -0,0 +1,276 @@
+package noot
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strings"
+)
+
+// --------------------------------------------------------------------------------
+// - Go Backend
+// --------------------------------------------------------------------------------
+// Translates a checked FileNode into Go source, so noot code can be compiled straight into a Go program. Each noot function or method becomes a Go function or method with the same name and signature, and each declared type a Go defined type.
+// noot's operators and precedences are a subset of Go's and its typing rules are stricter, so anything the checker accepts translates almost word for word. The differences are handled here: Go rejects variables that are never read, and some noot names are Go keywords.
+// Runtime errors like division by zero become Go panics rather than errors. Constant expressions that Go would refuse to compile, like x / 0 or 9223372036854775807 + 1, are already rejected by the checker.
+
+// GenerateGo writes the file as a Go source file in package pkg. The file should have passed the type checker
+func GenerateGo(file *FileNode, pkg string) ([]byte, error) {
+	g := goGen{}
+	g.printf("// Code generated by noot from %s. DO NOT EDIT.\n\npackage %s\n", file.filename, pkg)
+	for _, node := range file.nodes {
+		switch n := node.(type) {
+		case *TypeNode:
+			if n.isStruct() {
+				g.printf("\ntype %s struct {\n", goName(n.name))
+				for _, f := range n.fields {
+					g.printf("%s %s\n", goName(f.name), goType(f.kind))
+				}
+				g.printf("}\n")
+				continue
+			}
+			g.printf("\ntype %s %s\n", goName(n.name), goType(n.kind))
+		case *FuncNode:
+			g.function(n)
+		case *PackageNode:
+			// The Go package comes from pkg. Imports aren't skipped, they have to be linked into the file first
+		default:
+			return nil, fmt.Errorf("%s: cannot generate Go for %T", node.Pos(), node)
+		}
+	}
+
+	out, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid Go: %v", err) // A bug in the generator, not the input
+	}
+	return out, nil
+}
+
+type goGen struct {
+	buf bytes.Buffer
+	unused map[*VarNode]bool // Variables in the current function that are never read
+}
+
+func (g *goGen) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// goName keeps noot identifiers that are reserved in Go from clashing
+func goName(name string) string {
+	if token.IsKeyword(name) || name == "init" || name == "_" {
+		return name + "_"
+	}
+	return name
+}
+
+func goType(name string) string {
+	if name == "float" {
+		return "float64"
+	}
+	return goName(name)
+}
+
+func (g *goGen) function(f *FuncNode) {
+	params := f.arguments.(*ArgNode).args
+	args := make([]string, len(params))
+	for i := range params {
+		args[i] = goName(params[i].name) + " " + goType(params[i].kind)
+	}
+	recv := ""
+	scope := make(map[string]*VarNode)
+	if f.recv != nil {
+		r := f.recv.args[0]
+		recv = "(" + goName(r.name) + " " + goType(r.kind) + ") "
+		scope[r.name] = nil
+	}
+	g.printf("\nfunc %s%s(%s) %s ", recv, goName(f.funcName), strings.Join(args, ", "), goType(f.returnType))
+
+	body := f.body.(*CurlyScope)
+	g.unused = make(map[*VarNode]bool)
+	for i := range params {
+		scope[params[i].name] = nil
+	}
+	g.findUnused(body.nodes, []map[string]*VarNode{scope})
+
+	g.block(body)
+	g.printf("\n")
+}
+
+// findUnused resolves every variable read in the statements, and marks the variables that are declared but never read. Go refuses to compile those, so they get a blank assignment
+func (g *goGen) findUnused(nodes []Node, scopes []map[string]*VarNode) {
+	read := func(expr Node) {
+		Inspect(expr, func(n Node) bool {
+			ident, ok := n.(*UnaryNode)
+			if !ok || ident.token.token != IDENT {
+				return true
+			}
+			for i := len(scopes) - 1; i >= 0; i-- {
+				if v, ok := scopes[i][ident.token.str]; ok {
+					if v != nil {
+						delete(g.unused, v)
+					}
+					break
+				}
+			}
+			return true
+		})
+	}
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *VarNode:
+			if n.expr != nil {
+				read(n.expr)
+			}
+			scopes[len(scopes)-1][n.name] = n
+			g.unused[n] = true
+		case *AssignNode:
+			read(n.expr)
+		case *FieldAssignNode:
+			read(n.target) // Go counts assigning to a field as using the variable
+			read(n.expr)
+		case *ReturnNode:
+			if n.expr != nil {
+				read(n.expr)
+			}
+		case *ExprStmtNode:
+			read(n.expr)
+		case *IfNode:
+			read(n.cond)
+			g.findUnused([]Node{n.then}, scopes)
+			if n.els != nil {
+				g.findUnused([]Node{n.els}, scopes)
+			}
+		case *ForNode:
+			if n.cond != nil {
+				read(n.cond)
+			}
+			g.findUnused([]Node{n.body}, scopes)
+		case *CurlyScope:
+			g.findUnused(n.nodes, append(scopes, make(map[string]*VarNode)))
+		}
+	}
+}
+
+func (g *goGen) block(n *CurlyScope) {
+	g.printf("{\n")
+	for _, node := range n.nodes {
+		g.stmt(node)
+		g.printf("\n")
+	}
+	g.printf("}")
+}
+
+func (g *goGen) stmt(node Node) {
+	switch n := node.(type) {
+	case *ReturnNode:
+		g.printf("return")
+		if n.expr != nil {
+			g.printf(" %s", goExpr(n.expr, 0))
+		}
+	case *VarNode:
+		name := goName(n.name)
+		if n.kind == "" {
+			g.printf("%s := %s", name, goExpr(n.expr, 0))
+		} else {
+			g.printf("var %s %s", name, goType(n.kind))
+			if n.expr != nil {
+				g.printf(" = %s", goExpr(n.expr, 0))
+			}
+		}
+		if g.unused[n] {
+			g.printf("\n_ = %s", name)
+		}
+	case *AssignNode:
+		g.printf("%s = %s", goName(n.name), goExpr(n.expr, 0))
+	case *FieldAssignNode:
+		g.printf("%s = %s", goExpr(n.target, 0), goExpr(n.expr, 0))
+	case *IfNode:
+		g.printf("if %s ", goExpr(n.cond, 0))
+		g.block(n.then.(*CurlyScope))
+		switch els := n.els.(type) {
+		case *IfNode:
+			g.printf(" else ")
+			g.stmt(els)
+		case *CurlyScope:
+			g.printf(" else ")
+			g.block(els)
+		}
+	case *ForNode:
+		g.printf("for ")
+		if n.cond != nil {
+			g.printf("%s ", goExpr(n.cond, 0))
+		}
+		g.block(n.body.(*CurlyScope))
+	case *BranchNode:
+		g.printf("%s", n.keyword)
+	case *ExprStmtNode:
+		g.printf("%s", goExpr(n.expr, 0))
+	case *CurlyScope:
+		g.block(n)
+	}
+}
+
+// goPrefixPrec is tighter than any binary operator, and goSelectorPrec is tighter again
+const (
+	goPrefixPrec = 6
+	goSelectorPrec = 7
+)
+
+// goExpr prints an expression that is an operand of an operator with precedence prec. Parentheses are added where the tree wouldn't survive being reparsed without them, which only matters for trees that weren't made by the parser
+func goExpr(node Node, prec int) string {
+	switch n := node.(type) {
+	case *UnaryNode:
+		if n.token.token == IDENT {
+			return goName(n.token.str)
+		}
+		return n.token.str
+	case *ExprNode:
+		return "(" + goExpr(n.expr, 0) + ")"
+	case *PrefixExprNode:
+		operand := goExpr(n.expr, goPrefixPrec)
+		if strings.HasPrefix(operand, "-") {
+			operand = "(" + operand + ")" // -(-x) rather than --x
+		}
+		return n.op.String() + operand
+	case *BinaryExprNode:
+		p := opPrec(n.op)
+		s := goExpr(n.left, p) + " " + n.op.String() + " " + goExpr(n.right, p+1)
+		if p < prec {
+			return "(" + s + ")"
+		}
+		return s
+	case *CallExprNode:
+		args := make([]string, len(n.args))
+		for i := range n.args {
+			args[i] = goExpr(n.args[i], 0)
+		}
+		return goExpr(n.fn, 0) + "(" + strings.Join(args, ", ") + ")"
+	case *SelectorNode:
+		s := goExpr(n.expr, goSelectorPrec)
+		if _, ok := n.expr.(*PrefixExprNode); ok {
+			s = "(" + s + ")"
+		}
+		return s + "." + goName(n.name)
+	case *CompositeLitNode:
+		fields := make([]string, len(n.fields))
+		for i, f := range n.fields {
+			fields[i] = goExpr(f.expr, 0)
+			if f.name != "" {
+				fields[i] = goName(f.name) + ": " + fields[i]
+			}
+		}
+		return goType(n.kind) + "{" + strings.Join(fields, ", ") + "}"
+	}
+	return ""
+}
+
+func opPrec(op Operator) int {
+	for _, b := range binaryOps {
+		if b.op == op {
+			return b.prec
+		}
+	}
+	return 0
+}
@@ -0,0 +1,282 @@
+package noot
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const gogenProgram = `type Count int
+
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func collatz(n int) Count {
+	var steps Count
+	for n != 1 {
+		if n / 2 * 2 == n {
+			n = n / 2
+		} else if n > 0 {
+			n = 3 * n + 1
+		} else {
+			return -1
+		}
+		steps = steps + 1
+	}
+	return steps
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	d := 2
+	for {
+		if d * d > n {
+			break
+		}
+		if n / d * d == n {
+			return false
+		}
+		d = d + 1
+	}
+	return true
+}
+
+func shadow(range int) int {
+	unused := 1
+	x := range
+	if x > 0 {
+		x := x * 2
+		range = x
+	}
+	return - -x + range
+}
+
+func nothing(n int) {
+	var ignored bool = n > 3
+	return
+}
+`
+
+const gogenStructs = `type Vec2 struct {
+	X float
+	func_ float
+}
+
+type Box struct {
+	Min Vec2
+	Max Vec2
+}
+
+type P Vec2
+
+func (v Vec2) Scale(k float) Vec2 {
+	return Vec2{v.X * k, v.func_ * k}
+}
+
+func (b Box) Size() Vec2 {
+	var unused Box
+	var size Vec2
+	size.X = b.Max.X - b.Min.X
+	size.func_ = b.Max.func_ - b.Min.func_
+	return size.Scale(2)
+}
+
+func area(b Box) float {
+	s := b.Size()
+	p := P{X: 1}
+	if p.X == 1 && s != (Vec2{}) {
+		return s.X * s.func_
+	}
+	return 0
+}
+`
+
+// generateChecked checks the source and generates Go from it
+func generateChecked(t *testing.T, name, src string) (*FileNode, []byte) {
+	t.Helper()
+	s := &source{name: name, data: []byte(src)}
+	file, _, errs := s.check()
+	if len(errs) > 0 {
+		t.Fatalf("%s: %v", name, errs)
+	}
+	out, err := GenerateGo(file, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, out
+}
+
+func TestGenerateGoTypeChecks(t *testing.T) {
+	data, err := os.ReadFile("input.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]string{"input.test": string(data), "program.noot": gogenProgram, "structs.noot": gogenStructs}
+	for name, src := range sources {
+		_, out := generateChecked(t, name, src)
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, name+".go", out, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf := types.Config{Importer: importer.Default()}
+		if _, err := conf.Check("main", fset, []*ast.File{f}, nil); err != nil {
+			t.Errorf("%s: %v\n%s", name, err, out)
+		}
+	}
+}
+
+// Go rejects constant expressions that overflow or divide by zero, so the checker has to reject them before any Go is generated. The ones it lets through have to be valid Go
+func TestGenerateGoConstants(t *testing.T) {
+	tests := []struct {
+		kind string
+		expr string
+		ok bool
+	}{
+		{"int", "x / 0", false},
+		{"int", "x / (1 - 1)", false},
+		{"int", "9223372036854775807 + 1 + x", false},
+		{"int", "x + 3037000500 * 3037000500", false},
+		{"int", "x + 99999999999999999999", false},
+		{"float", "x / 0.0", false},
+		{"float", "x * (1e308 * 10)", false},
+		{"int", "x / (1 - 2)", true},
+		{"int", "9223372036854775806 + 1 - x", true},
+		{"int", "x + -9223372036854775807 - 1", true},
+		{"float", "x / 0.5 + 1e308 * 1.5", true},
+	}
+	for _, test := range tests {
+		src := "func F(x " + test.kind + ") " + test.kind + " {\n\treturn " + test.expr + "\n}\n"
+		file, _, errs := (&source{name: "consts.noot", data: []byte(src)}).check()
+		if !test.ok {
+			if len(errs) == 0 {
+				t.Errorf("%s: expected the checker to reject it", test.expr)
+			}
+			continue
+		}
+		if len(errs) > 0 {
+			t.Fatalf("%s: %v", test.expr, errs)
+		}
+		out, err := GenerateGo(file, "main")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "consts.go", out, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&types.Config{}).Check("main", fset, []*ast.File{f}, nil); err != nil {
+			t.Errorf("%s: %v\n%s", test.expr, err, out)
+		}
+	}
+}
+
+// Builds the generated code into a program and checks that it prints the same as the interpreter
+func TestGenerateGoMatchesInterpreter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the go tool")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	data, err := os.ReadFile("input.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		src string
+		calls [][]int
+		funcs []string
+	}{
+		{"input.test", string(data), [][]int{{-3, 7}, {0, 0}, {1, 2}, {10, 0}}, []string{"FunctionA", "FunctionB", "FunctionC"}},
+		{"program.noot", gogenProgram, [][]int{{1}, {2}, {7}, {12}, {-4}}, []string{"fib", "collatz", "isPrime", "shadow"}},
+	}
+
+	for _, test := range tests {
+		file, out := generateChecked(t, test.name, test.src)
+		in := NewInterpreter(file)
+
+		driver := strings.Builder{}
+		want := strings.Builder{}
+		driver.WriteString("package main\n\nimport \"fmt\"\n\nfunc main() {\n")
+		for _, name := range test.funcs {
+			for _, args := range test.calls {
+				strs := make([]string, len(args))
+				for i := range args {
+					strs[i] = fmt.Sprint(args[i])
+				}
+				fmt.Fprintf(&driver, "\tfmt.Println(%s(%s))\n", goName(name), strings.Join(strs, ", "))
+
+				v, err := in.Call(name, args...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if in.funcs[name].returnType == "bool" {
+					fmt.Fprintln(&want, v != 0)
+				} else {
+					fmt.Fprintln(&want, v)
+				}
+			}
+		}
+		driver.WriteString("}\n")
+
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "gen.go"), out, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(driver.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(gobin, "run", "gen.go", "main.go")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GO111MODULE=off")
+		got, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s\n%s", test.name, err, got, out)
+		}
+		if string(got) != want.String() {
+			t.Errorf("%s: generated Go printed:\n%s\ninterpreter gave:\n%s", test.name, got, want.String())
+		}
+	}
+}
+
+func TestGoExprParens(t *testing.T) {
+	// Trees built by hand, like the ones later passes make, don't carry ExprNodes for their grouping
+	one := &UnaryNode{PackedToken{token: INT, str: "1"}}
+	x := &UnaryNode{PackedToken{token: IDENT, str: "x"}}
+	sum := &BinaryExprNode{op: OpAdd, left: x, right: one}
+	tests := []struct {
+		node Node
+		want string
+	}{
+		{&BinaryExprNode{op: OpMul, left: sum, right: sum}, "(x + 1) * (x + 1)"},
+		{&BinaryExprNode{op: OpSub, left: sum, right: sum}, "x + 1 - (x + 1)"},
+		{&PrefixExprNode{op: OpSub, expr: sum}, "-(x + 1)"},
+		{&PrefixExprNode{op: OpSub, expr: &PrefixExprNode{op: OpSub, expr: x}}, "-(-x)"},
+		{&UnaryNode{PackedToken{token: IDENT, str: "range"}}, "range_"},
+	}
+	for _, test := range tests {
+		if got := goExpr(test.node, 0); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package noot
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Each testdata/golden/NAME.noot is run through the front end and the results are compared against the files next to it:
+//   NAME.tokens  the token dump, like `noot tokens`
+//   NAME.json    the AST, like `noot ast --format=json`
+//   NAME.diag    the parse errors, or the type errors if it parsed, as Renderer prints them
+// Run `go test -run TestGolden -update` to rewrite them after an intended change, and check the diff.
+var update = flag.Bool("update", false, "rewrite the golden files in testdata instead of comparing against them")
+
+func TestGolden(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("testdata", "golden", "*.noot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) == 0 {
+		t.Fatal("no golden sources found")
+	}
+
+	for _, path := range sources {
+		base := strings.TrimSuffix(path, ".noot")
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tokens := bytes.Buffer{}
+			printTokens(&tokens, lexAll(bytes.NewReader(src)))
+			golden(t, base+".tokens", tokens.Bytes())
+
+			parser := Parser{}
+			file, errs := parser.ParseFile(filepath.Base(path), NewLexStream(NewLexer(bytes.NewReader(src))))
+			ast, err := MarshalAST(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, base+".json", append(ast, '\n'))
+
+			if len(errs) == 0 {
+				errs = NewChecker().Check(file)
+			}
+			diags := bytes.Buffer{}
+			NewRenderer(NewSourceFile(filepath.Base(path), src), false).RenderAll(&diags, errs)
+			golden(t, base+".diag", diags.Bytes())
+		})
+	}
+}
+
+// golden compares got against the golden file at path, or rewrites the file with -update
+func golden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if diff := unifiedDiff(path, want, got); diff != "" {
+		t.Errorf("output differs from %s (run go test -update if this is intended):\n%s", path, diff)
+	}
+}
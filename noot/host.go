@@ -0,0 +1,355 @@
+package noot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// --------------------------------------------------------------------------------
+// - Host API
+// --------------------------------------------------------------------------------
+// For embedding noot in a Go program: register Go functions for scripts to call, load scripts and call their functions.
+//   rt := noot.New()
+//   rt.Register("sqrt", math.Sqrt)
+//   tick := rt.Load(src).Func("physicsTick")
+//   result, err := tick.Call(1.5, 2)
+// Values cross over as Go ints, floats and bools of any size, and strings, which become noot's int, float, bool and string. Ints can also be passed for float parameters. A Go struct can be passed for a struct parameter, its fields are matched to the script's by name, and struct results come back as a map from field names to values. Scripts run on the bytecode VM.
+
+// Runtime holds the Go functions that scripts can call, and the limits they run under
+type Runtime struct {
+	hosts []*hostFunc
+	decls map[string]*FuncNode // What the checker sees of each host function
+	limit int
+}
+
+func New() *Runtime {
+	return &Runtime{
+		decls: make(map[string]*FuncNode),
+	}
+}
+
+// SetInstructionLimit makes calls into scripts fail once they have run n VM instructions, so a script can't hang its host. 0, the default, means no limit. It applies to scripts loaded afterwards
+func (r *Runtime) SetInstructionLimit(n int) {
+	r.limit = n
+}
+
+// Register makes fn callable from scripts loaded afterwards. fn must be a function whose parameters are ints, floats, bools or strings, and which returns one of those, nothing, or either followed by an error. A non-nil error, or a panic, stops the script and is returned from Call
+func (r *Runtime) Register(name string, fn any) error {
+	if !isIdent(name) || keywords[name] {
+		return fmt.Errorf("cannot register %q: not a valid name", name)
+	}
+	if _, exists := r.decls[name]; exists {
+		return fmt.Errorf("cannot register %s: already registered", name)
+	}
+
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.IsVariadic() {
+		return fmt.Errorf("cannot register %s: %T is not a function with fixed parameters", name, fn)
+	}
+
+	decl := &FuncNode{funcName: name, arguments: &ArgNode{}}
+	args := decl.arguments.(*ArgNode)
+	for i := 0; i < t.NumIn(); i++ {
+		kind := hostKind(t.In(i))
+		if kind == "" {
+			return fmt.Errorf("cannot register %s: unsupported parameter type %s", name, t.In(i))
+		}
+		args.args = append(args.args, Arg{name: fmt.Sprintf("p%d", i), kind: kind})
+	}
+
+	results := t.NumOut()
+	hasErr := results > 0 && t.Out(results-1) == reflect.TypeOf((*error)(nil)).Elem()
+	if hasErr {
+		results--
+	}
+	if results > 1 {
+		return fmt.Errorf("cannot register %s: too many results", name)
+	}
+	if results == 1 {
+		decl.returnType = hostKind(t.Out(0))
+		if decl.returnType == "" {
+			return fmt.Errorf("cannot register %s: unsupported result type %s", name, t.Out(0))
+		}
+	}
+
+	call := func(vm *VM, args []int) (result int, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		in := make([]reflect.Value, len(args))
+		for i := range args {
+			in[i] = fromValue(vm, args[i], t.In(i))
+		}
+		out := v.Call(in)
+		if hasErr {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return 0, err
+			}
+		}
+		if results == 0 {
+			return 0, nil
+		}
+		return toValue(vm, out[0])
+	}
+
+	r.hosts = append(r.hosts, &hostFunc{name, t.NumIn(), call})
+	r.decls[name] = decl
+	return nil
+}
+
+func isIdent(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isIdentStart(r) && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// hostKind is the noot type of a Go type, or "" if it has none
+func hostKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Bool:
+		return "bool"
+	case reflect.String:
+		return "string"
+	}
+	return ""
+}
+
+// scriptKind is the builtin type that a noot type is based on, if Go values can be converted to it
+func scriptKind(t *Type) string {
+	switch t.kind {
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindBool:
+		return "bool"
+	case KindString:
+		return "string"
+	}
+	return ""
+}
+
+// toValue converts a Go value to a VM value. The kind has already been checked. Strings are added to vm's strings
+func toValue(vm *VM, v reflect.Value) (int, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt {
+			return 0, fmt.Errorf("%d overflows int", v.Uint())
+		}
+		return int(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return floatToValue(v.Float()), nil
+	case reflect.Bool:
+		return boolToInt(v.Bool()), nil
+	case reflect.String:
+		return vm.intern(v.String()), nil
+	}
+	panic("unsupported kind " + v.Kind().String())
+}
+
+// fromValue converts a VM value to the Go type t
+func fromValue(vm *VM, x int, t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(x))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(x))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(valueToFloat(x))
+	case reflect.Bool:
+		v.SetBool(x != 0)
+	case reflect.String:
+		v.SetString(vm.strings[x])
+	}
+	return v
+}
+
+// Diagnostics are the problems found in a script that failed to load
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	lines := make([]string, len(d))
+	for i := range d {
+		lines[i] = d[i].Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Script is a loaded and compiled script. A script runs one call at a time: it can't be called from more than one goroutine at once, or from a host function that it is running
+type Script struct {
+	checker *Checker
+	vm *VM
+	err error
+}
+
+// Load parses, checks and compiles a script against the functions registered so far. Problems are kept until they can be returned from Err or Call, so that calls can be chained
+func (r *Runtime) Load(src string) *Script {
+	if strconv.IntSize < 64 {
+		return &Script{err: errors.New("noot scripts need 64 bit ints")}
+	}
+
+	parser := Parser{}
+	file, errs := parser.ParseFile("", NewLexStream(NewLexer(strings.NewReader(src))))
+	if len(errs) > 0 {
+		return &Script{err: Diagnostics(errs)}
+	}
+
+	checker := NewChecker()
+	for name, decl := range r.decls {
+		checker.funcs[name] = decl
+	}
+	if errs := checker.Check(file); len(errs) > 0 {
+		return &Script{err: Diagnostics(errs)}
+	}
+
+	hosts := make([]*hostFunc, len(r.hosts))
+	copy(hosts, r.hosts)
+	program, errs := compileChecked(file, checker, hosts)
+	if len(errs) > 0 {
+		return &Script{err: Diagnostics(errs)}
+	}
+
+	vm := NewVM(program)
+	vm.SetLimit(r.limit)
+	return &Script{checker: checker, vm: vm}
+}
+
+// Err returns the errors that stopped the script from loading, as Diagnostics if there were problems in the source
+func (s *Script) Err() error {
+	return s.err
+}
+
+// Func is a function in a script that can be called from Go
+type Func struct {
+	script *Script
+	decl *FuncNode
+	err error
+}
+
+// Func looks up a function declared in the script
+func (s *Script) Func(name string) *Func {
+	if s.err != nil {
+		return &Func{err: s.err}
+	}
+	decl, ok := s.checker.funcs[name]
+	if !ok || decl.body == nil {
+		return &Func{err: fmt.Errorf("undefined function: %s", name)}
+	}
+	return &Func{script: s, decl: decl}
+}
+
+// Call runs the function. The arguments are converted to the function's parameter types, and the result comes back as an int, float64 or bool, a map[string]any for a struct, or nil if the function has no result. Runtime errors in the script are returned as *RuntimeError
+func (f *Func) Call(args ...any) (any, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	name := f.decl.funcName
+	params := f.decl.arguments.(*ArgNode).args
+	if len(args) != len(params) {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, len(params), len(args))
+	}
+	f.script.vm.clearStrings()
+	values := []int{}
+	for i := range args {
+		t := f.script.checker.resolveTypeQuiet(params[i].kind)
+		if args[i] == nil {
+			return nil, fmt.Errorf("argument %s of %s: cannot use <nil> as %s", params[i].name, name, t)
+		}
+		var err error
+		values, err = toSlots(f.script.vm, values, reflect.ValueOf(args[i]), t)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s of %s: %v", params[i].name, name, err)
+		}
+	}
+
+	results, err := f.script.vm.call(name, values)
+	if err != nil {
+		return nil, err
+	}
+	if f.decl.returnType == "" {
+		return nil, nil
+	}
+	return fromSlots(f.script.vm, results, f.script.checker.resolveTypeQuiet(f.decl.returnType)), nil
+}
+
+// toSlots converts a Go value to the VM slots of a value of type t and appends them to values. The fields of a struct are looked up by name, so the Go struct can have more of them, in any order. Strings are added to vm's strings
+func toSlots(vm *VM, values []int, v reflect.Value, t *Type) ([]int, error) {
+	switch t.kind {
+	case KindStruct:
+		if v.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("cannot use %s as %s", v.Type(), t)
+		}
+		for _, field := range t.fields {
+			fv := v.FieldByName(field.name)
+			if !fv.IsValid() {
+				return nil, fmt.Errorf("cannot use %s as %s: missing field %s", v.Type(), t, field.name)
+			}
+			var err error
+			if values, err = toSlots(vm, values, fv, field.t); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+
+	want := scriptKind(t)
+	if want == "" {
+		return nil, fmt.Errorf("%s values can't be passed in from Go", t)
+	}
+	got := hostKind(v.Type())
+	if got == "int" && want == "float" {
+		v = v.Convert(reflect.TypeOf(float64(0)))
+		got = want
+	}
+	if got != want {
+		return nil, fmt.Errorf("cannot use %s as %s", v.Type(), t)
+	}
+	x, err := toValue(vm, v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use %s as %s: %v", v.Type(), t, err)
+	}
+	return append(values, x), nil
+}
+
+// fromSlots converts the VM slots of a value of type t to an int, float64, bool or string, or for a struct to a map from its field names to their values
+func fromSlots(vm *VM, xs []int, t *Type) any {
+	switch t.kind {
+	case KindString:
+		return vm.strings[xs[0]]
+	case KindFloat:
+		return valueToFloat(xs[0])
+	case KindBool:
+		return xs[0] != 0
+	case KindStruct:
+		m := make(map[string]any, len(t.fields))
+		for _, field := range t.fields {
+			n := slots(field.t)
+			m[field.name] = fromSlots(vm, xs[:n], field.t)
+			xs = xs[n:]
+		}
+		return m
+	}
+	return xs[0]
+}
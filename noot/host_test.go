@@ -0,0 +1,380 @@
+package noot
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const physicsScript = `
+type Meters float
+
+func physicsTick(x Meters, vx Meters, dt Meters) Meters {
+	return x + vx * dt
+}
+
+func speed(vx float, vy float) float {
+	return sqrt(vx * vx + vy * vy)
+}
+
+func moving(vx float) bool {
+	return vx < -0.001 || vx > 0.001
+}
+
+func steps(n int) int {
+	total := 0
+	for n > 0 {
+		total = total + n
+		n = n - 1
+	}
+	return total
+}
+
+func spin() {
+	for {}
+}
+
+func half(n int) float {
+	return scale(n, 0.5)
+}
+
+func ratio(a int, b int) int {
+	return a / b
+}
+
+func check(n int) {
+	validate(n)
+}
+
+func boom() int {
+	return explode()
+}
+
+func big() int {
+	return huge()
+}
+`
+
+func physicsRuntime(t *testing.T) *Runtime {
+	t.Helper()
+	rt := New()
+	if err := rt.Register("sqrt", math.Sqrt); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Register("scale", func(n int32, by float32) float32 { return float32(n) * by }); err != nil {
+		t.Fatal(err)
+	}
+	err := rt.Register("validate", func(n uint8) error {
+		if n > 10 {
+			return errors.New("too big")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Register("explode", func() int { panic("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Register("huge", func() uint64 { return math.MaxUint64 }); err != nil {
+		t.Fatal(err)
+	}
+	return rt
+}
+
+func TestHostCalls(t *testing.T) {
+	script := physicsRuntime(t).Load(physicsScript)
+	if err := script.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fn string
+		args []any
+		want any
+	}{
+		{"physicsTick", []any{1.5, 2.0, 0.25}, 2.0},
+		{"physicsTick", []any{float32(-1), float32(4), 0.5}, 1.0},
+		{"speed", []any{3.0, 4.0}, 5.0},
+		{"speed", []any{3, uint8(4)}, 5.0},
+		{"moving", []any{0.0}, false},
+		{"moving", []any{-2.5}, true},
+		{"steps", []any{100}, 5050},
+		{"steps", []any{uint16(4)}, 10},
+		{"half", []any{5}, 2.5},
+		{"check", []any{3}, nil},
+	}
+	for _, test := range tests {
+		got, err := script.Func(test.fn).Call(test.args...)
+		if err != nil {
+			t.Errorf("%s%v: %v", test.fn, test.args, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s%v: got %v (%T), want %v (%T)", test.fn, test.args, got, got, test.want, test.want)
+		}
+	}
+}
+
+func TestHostErrors(t *testing.T) {
+	rt := physicsRuntime(t)
+	rt.SetInstructionLimit(10000)
+	script := rt.Load(physicsScript)
+
+	tests := []struct {
+		fn string
+		args []any
+		want string
+	}{
+		{"spin", nil, "instruction limit of 10000 exceeded"},
+		{"steps", []any{1000000}, "instruction limit of 10000 exceeded"},
+		{"ratio", []any{1, 0}, "34:11: division by zero"},
+		{"check", []any{11}, "validate: too big"},
+		{"boom", nil, "explode: panic: boom"},
+		{"big", nil, "huge: 18446744073709551615 overflows int"},
+		{"missing", nil, "undefined function: missing"},
+		{"sqrt", []any{1.0}, "undefined function: sqrt"},
+		{"speed", []any{1.0}, "speed expects 2 arguments, got 1"},
+		{"speed", []any{true, 2.0}, "argument vx of speed: cannot use bool as float"},
+		{"steps", []any{uint64(math.MaxUint64)}, "argument n of steps: cannot use uint64 as int: 18446744073709551615 overflows int"},
+		{"moving", []any{"fast"}, "argument vx of moving: cannot use string as float"},
+		{"moving", []any{nil}, "argument vx of moving: cannot use <nil> as float"},
+	}
+	for _, test := range tests {
+		_, err := script.Func(test.fn).Call(test.args...)
+		if err == nil || !strings.HasSuffix(err.Error(), test.want) {
+			t.Errorf("%s%v: expected error %q, got %v", test.fn, test.args, test.want, err)
+		}
+	}
+
+	// Runtime errors come back as *RuntimeError, and the script can be called again afterwards
+	_, err := script.Func("ratio").Call(1, 0)
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Errorf("expected a *RuntimeError, got %T", err)
+	}
+	if got, err := script.Func("steps").Call(3); err != nil || got != 6 {
+		t.Errorf("script broken after an error: %v %v", got, err)
+	}
+}
+
+// The same components as the ECS in iterators/physics_test.go
+type ecsPosition struct {
+	X, Y, Z float32
+}
+
+type ecsVelocity struct {
+	X, Y, Z float32
+}
+
+func TestHostStructs(t *testing.T) {
+	script := New().Load(`
+type Position struct {
+	X float
+	Y float
+	Z float
+}
+
+type Velocity struct {
+	X float
+	Y float
+	Z float
+}
+
+func (p Position) len2() float {
+	return p.X * p.X + p.Y * p.Y + p.Z * p.Z
+}
+
+func physicsTick(pos Position, vel Velocity, dt float) Position {
+	pos.X = pos.X + vel.X * dt
+	pos.Y = pos.Y + vel.Y * dt
+	pos.Z = pos.Z + vel.Z * dt
+	return pos
+}
+
+func speed2(vel Velocity) float {
+	return Position{vel.X, vel.Y, vel.Z}.len2()
+}
+`)
+	if err := script.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := script.Func("physicsTick").Call(ecsPosition{1, 2, 3}, ecsVelocity{1, -2, 0.5}, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"X": 1.5, "Y": 1.0, "Z": 3.25}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, err := script.Func("speed2").Call(ecsVelocity{1, 2, 2}); err != nil || got != 9.0 {
+		t.Errorf("got %v %v, want 9", got, err)
+	}
+
+	tests := []struct {
+		args []any
+		want string
+	}{
+		{[]any{ecsPosition{}, struct{ X, Y float64 }{}, 1.0}, "argument vel of physicsTick: cannot use struct { X float64; Y float64 } as Velocity: missing field Z"},
+		{[]any{ecsPosition{}, struct{ X, Y, Z bool }{}, 1.0}, "argument vel of physicsTick: cannot use bool as float"},
+		{[]any{1.0, ecsVelocity{}, 1.0}, "argument pos of physicsTick: cannot use float64 as Position"},
+	}
+	for _, test := range tests {
+		if _, err := script.Func("physicsTick").Call(test.args...); err == nil || err.Error() != test.want {
+			t.Errorf("%v: expected %q, got %v", test.args, test.want, err)
+		}
+	}
+}
+
+func TestHostStrings(t *testing.T) {
+	rt := New()
+	if err := rt.Register("upper", strings.ToUpper); err != nil {
+		t.Fatal(err)
+	}
+	script := rt.Load(`
+type Tag struct {
+	Name string
+	N int
+}
+
+func greet(name string) string {
+	return "hello, " + name
+}
+
+func shout(s string) string {
+	return upper(s + "!")
+}
+
+func rename(tag Tag) Tag {
+	tag.Name = tag.Name + "!"
+	return tag
+}
+`)
+	if err := script.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if got, err := script.Func("greet").Call("noot"); err != nil || got != "hello, noot" {
+			t.Fatalf("got %v %v, want hello, noot", got, err)
+		}
+	}
+	if got, err := script.Func("shout").Call("hey"); err != nil || got != "HEY!" {
+		t.Errorf("got %v %v, want HEY!", got, err)
+	}
+	// Strings made during a call are dropped before the next one
+	if n := len(script.vm.strings); n > 8 {
+		t.Errorf("expected the strings of old calls to be dropped, have %d", n)
+	}
+	got, err := script.Func("rename").Call(struct {
+		Name string
+		N int
+	}{"a", 2})
+	want := map[string]any{"Name": "a!", "N": 2}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v %v, want %v", got, err, want)
+	}
+	if _, err := script.Func("greet").Call(1); err == nil || err.Error() != "argument name of greet: cannot use int as string" {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}
+
+func TestHostLoadErrors(t *testing.T) {
+	rt := physicsRuntime(t)
+
+	// Errors are kept until Call so that the whole chain can be written in one go
+	_, err := rt.Load("func f() int {\n\treturn nope(1)\n}\n").Func("f").Call()
+	var diags Diagnostics
+	if !errors.As(err, &diags) || err.Error() != "2:9: undefined: nope" {
+		t.Errorf("expected diagnostics, got %v", err)
+	}
+
+	tests := []struct {
+		src string
+		want string
+	}{
+		{"func f( {", "1:9: expected IDENT, found {"},
+		{"func f() int { return sqrt(1) }", "1:16: cannot return float as int"},
+		{"func f(a float) { sqrt(true) }", "1:24: cannot use bool as float in argument to sqrt"},
+		{"func sqrt(a float) float { return a }", "1:6: sqrt redeclared"},
+	}
+	for _, test := range tests {
+		err := rt.Load(test.src).Err()
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%q: expected %q, got %v", test.src, test.want, err)
+		}
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	rt := New()
+	if err := rt.Register("ok", func() {}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		fn any
+		want string
+	}{
+		{"ok", func() {}, "cannot register ok: already registered"},
+		{"for", func() {}, `cannot register "for": not a valid name`},
+		{"1x", func() {}, `cannot register "1x": not a valid name`},
+		{"f", 3, "cannot register f: int is not a function with fixed parameters"},
+		{"f", func(...int) {}, "cannot register f: func(...int) is not a function with fixed parameters"},
+		{"f", func(chan int) {}, "cannot register f: unsupported parameter type chan int"},
+		{"f", func() []int { return nil }, "cannot register f: unsupported result type []int"},
+		{"f", func() (int, int) { return 0, 0 }, "cannot register f: too many results"},
+	}
+	for _, test := range tests {
+		if err := rt.Register(test.name, test.fn); err == nil || err.Error() != test.want {
+			t.Errorf("%s: expected %q, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+// Float code on the VM has to give exactly what Go does
+func TestVMFloats(t *testing.T) {
+	script := New().Load(`
+func arith(a float, b float) float {
+	return -(a * b - a / b) + 1.5 * 2
+}
+func compare(a float, b float) int {
+	n := 0
+	if a < b { n = n + 1 }
+	if a <= b { n = n + 10 }
+	if a == b { n = n + 100 }
+	if a != b { n = n + 1000 }
+	if 1 < a { n = n + 10000 }
+	return n
+}
+`)
+	values := []float64{0, 1, -2.5, 3.25, math.Inf(1), math.NaN()}
+	for _, a := range values {
+		for _, b := range values {
+			got, err := script.Func("arith").Call(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := -(a*b - a/b) + 1.5*2
+			if g := got.(float64); g != want && !(math.IsNaN(g) && math.IsNaN(want)) {
+				t.Errorf("arith(%v, %v): got %v, want %v", a, b, g, want)
+			}
+
+			got, err = script.Func("compare").Call(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			if a < b { n += 1 }
+			if a <= b { n += 10 }
+			if a == b { n += 100 }
+			if a != b { n += 1000 }
+			if 1 < a { n += 10000 }
+			if got != n {
+				t.Errorf("compare(%v, %v): got %v, want %v", a, b, got, n)
+			}
+		}
+	}
+}
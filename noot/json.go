@@ -0,0 +1,342 @@
+package noot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// --------------------------------------------------------------------------------
+// - JSON
+// --------------------------------------------------------------------------------
+// A stable JSON encoding of the tree for tools that don't link against noot. The file is wrapped in {"version": N, "file": ...} and every node is an object with a "node" field naming its type. Fields that are empty are left out.
+// Bump astVersion whenever a field is renamed or its meaning changes, adding a new optional field doesn't need a bump.
+
+const astVersion = 1
+
+type jsonAST struct {
+	Version int `json:"version"`
+	File *jsonNode `json:"file"`
+}
+
+type jsonPos struct {
+	Line int `json:"line"`
+	Column int `json:"column"`
+}
+
+// jsonNode is the wire format of every node type. Each type only fills in the fields it has
+type jsonNode struct {
+	Node string `json:"node"`
+	Pos *jsonPos `json:"pos,omitempty"`
+	FuncPos *jsonPos `json:"funcPos,omitempty"`
+	Open *jsonPos `json:"open,omitempty"` // The opening brace of a composite literal
+	Close *jsonPos `json:"close,omitempty"` // The closing brace or paren
+	End *jsonPos `json:"end,omitempty"`
+
+	Filename string `json:"filename,omitempty"`
+	Name string `json:"name,omitempty"`
+	NamePos *jsonPos `json:"namePos,omitempty"`
+	Kind string `json:"kind,omitempty"`
+	KindPos *jsonPos `json:"kindPos,omitempty"`
+	PathPos *jsonPos `json:"pathPos,omitempty"`
+	ReturnType string `json:"returnType,omitempty"`
+	ReturnPos *jsonPos `json:"returnPos,omitempty"`
+	Keyword string `json:"keyword,omitempty"`
+	Op string `json:"op,omitempty"`
+	Token string `json:"token,omitempty"`
+	Value string `json:"value,omitempty"`
+	Short bool `json:"short,omitempty"`
+
+	Receiver *jsonNode `json:"receiver,omitempty"`
+	Arguments *jsonNode `json:"arguments,omitempty"`
+	Fn *jsonNode `json:"fn,omitempty"`
+	Cond *jsonNode `json:"cond,omitempty"`
+	Then *jsonNode `json:"then,omitempty"`
+	Else *jsonNode `json:"else,omitempty"`
+	Left *jsonNode `json:"left,omitempty"`
+	Right *jsonNode `json:"right,omitempty"`
+	Target *jsonNode `json:"target,omitempty"`
+	Expr *jsonNode `json:"expr,omitempty"`
+	Body *jsonNode `json:"body,omitempty"`
+	Args []*jsonNode `json:"args,omitempty"`
+	Fields []*jsonNode `json:"fields,omitempty"`
+	Nodes []*jsonNode `json:"nodes,omitempty"`
+}
+
+// MarshalAST encodes a parsed file as indented JSON
+func MarshalAST(file *FileNode) ([]byte, error) {
+	return json.MarshalIndent(jsonAST{astVersion, astJSON(file)}, "", "  ")
+}
+
+// UnmarshalAST decodes the output of MarshalAST back into a tree
+func UnmarshalAST(data []byte) (*FileNode, error) {
+	ast := jsonAST{}
+	if err := json.Unmarshal(data, &ast); err != nil {
+		return nil, err
+	}
+	if ast.Version != astVersion {
+		return nil, fmt.Errorf("unsupported ast version %d, expected %d", ast.Version, astVersion)
+	}
+	if ast.File == nil {
+		return nil, fmt.Errorf("missing file")
+	}
+
+	node, err := nodeFromJSON(ast.File)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := node.(*FileNode)
+	if !ok {
+		return nil, fmt.Errorf("expected a FileNode at the top, found %s", ast.File.Node)
+	}
+	return file, nil
+}
+
+func posJSON(p Position) *jsonPos {
+	if p == (Position{}) {
+		return nil
+	}
+	return &jsonPos{p.line, p.column}
+}
+
+func posFromJSON(p *jsonPos) Position {
+	if p == nil {
+		return Position{}
+	}
+	return Position{p.Line, p.Column}
+}
+
+func astJSON(node Node) *jsonNode {
+	if node == nil {
+		return nil
+	}
+
+	list := func(nodes []Node) []*jsonNode {
+		out := make([]*jsonNode, len(nodes))
+		for i := range nodes {
+			out[i] = astJSON(nodes[i])
+		}
+		return out
+	}
+
+	switch n := node.(type) {
+	case *FileNode:
+		return &jsonNode{Node: "FileNode", Filename: n.filename, Nodes: list(n.nodes)}
+	case *FuncNode:
+		j := &jsonNode{Node: "FuncNode", Pos: posJSON(n.pos), FuncPos: posJSON(n.funcPos), Name: n.funcName, Arguments: astJSON(n.arguments), ReturnType: n.returnType, ReturnPos: posJSON(n.returnPos), Body: astJSON(n.body)}
+		if n.recv != nil {
+			j.Receiver = astJSON(n.recv)
+		}
+		return j
+	case *ArgNode:
+		return &jsonNode{Node: "ArgNode", Pos: posJSON(n.lparen), Args: argsJSON(n.args), Close: posJSON(n.rparen)}
+	case *PackageNode:
+		return &jsonNode{Node: "PackageNode", Pos: posJSON(n.pos), Name: n.name, NamePos: posJSON(n.namePos)}
+	case *ImportNode:
+		return &jsonNode{Node: "ImportNode", Pos: posJSON(n.pos), Value: n.lit, PathPos: posJSON(n.pathPos)}
+	case *TypeNode:
+		return &jsonNode{Node: "TypeNode", Pos: posJSON(n.pos), Name: n.name, NamePos: posJSON(n.namePos), Kind: n.kind, KindPos: posJSON(n.kindPos), Fields: argsJSON(n.fields), Close: posJSON(n.rbrace)}
+	case *CurlyScope:
+		return &jsonNode{Node: "CurlyScope", Pos: posJSON(n.pos), Nodes: list(n.nodes), Close: posJSON(n.rbrace)}
+	case *ReturnNode:
+		return &jsonNode{Node: "ReturnNode", Pos: posJSON(n.pos), Expr: astJSON(n.expr)}
+	case *VarNode:
+		return &jsonNode{Node: "VarNode", Pos: posJSON(n.pos), Name: n.name, NamePos: posJSON(n.namePos), Kind: n.kind, KindPos: posJSON(n.kindPos), Expr: astJSON(n.expr), Short: n.short}
+	case *AssignNode:
+		return &jsonNode{Node: "AssignNode", Pos: posJSON(n.pos), Name: n.name, Expr: astJSON(n.expr)}
+	case *FieldAssignNode:
+		return &jsonNode{Node: "FieldAssignNode", Target: astJSON(n.target), Expr: astJSON(n.expr)}
+	case *IfNode:
+		return &jsonNode{Node: "IfNode", Pos: posJSON(n.pos), Cond: astJSON(n.cond), Then: astJSON(n.then), Else: astJSON(n.els)}
+	case *ForNode:
+		return &jsonNode{Node: "ForNode", Pos: posJSON(n.pos), Cond: astJSON(n.cond), Body: astJSON(n.body)}
+	case *BranchNode:
+		return &jsonNode{Node: "BranchNode", Pos: posJSON(n.pos), Keyword: n.keyword}
+	case *ExprStmtNode:
+		return &jsonNode{Node: "ExprStmtNode", Expr: astJSON(n.expr)}
+	case *ExprNode:
+		return &jsonNode{Node: "ExprNode", Pos: posJSON(n.pos), Expr: astJSON(n.expr), Close: posJSON(n.rparen)}
+	case *BinaryExprNode:
+		return &jsonNode{Node: "BinaryExprNode", Pos: posJSON(n.pos), Op: n.op.String(), Left: astJSON(n.left), Right: astJSON(n.right)}
+	case *PrefixExprNode:
+		return &jsonNode{Node: "PrefixExprNode", Pos: posJSON(n.pos), Op: n.op.String(), Expr: astJSON(n.expr)}
+	case *CallExprNode:
+		return &jsonNode{Node: "CallExprNode", Pos: posJSON(n.pos), Fn: astJSON(n.fn), Args: list(n.args), Close: posJSON(n.rparen)}
+	case *SelectorNode:
+		return &jsonNode{Node: "SelectorNode", Expr: astJSON(n.expr), Name: n.name, NamePos: posJSON(n.namePos)}
+	case *CompositeLitNode:
+		fields := make([]*jsonNode, len(n.fields))
+		for i, f := range n.fields {
+			fields[i] = &jsonNode{Node: "FieldInit", Name: f.name, NamePos: posJSON(f.namePos), Expr: astJSON(f.expr)}
+		}
+		return &jsonNode{Node: "CompositeLitNode", Pos: posJSON(n.pos), Kind: n.kind, Open: posJSON(n.lbrace), Fields: fields, Close: posJSON(n.rbrace)}
+	case *UnaryNode:
+		return &jsonNode{Node: "UnaryNode", Pos: posJSON(n.token.pos), Token: n.token.token.String(), Value: n.token.str}
+	case *BadNode:
+		return &jsonNode{Node: "BadNode", Pos: posJSON(n.pos), End: posJSON(n.end)}
+	}
+	panic(fmt.Sprintf("astJSON: unknown node %T", node))
+}
+
+func argsJSON(args []Arg) []*jsonNode {
+	out := make([]*jsonNode, len(args))
+	for i, arg := range args {
+		out[i] = &jsonNode{Node: "Arg", Pos: posJSON(arg.pos), Name: arg.name, Kind: arg.kind, KindPos: posJSON(arg.kindPos)}
+	}
+	return out
+}
+
+func argsFromJSON(list []*jsonNode, node string) ([]Arg, error) {
+	args := []Arg{}
+	for _, a := range list {
+		if a == nil || a.Node != "Arg" {
+			return nil, fmt.Errorf("%s: expected Arg entries", node)
+		}
+		args = append(args, Arg{a.Name, a.Kind, posFromJSON(a.Pos), posFromJSON(a.KindPos)})
+	}
+	return args, nil
+}
+
+// nodeFromJSON is the inverse of astJSON. Children that every node of a type has are required, optional ones may be missing
+func nodeFromJSON(j *jsonNode) (Node, error) {
+	var err error
+	// required and optional decode a child and remember the first error, so that the cases below can stay one liners
+	optional := func(c *jsonNode) Node {
+		if c == nil || err != nil {
+			return nil
+		}
+		var n Node
+		n, err = nodeFromJSON(c)
+		return n
+	}
+	required := func(c *jsonNode, field string) Node {
+		if c == nil && err == nil {
+			err = fmt.Errorf("%s at %s: missing %s", j.Node, posFromJSON(j.Pos), field)
+		}
+		return optional(c)
+	}
+	list := func(cs []*jsonNode, field string) []Node {
+		out := []Node{}
+		for _, c := range cs {
+			out = append(out, required(c, field))
+		}
+		return out
+	}
+	operator := func() Operator {
+		for i := range operators {
+			if Operator(i) != OpNone && operators[i] == j.Op {
+				return Operator(i)
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("%s at %s: unknown operator %q", j.Node, posFromJSON(j.Pos), j.Op)
+		}
+		return OpNone
+	}
+
+	pos := posFromJSON(j.Pos)
+	var node Node
+	switch j.Node {
+	case "FileNode":
+		node = &FileNode{filename: j.Filename, nodes: list(j.Nodes, "nodes")}
+	case "FuncNode":
+		f := &FuncNode{funcPos: posFromJSON(j.FuncPos), pos: pos, funcName: j.Name, arguments: required(j.Arguments, "arguments"), returnType: j.ReturnType, returnPos: posFromJSON(j.ReturnPos), body: required(j.Body, "body")}
+		if _, ok := f.arguments.(*ArgNode); !ok && err == nil {
+			err = fmt.Errorf("FuncNode at %s: arguments must be an ArgNode", pos)
+		}
+		if recv := optional(j.Receiver); recv != nil {
+			f.recv, _ = recv.(*ArgNode)
+			if f.recv == nil && err == nil {
+				err = fmt.Errorf("FuncNode at %s: receiver must be an ArgNode", pos)
+			}
+		}
+		node = f
+	case "ArgNode":
+		args, err := argsFromJSON(j.Args, j.Node)
+		if err != nil {
+			return nil, err
+		}
+		node = &ArgNode{pos, args, posFromJSON(j.Close)}
+	case "PackageNode":
+		node = &PackageNode{pos, j.Name, posFromJSON(j.NamePos)}
+	case "ImportNode":
+		path, perr := strconv.Unquote(j.Value)
+		if perr != nil {
+			return nil, fmt.Errorf("ImportNode at %s: invalid path %s", pos, j.Value)
+		}
+		node = &ImportNode{pos, path, j.Value, posFromJSON(j.PathPos)}
+	case "TypeNode":
+		fields, err := argsFromJSON(j.Fields, j.Node)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			fields = nil
+		}
+		node = &TypeNode{pos, j.Name, posFromJSON(j.NamePos), j.Kind, posFromJSON(j.KindPos), fields, posFromJSON(j.Close)}
+	case "CurlyScope":
+		node = &CurlyScope{pos, list(j.Nodes, "nodes"), posFromJSON(j.Close)}
+	case "ReturnNode":
+		node = &ReturnNode{pos, optional(j.Expr)}
+	case "VarNode":
+		node = &VarNode{pos, j.Name, posFromJSON(j.NamePos), j.Kind, posFromJSON(j.KindPos), optional(j.Expr), j.Short}
+	case "AssignNode":
+		node = &AssignNode{pos, j.Name, required(j.Expr, "expr")}
+	case "FieldAssignNode":
+		target, _ := required(j.Target, "target").(*SelectorNode)
+		if target == nil && err == nil {
+			err = fmt.Errorf("FieldAssignNode: target must be a SelectorNode")
+		}
+		node = &FieldAssignNode{target, required(j.Expr, "expr")}
+	case "IfNode":
+		node = &IfNode{pos, required(j.Cond, "cond"), required(j.Then, "then"), optional(j.Else)}
+	case "ForNode":
+		node = &ForNode{pos, optional(j.Cond), required(j.Body, "body")}
+	case "BranchNode":
+		node = &BranchNode{pos, j.Keyword}
+	case "ExprStmtNode":
+		node = &ExprStmtNode{required(j.Expr, "expr")}
+	case "ExprNode":
+		node = &ExprNode{pos, required(j.Expr, "expr"), posFromJSON(j.Close)}
+	case "BinaryExprNode":
+		node = &BinaryExprNode{pos, operator(), required(j.Left, "left"), required(j.Right, "right")}
+	case "PrefixExprNode":
+		node = &PrefixExprNode{pos, operator(), required(j.Expr, "expr")}
+	case "CallExprNode":
+		node = &CallExprNode{pos, required(j.Fn, "fn"), list(j.Args, "args"), posFromJSON(j.Close)}
+	case "SelectorNode":
+		node = &SelectorNode{required(j.Expr, "expr"), j.Name, posFromJSON(j.NamePos)}
+	case "CompositeLitNode":
+		lit := &CompositeLitNode{pos: pos, kind: j.Kind, lbrace: posFromJSON(j.Open), rbrace: posFromJSON(j.Close)}
+		for _, f := range j.Fields {
+			if f == nil || f.Node != "FieldInit" {
+				return nil, fmt.Errorf("CompositeLitNode: expected FieldInit entries")
+			}
+			lit.fields = append(lit.fields, FieldInit{f.Name, posFromJSON(f.NamePos), required(f.Expr, "expr")})
+		}
+		node = lit
+	case "UnaryNode":
+		tok, ok := tokenFromString(j.Token)
+		if !ok {
+			return nil, fmt.Errorf("UnaryNode at %s: unknown token %q", pos, j.Token)
+		}
+		node = &UnaryNode{PackedToken{pos, tok, j.Value}}
+	case "BadNode":
+		node = &BadNode{pos, posFromJSON(j.End)}
+	default:
+		return nil, fmt.Errorf("unknown node type %q", j.Node)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func tokenFromString(s string) (Token, bool) {
+	for i := range tokens {
+		if tokens[i] == s {
+			return Token(i), true
+		}
+	}
+	return ILLEGAL, false
+}
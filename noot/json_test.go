@@ -0,0 +1,135 @@
+package noot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	src := `type X int
+
+type V struct {
+	A X
+	B float
+}
+
+func (v V) Sum(n int) X {
+	v.A = v.A + X(n)
+	return v.A + V{A: 1}.A + V{2, 0.5}.A
+}
+
+func add(x X, y int) X {
+	return x + X(y)
+}
+
+func main() int {
+	var total int = 0
+	i := 0
+	for i < 10 {
+		i = i + 1
+		if i == 3 || !(i < 8) {
+			continue
+		} else if i == 9 {
+			break
+		}
+		total = total + -i * 2
+	}
+	add(1, 2)
+	return total
+}
+
+func broken( {
+	return 1 +
+}
+`
+	file, errs := parseString(t, src)
+	if len(errs) == 0 {
+		t.Fatal("expected the broken function to produce errors")
+	}
+
+	data, err := MarshalAST(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnmarshalAST(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(file, decoded) {
+		t.Errorf("round trip changed the tree\nbefore: %s\nafter:  %s", sexpr(file), sexpr(decoded))
+	}
+
+	again, err := MarshalAST(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("encoding isn't stable:\n%s\n%s", data, again)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	file, _ := parseString(t, "return -x\n")
+	data, err := MarshalAST(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "version": 1,
+  "file": {
+    "node": "FileNode",
+    "filename": "test",
+    "nodes": [
+      {
+        "node": "ReturnNode",
+        "pos": {
+          "line": 1,
+          "column": 1
+        },
+        "expr": {
+          "node": "PrefixExprNode",
+          "pos": {
+            "line": 1,
+            "column": 8
+          },
+          "op": "-",
+          "expr": {
+            "node": "UnaryNode",
+            "pos": {
+              "line": 1,
+              "column": 9
+            },
+            "token": "IDENT",
+            "value": "x"
+          }
+        }
+      }
+    ]
+  }
+}`
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestJSONDecodeErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`{"version": 2, "file": {"node": "FileNode"}}`, "unsupported ast version 2"},
+		{`{"version": 1}`, "missing file"},
+		{`{"version": 1, "file": {"node": "ReturnNode"}}`, "expected a FileNode"},
+		{`{"version": 1, "file": {"node": "FileNode", "nodes": [{"node": "Lambda"}]}}`, `unknown node type "Lambda"`},
+		{`{"version": 1, "file": {"node": "FileNode", "nodes": [{"node": "AssignNode", "pos": {"line": 2, "column": 3}, "name": "x"}]}}`, "AssignNode at 2:3: missing expr"},
+		{`{"version": 1, "file": {"node": "FileNode", "nodes": [{"node": "ReturnNode", "expr": {"node": "BinaryExprNode", "op": "%"}}]}}`, `unknown operator "%"`},
+		{`{"version": 1, "file": {"node": "FileNode", "nodes": [{"node": "ReturnNode", "expr": {"node": "UnaryNode", "token": "WORD"}}]}}`, `unknown token "WORD"`},
+	}
+	for _, test := range tests {
+		_, err := UnmarshalAST([]byte(test.src))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.src, err, test.err)
+		}
+	}
+}
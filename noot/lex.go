@@ -1,8 +1,9 @@
-package main
+package noot
 
 import (
+	"fmt"
 	"io"
-	"bufio"
+	"unicode/utf8"
 	"unicode"
 )
 
@@ -13,6 +14,8 @@ const (
 	ILLEGAL
 	IDENT
 	INT
+	FLOAT
+	STRING
 	SEMI // ;
 	COMMA // ;
 
@@ -22,12 +25,25 @@ const (
 	MUL // *
 	DIV // /
 
+	EQL // ==
+	NEQ // !=
+	LSS // <
+	GTR // >
+	LEQ // <=
+	GEQ // >=
+	LAND // &&
+	LOR // ||
+	NOT // !
+
 	ASSIGN // =
+	DEFINE // :=
 
 	LPAREN // (
 	RPAREN // )
 	LBRACE // {
 	RBRACE // }
+	DOT // .
+	COLON // :
 )
 
 var tokens = []string{
@@ -36,6 +52,8 @@ var tokens = []string{
 	IDENT:   "IDENT",
 
 	INT:     "INT",
+	FLOAT:   "FLOAT",
+	STRING:  "STRING",
 	SEMI:    ";",
 	COMMA:    ",",
 
@@ -45,180 +63,444 @@ var tokens = []string{
 	MUL: "MUL",
 	DIV: "DIV",
 
+	EQL: "==",
+	NEQ: "!=",
+	LSS: "<",
+	GTR: ">",
+	LEQ: "<=",
+	GEQ: ">=",
+	LAND: "&&",
+	LOR: "||",
+	NOT: "!",
+
 	ASSIGN: "=",
+	DEFINE: ":=",
 
 	LPAREN: "(",
 	RPAREN: ")",
 	LBRACE: "{",
 	RBRACE: "}",
+	DOT: ".",
+	COLON: ":",
 }
 
 func (t Token) String() string {
 	return tokens[t]
 }
 
+// errCommentNotTerminated is the text of the ILLEGAL token for a block comment that runs into the end of the input
+const errCommentNotTerminated = "comment not terminated"
+
+// keywords are lexed as IDENTs, the parser tells them apart by name
+var keywords = map[string]bool{
+	"func": true,
+	"return": true,
+	"type": true,
+	"var": true,
+	"if": true,
+	"else": true,
+	"for": true,
+	"break": true,
+	"continue": true,
+	"struct": true,
+	"package": true,
+	"import": true,
+}
+
 type Position struct {
 	line   int
 	column int
 }
 
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.line, p.column)
+}
+
+func (p Position) before(q Position) bool {
+	return p.line < q.line || p.line == q.line && p.column < q.column
+}
+
+// Comment is a // or /* */ comment. The parser never sees these, the lexer keeps them on the side for tools like the formatter
+type Comment struct {
+	pos Position
+	text string // Including the // or /* */
+}
+
+// Lexer scans source that is held in memory. Tokens are offsets into the source, and their text is only made into a string when it is asked for, so scanning allocates nothing but comments. Identifiers are interned, so every use of a name shares one string
 type Lexer struct {
 	lastToken Token
-	pos    Position
-	reader *bufio.Reader
+	pos    Position // Of the last rune read
+	src []byte
+	off int // Offset of the next rune to read
+	comments []Comment // Every comment seen so far, in order
+	idents map[string]string
+	err error // Why reading the input stopped early, reported as an ILLEGAL token at the end
+	reported bool
 }
 
+// NewLexer reads all of reader into memory to lex it. If reading fails, the lexer lexes what it got and then reports the error as an ILLEGAL token
 func NewLexer(reader io.Reader) *Lexer {
+	src, err := io.ReadAll(reader)
+	l := NewByteLexer(src)
+	l.err = err
+	return l
+}
+
+// NewByteLexer lexes src, which must not change while it is being lexed
+func NewByteLexer(src []byte) *Lexer {
 	return &Lexer{
 		lastToken: ILLEGAL,
 		pos:    Position{line: 1, column: 0},
-		reader: bufio.NewReader(reader),
+		src: src,
+		idents: make(map[string]string),
 	}
 }
 
+// Span is a token as offsets into the source. Inserted semicolons and EOF are empty
+type Span struct {
+	pos Position
+	token Token
+	start, end int
+}
+
 // Lex scans the input for the next token. It returns the position of the token,
 // the token's type, and the literal value.
 func (l *Lexer) Lex() (Position, Token, string) {
+	s := l.Scan()
+	return s.pos, s.token, l.Text(s)
+}
+
+// Text is the literal value of a token: the source text for everything but semicolons and EOF
+func (l *Lexer) Text(s Span) string {
+	switch s.token {
+	case SEMI:
+		return ";"
+	case EOF:
+		return "EOF"
+	case IDENT:
+		return l.intern(l.src[s.start:s.end])
+	case ILLEGAL:
+		if s.start == s.end && l.err != nil {
+			return "read error: " + l.err.Error()
+		}
+		if s.end-s.start >= 2 && l.src[s.start] == '/' && l.src[s.start+1] == '*' {
+			return errCommentNotTerminated
+		}
+	}
+	return string(l.src[s.start:s.end])
+}
+
+// intern gives the one copy of an identifier. Looking up a map with a converted []byte doesn't allocate
+func (l *Lexer) intern(b []byte) string {
+	if s, ok := l.idents[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	l.idents[s] = s
+	return s
+}
+
+// Scan scans the input for the next token
+func (l *Lexer) Scan() Span {
 	// keep looping until we return a token
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				return l.pos, EOF, "EOF"
+		start := l.off
+		r, ok := l.read()
+		if !ok {
+			// EOF comes just after the last rune, so that nothing ends after it
+			pos := l.pos
+			if pos.column > 0 {
+				pos.column++
 			}
-
-			// at this point there isn't much we can do, and the compiler
-			// should just return the raw error to the user
-			panic(err)
+			if l.err != nil && !l.reported {
+				l.reported = true
+				return l.emit(pos, ILLEGAL, start, start)
+			}
+			return Span{pos, EOF, start, start}
 		}
 
-		// update the column to the position of the newly read in rune
-		l.pos.column++
-
 		switch r {
+		case ' ', '\t', '\r':
+			continue // The common spaces, before the unicode.IsSpace check below
 		case '\n':
 			// Decide if we want to add semicolon
-			if l.lastToken == IDENT || l.lastToken == RPAREN || l.lastToken == INT {
+			if l.needsSemi() {
 				l.resetPosition()
-				return l.pos, SEMI, ";"
+				return l.emit(l.pos, SEMI, start, start)
 			}
 			l.resetPosition()
 		case ';':
-			l.lastToken = SEMI
-			return l.pos, SEMI, ";"
+			return l.emit(l.pos, SEMI, start, l.off)
 		case ',':
-			l.lastToken = COMMA
-			return l.pos, COMMA, ","
+			return l.emit(l.pos, COMMA, start, l.off)
 		case '+':
-			l.lastToken = ADD
-			return l.pos, ADD, "+"
+			return l.emit(l.pos, ADD, start, l.off)
 		case '-':
-			l.lastToken = SUB
-			return l.pos, SUB, "-"
+			return l.emit(l.pos, SUB, start, l.off)
 		case '*':
-			l.lastToken = MUL
-			return l.pos, MUL, "*"
+			return l.emit(l.pos, MUL, start, l.off)
 		case '/':
-			l.lastToken = DIV
-			return l.pos, DIV, "/"
+			startPos := l.pos
+			if l.accept('/') {
+				l.skipLineComment(startPos, start)
+				continue
+			}
+			if l.accept('*') {
+				newline, closed := l.skipBlockComment(startPos, start)
+				if !closed {
+					return l.emit(startPos, ILLEGAL, start, l.off)
+				}
+				if newline && l.needsSemi() {
+					// A comment spanning lines counts as a newline
+					return l.emit(startPos, SEMI, start, start)
+				}
+				continue
+			}
+			return l.emit(startPos, DIV, start, l.off)
 		case '=':
-			l.lastToken = ASSIGN
-			return l.pos, ASSIGN, "="
+			return l.emitPair(l.pos, start, '=', EQL, ASSIGN)
+		case '!':
+			return l.emitPair(l.pos, start, '=', NEQ, NOT)
+		case '<':
+			return l.emitPair(l.pos, start, '=', LEQ, LSS)
+		case '>':
+			return l.emitPair(l.pos, start, '=', GEQ, GTR)
+		case ':':
+			return l.emitPair(l.pos, start, '=', DEFINE, COLON)
+		case '&':
+			return l.emitPair(l.pos, start, '&', LAND, ILLEGAL)
+		case '|':
+			return l.emitPair(l.pos, start, '|', LOR, ILLEGAL)
 		case '(':
-			l.lastToken = LPAREN
-			return l.pos, LPAREN, "("
+			return l.emit(l.pos, LPAREN, start, l.off)
 		case ')':
-			l.lastToken = RPAREN
-			return l.pos, RPAREN, ")"
+			return l.emit(l.pos, RPAREN, start, l.off)
 		case '{':
-			l.lastToken = LBRACE
-			return l.pos, LBRACE, "}"
+			return l.emit(l.pos, LBRACE, start, l.off)
 		case '}':
-			l.lastToken = RBRACE
-			return l.pos, RBRACE, "}"
+			return l.emit(l.pos, RBRACE, start, l.off)
+		case '.':
+			return l.emit(l.pos, DOT, start, l.off)
+		case '"':
+			startPos := l.pos
+			end, ok := l.lexString()
+			if !ok {
+				return l.emit(startPos, ILLEGAL, start, end)
+			}
+			return l.emit(startPos, STRING, start, end)
 		default:
 			if unicode.IsSpace(r) {
 				continue // nothing to do here, just move on
 			} else if unicode.IsDigit(r) {
-				// backup and let lexInt rescan the beginning of the int
 				startPos := l.pos
-				l.backup()
-				lit := l.lexInt()
-				l.lastToken = INT
-				return startPos, INT, lit
-			} else if unicode.IsLetter(r) {
-				// backup and let lexIdent rescan the beginning of the ident
+				tok := l.lexNumber()
+				return l.emit(startPos, tok, start, l.off)
+			} else if isIdentStart(r) {
 				startPos := l.pos
-				l.backup()
-				lit := l.lexIdent()
-
-				l.lastToken = IDENT
-				return startPos, IDENT, lit
+				l.lexIdent()
+				return l.emit(startPos, IDENT, start, l.off)
 			} else {
-				l.lastToken = ILLEGAL
-				return l.pos, ILLEGAL, string(r)
+				return l.emit(l.pos, ILLEGAL, start, l.off)
 			}
 		}
 	}
 }
 
+func (l *Lexer) emit(pos Position, tok Token, start, end int) Span {
+	l.lastToken = tok
+	return Span{pos, tok, start, end}
+}
+
+// emitPair emits the two character token if the next rune is second, otherwise the single character one
+func (l *Lexer) emitPair(pos Position, start int, second rune, pair, single Token) Span {
+	if l.accept(second) {
+		return l.emit(pos, pair, start, l.off)
+	}
+	// There's no single character version of some tokens, so those are ILLEGAL with what we saw
+	return l.emit(pos, single, start, l.off)
+}
+
+// needsSemi reports whether a newline after the last token should end the statement
+func (l *Lexer) needsSemi() bool {
+	switch l.lastToken {
+	case IDENT, RPAREN, RBRACE, INT, FLOAT, STRING:
+		return true
+	}
+	return false
+}
+
+// peek gives the next rune and its size in bytes without reading it. The size is 0 at the end of the input
+func (l *Lexer) peek() (rune, int) {
+	if l.off >= len(l.src) {
+		return 0, 0
+	}
+	if b := l.src[l.off]; b < utf8.RuneSelf {
+		return rune(b), 1
+	}
+	return utf8.DecodeRune(l.src[l.off:])
+}
+
+// read reads the next rune and advances the column. It returns false at the end of the input
+func (l *Lexer) read() (rune, bool) {
+	r, size := l.peek()
+	if size == 0 {
+		return 0, false
+	}
+	l.off += size
+	l.pos.column++
+	return r, true
+}
+
+// accept consumes the next rune only if it is r
+func (l *Lexer) accept(r rune) bool {
+	next, size := l.peek()
+	if size == 0 || next != r {
+		return false
+	}
+	l.off += size
+	l.pos.column++
+	return true
+}
+
+// acceptFunc consumes runes as long as they match fn. ascii is the same test for bytes below utf8.RuneSelf, which most source is, so that it can be done without decoding runes or calling through fn
+func (l *Lexer) acceptFunc(ascii func(byte) bool, fn func(rune) bool) {
+	for l.off < len(l.src) && l.src[l.off] < utf8.RuneSelf {
+		if !ascii(l.src[l.off]) {
+			return
+		}
+		l.off++
+		l.pos.column++
+	}
+	for {
+		r, size := l.peek()
+		if size == 0 || !fn(r) {
+			return
+		}
+		l.off += size
+		l.pos.column++
+	}
+}
+
 func (l *Lexer) resetPosition() {
 	l.pos.line++
 	l.pos.column = 0
 }
 
-func (l *Lexer) backup() {
-	if err := l.reader.UnreadRune(); err != nil {
-		panic(err)
-	}
-
-	l.pos.column--
+// skipLineComment skips everything up to (but not including) the next newline, so that the newline still gets a chance to insert a semicolon
+func (l *Lexer) skipLineComment(pos Position, start int) {
+	l.acceptFunc(func(b byte) bool { return b != '\n' }, func(r rune) bool { return r != '\n' })
+	l.comments = append(l.comments, Comment{pos, string(l.src[start:l.off])})
 }
 
-// lexInt scans the input until the end of an integer and then returns the
-// literal.
-func (l *Lexer) lexInt() string {
-	var lit string
+// skipBlockComment skips everything up to and including the closing */. It reports whether the comment contained a newline, and false for closed if the input ended first
+func (l *Lexer) skipBlockComment(pos Position, start int) (newline, closed bool) {
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				// at the end of the int
-				return lit
-			}
+		r, ok := l.read()
+		if !ok {
+			return newline, false
 		}
+		if r == '\n' {
+			l.resetPosition()
+			newline = true
+		}
+		if r == '*' && l.accept('/') {
+			break
+		}
+	}
+	l.comments = append(l.comments, Comment{pos, string(l.src[start:l.off])})
+	return newline, true
+}
 
-		l.pos.column++
-		if unicode.IsDigit(r) {
-			lit = lit + string(r)
-		} else {
-			// scanned something not in the integer
-			l.backup()
-			return lit
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+func isASCIIIdentPart(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '_'
+}
+
+func isASCIIDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// lexNumber scans to the end of an integer or float whose first digit has been read. Floats have a fractional part, an exponent or both: 1.5, 2e10, 1.5e-3
+func (l *Lexer) lexNumber() Token {
+	tok := INT
+	l.acceptFunc(isASCIIDigit, unicode.IsDigit)
+
+	if l.accept('.') {
+		tok = FLOAT
+		l.acceptFunc(isASCIIDigit, unicode.IsDigit)
+	}
+
+	// The exponent needs at least one digit, so look ahead before committing to it
+	rest := l.src[l.off:]
+	if len(rest) > 1 && (rest[0] == 'e' || rest[0] == 'E') {
+		digit := 1
+		if rest[1] == '+' || rest[1] == '-' {
+			digit = 2
+		}
+		if digit < len(rest) && unicode.IsDigit(rune(rest[digit])) {
+			l.off += digit
+			l.pos.column += digit
+			tok = FLOAT
+			l.acceptFunc(isASCIIDigit, unicode.IsDigit)
 		}
 	}
+	return tok
 }
 
-// lexIdent scans the input until the end of an identifier and then returns the
-// literal.
-func (l *Lexer) lexIdent() string {
-	var lit string
+// lexIdent scans to the end of an identifier whose first rune has been read
+func (l *Lexer) lexIdent() {
+	l.acceptFunc(isASCIIIdentPart, isIdentPart)
+}
+
+// lexString scans a string literal, the opening quote has already been read. The literal is the source exactly as written, quotes and escapes included, so use strconv.Unquote to get the value. It returns the offset the literal ends at, and false if the string is unterminated or has a bad escape sequence. The literal of a bad escape ends after the escape, but the rest of the string is skipped
+func (l *Lexer) lexString() (int, bool) {
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				// at the end of the identifier
-				return lit
+		r, size := l.peek()
+		if size == 0 || r == '\n' {
+			return l.off, false
+		}
+		l.read()
+
+		switch r {
+		case '"':
+			return l.off, true
+		case '\\':
+			esc, size := l.peek()
+			switch {
+			case size == 0 || esc == '\n':
+				return l.off, false
+			case esc == 'n' || esc == 't' || esc == 'r' || esc == '\\' || esc == '"':
+				l.read()
+			default:
+				l.read()
+				end := l.off
+				l.skipString()
+				return end, false
 			}
 		}
+	}
+}
 
-		l.pos.column++
-		if unicode.IsLetter(r) {
-			lit = lit + string(r)
-		} else {
-			// scanned something not in the identifier
-			l.backup()
-			return lit
+// skipString drops the rest of a broken string literal so that lexing can carry on after it
+func (l *Lexer) skipString() {
+	for {
+		r, size := l.peek()
+		if size == 0 || r == '\n' {
+			return
+		}
+		l.read()
+		switch r {
+		case '"':
+			return
+		case '\\':
+			l.read()
 		}
 	}
 }
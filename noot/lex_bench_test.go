@@ -0,0 +1,479 @@
+package noot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode"
+)
+
+// Benchmarks of the lexer against the bufio.Reader design it replaced, which is kept below as readerLexer. Run them with:
+//   go test -run NONE -bench Lex -benchmem
+
+// generateSource makes at least size bytes of noot, with a mix of names, numbers, strings and comments like real code
+func generateSource(size int) []byte {
+	buf := bytes.Buffer{}
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, "// function%d works out something\n", i)
+		fmt.Fprintf(&buf, "func function%d(count int, scale float) float {\n", i)
+		fmt.Fprintf(&buf, "\ttotal := 0.0\n")
+		fmt.Fprintf(&buf, "\tfor count > %d {\n", i%97)
+		fmt.Fprintf(&buf, "\t\ttotal = total + scale * %d.5e-3 /* scaled */\n", i)
+		fmt.Fprintf(&buf, "\t\tcount = count - 1\n")
+		fmt.Fprintf(&buf, "\t\tif total >= 1000 && count != %d || !false {\n\t\t\tbreak\n\t\t}\n", i)
+		fmt.Fprintf(&buf, "\t}\n")
+		fmt.Fprintf(&buf, "\tname := \"function %d\\n\"\n", i)
+		fmt.Fprintf(&buf, "\treturn total + function%d(count, scale)\n}\n\n", i/2)
+	}
+	return buf.Bytes()
+}
+
+const benchSize = 4 << 20
+
+// The lexer has to give exactly the tokens and comments the old one did
+func TestByteLexerMatchesReaderLexer(t *testing.T) {
+	sources := map[string][]byte{"generated": generateSource(64 << 10)}
+	paths, _ := filepath.Glob(filepath.Join("testdata", "golden", "*.noot"))
+	for _, path := range append(paths, "input.test") {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[path] = src
+	}
+	for _, src := range []string{"", "x", "\"unterminated", "\"bad \\q escape\" x", "\"a\\\nb\"", "/* unterminated", "a /* x\ny */ b", "1.e5 2e+ 3E-4x", "é9 ٣", "a & b | c"} {
+		sources[fmt.Sprintf("%q", src)] = []byte(src)
+	}
+
+	for name, src := range sources {
+		old := newReaderLexer(bytes.NewReader(src))
+		lexer := NewByteLexer(src)
+		for i := 0; ; i++ {
+			wantPos, wantTok, wantLit := old.Lex()
+			pos, tok, lit := lexer.Lex()
+			if pos != wantPos || tok != wantTok || lit != wantLit {
+				t.Fatalf("%s: token %d is %s %s %q, want %s %s %q", name, i, pos, tok, lit, wantPos, wantTok, wantLit)
+			}
+			if tok == EOF {
+				break
+			}
+		}
+		if fmt.Sprint(lexer.comments) != fmt.Sprint(old.comments) {
+			t.Errorf("%s: got comments %v, want %v", name, lexer.comments, old.comments)
+		}
+	}
+}
+
+func BenchmarkLexReader(b *testing.B) {
+	benchSource := generateSource(benchSize)
+	b.SetBytes(int64(len(benchSource)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lexer := newReaderLexer(bytes.NewReader(benchSource))
+		for {
+			if _, tok, _ := lexer.Lex(); tok == EOF {
+				break
+			}
+		}
+	}
+}
+
+// Lex makes a string for every literal, like the parser needs
+func BenchmarkLexBytes(b *testing.B) {
+	benchSource := generateSource(benchSize)
+	b.SetBytes(int64(len(benchSource)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lexer := NewByteLexer(benchSource)
+		for {
+			if _, tok, _ := lexer.Lex(); tok == EOF {
+				break
+			}
+		}
+	}
+}
+
+// Scan only finds where the tokens are
+func BenchmarkScanBytes(b *testing.B) {
+	benchSource := generateSource(benchSize)
+	b.SetBytes(int64(len(benchSource)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lexer := NewByteLexer(benchSource)
+		for lexer.Scan().token != EOF {
+		}
+	}
+}
+
+func BenchmarkParseFile(b *testing.B) {
+	benchSource := generateSource(benchSize)
+	b.SetBytes(int64(len(benchSource)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser := Parser{}
+		if _, errs := parser.ParseFile("bench", NewLexStream(NewByteLexer(benchSource))); len(errs) > 0 {
+			b.Fatal(errs[0])
+		}
+	}
+}
+
+// --------------------------------------------------------------------------------
+// - The old lexer
+// --------------------------------------------------------------------------------
+// Reads runes from a bufio.Reader and builds each literal a rune at a time
+
+type readerLexer struct {
+	lastToken Token
+	pos    Position
+	reader *bufio.Reader
+	comments []Comment // Every comment seen so far, in order
+}
+
+func newReaderLexer(reader io.Reader) *readerLexer {
+	return &readerLexer{
+		lastToken: ILLEGAL,
+		pos:    Position{line: 1, column: 0},
+		reader: bufio.NewReader(reader),
+	}
+}
+
+// Lex scans the input for the next token. It returns the position of the token,
+// the token's type, and the literal value.
+func (l *readerLexer) Lex() (Position, Token, string) {
+	// keep looping until we return a token
+	for {
+		r, ok := l.read()
+		if !ok {
+			// EOF comes just after the last rune, so that nothing ends after it
+			pos := l.pos
+			if pos.column > 0 {
+				pos.column++
+			}
+			return pos, EOF, "EOF"
+		}
+
+		switch r {
+		case '\n':
+			// Decide if we want to add semicolon
+			if l.needsSemi() {
+				l.resetPosition()
+				l.lastToken = SEMI
+				return l.pos, SEMI, ";"
+			}
+			l.resetPosition()
+		case ';':
+			return l.emit(l.pos, SEMI, ";")
+		case ',':
+			return l.emit(l.pos, COMMA, ",")
+		case '+':
+			return l.emit(l.pos, ADD, "+")
+		case '-':
+			return l.emit(l.pos, SUB, "-")
+		case '*':
+			return l.emit(l.pos, MUL, "*")
+		case '/':
+			startPos := l.pos
+			if l.accept('/') {
+				l.skipLineComment(startPos)
+				continue
+			}
+			if l.accept('*') {
+				newline, closed := l.skipBlockComment(startPos)
+				if !closed {
+					return l.emit(startPos, ILLEGAL, errCommentNotTerminated)
+				}
+				if newline && l.needsSemi() {
+					// A comment spanning lines counts as a newline
+					l.lastToken = SEMI
+					return startPos, SEMI, ";"
+				}
+				continue
+			}
+			return l.emit(startPos, DIV, "/")
+		case '=':
+			return l.emitPair(l.pos, '=', '=', EQL, ASSIGN)
+		case '!':
+			return l.emitPair(l.pos, '!', '=', NEQ, NOT)
+		case '<':
+			return l.emitPair(l.pos, '<', '=', LEQ, LSS)
+		case '>':
+			return l.emitPair(l.pos, '>', '=', GEQ, GTR)
+		case ':':
+			return l.emitPair(l.pos, ':', '=', DEFINE, COLON)
+		case '&':
+			return l.emitPair(l.pos, '&', '&', LAND, ILLEGAL)
+		case '|':
+			return l.emitPair(l.pos, '|', '|', LOR, ILLEGAL)
+		case '(':
+			return l.emit(l.pos, LPAREN, "(")
+		case ')':
+			return l.emit(l.pos, RPAREN, ")")
+		case '{':
+			return l.emit(l.pos, LBRACE, "{")
+		case '}':
+			return l.emit(l.pos, RBRACE, "}")
+		case '.':
+			return l.emit(l.pos, DOT, ".")
+		case '"':
+			startPos := l.pos
+			lit, ok := l.lexString()
+			if !ok {
+				return l.emit(startPos, ILLEGAL, lit)
+			}
+			return l.emit(startPos, STRING, lit)
+		default:
+			if unicode.IsSpace(r) {
+				continue // nothing to do here, just move on
+			} else if unicode.IsDigit(r) {
+				// backup and let lexNumber rescan the beginning of the number
+				startPos := l.pos
+				l.backup()
+				tok, lit := l.lexNumber()
+				return l.emit(startPos, tok, lit)
+			} else if isIdentStart(r) {
+				// backup and let lexIdent rescan the beginning of the ident
+				startPos := l.pos
+				l.backup()
+				lit := l.lexIdent()
+				return l.emit(startPos, IDENT, lit)
+			} else {
+				return l.emit(l.pos, ILLEGAL, string(r))
+			}
+		}
+	}
+}
+
+func (l *readerLexer) emit(pos Position, tok Token, lit string) (Position, Token, string) {
+	l.lastToken = tok
+	return pos, tok, lit
+}
+
+// emitPair emits the two character token if the next rune is second, otherwise the single character one
+func (l *readerLexer) emitPair(pos Position, first, second rune, pair, single Token) (Position, Token, string) {
+	if l.accept(second) {
+		return l.emit(pos, pair, pair.String())
+	}
+	if single == ILLEGAL {
+		// There's no single character version of this token, so just report what we saw
+		return l.emit(pos, ILLEGAL, string(first))
+	}
+	return l.emit(pos, single, single.String())
+}
+
+// needsSemi reports whether a newline after the last token should end the statement
+func (l *readerLexer) needsSemi() bool {
+	switch l.lastToken {
+	case IDENT, RPAREN, RBRACE, INT, FLOAT, STRING:
+		return true
+	}
+	return false
+}
+
+// read reads the next rune and advances the column. It returns false at the end of the input or on a read error
+func (l *readerLexer) read() (rune, bool) {
+	r, _, err := l.reader.ReadRune()
+	if err != nil {
+		return 0, false
+	}
+	l.pos.column++
+	return r, true
+}
+
+// accept consumes the next rune only if it is r
+func (l *readerLexer) accept(r rune) bool {
+	next, ok := l.read()
+	if !ok {
+		return false
+	}
+	if next != r {
+		l.backup()
+		return false
+	}
+	return true
+}
+
+func (l *readerLexer) resetPosition() {
+	l.pos.line++
+	l.pos.column = 0
+}
+
+func (l *readerLexer) backup() {
+	if err := l.reader.UnreadRune(); err != nil {
+		panic(err)
+	}
+
+	l.pos.column--
+}
+
+// skipLineComment skips everything up to (but not including) the next newline, so that the newline still gets a chance to insert a semicolon
+func (l *readerLexer) skipLineComment(pos Position) {
+	text := "//"
+	for {
+		r, ok := l.read()
+		if !ok {
+			break
+		}
+		if r == '\n' {
+			l.backup()
+			break
+		}
+		text = text + string(r)
+	}
+	l.comments = append(l.comments, Comment{pos, text})
+}
+
+// skipBlockComment skips everything up to and including the closing */. It reports whether the comment contained a newline, and false for closed if the input ended first
+func (l *readerLexer) skipBlockComment(pos Position) (newline, closed bool) {
+	text := "/*"
+	for {
+		r, ok := l.read()
+		if !ok {
+			return newline, false
+		}
+		text = text + string(r)
+		if r == '\n' {
+			l.resetPosition()
+			newline = true
+		}
+		if r == '*' && l.accept('/') {
+			text = text + "/"
+			break
+		}
+	}
+	l.comments = append(l.comments, Comment{pos, text})
+	return newline, true
+}
+
+// lexNumber scans the input until the end of an integer or float and then returns the
+// literal. Floats have a fractional part, an exponent or both: 1.5, 2e10, 1.5e-3
+func (l *readerLexer) lexNumber() (Token, string) {
+	tok := INT
+	lit := l.lexDigits()
+
+	if l.accept('.') {
+		tok = FLOAT
+		lit = lit + "." + l.lexDigits()
+	}
+
+	// The exponent needs at least one digit, so peek ahead before committing to it
+	peek, _ := l.reader.Peek(3)
+	if len(peek) > 1 && (peek[0] == 'e' || peek[0] == 'E') {
+		digit := 1
+		if peek[1] == '+' || peek[1] == '-' {
+			digit = 2
+		}
+		if digit < len(peek) && unicode.IsDigit(rune(peek[digit])) {
+			for i := 0; i < digit; i++ {
+				r, _ := l.read()
+				lit = lit + string(r)
+			}
+			tok = FLOAT
+			lit = lit + l.lexDigits()
+		}
+	}
+
+	return tok, lit
+}
+
+// lexDigits scans a run of decimal digits
+func (l *readerLexer) lexDigits() string {
+	var lit string
+	for {
+		r, ok := l.read()
+		if !ok {
+			return lit
+		}
+
+		if unicode.IsDigit(r) {
+			lit = lit + string(r)
+		} else {
+			// scanned something not in the number
+			l.backup()
+			return lit
+		}
+	}
+}
+
+// lexIdent scans the input until the end of an identifier and then returns the
+// literal.
+func (l *readerLexer) lexIdent() string {
+	var lit string
+	for {
+		r, ok := l.read()
+		if !ok {
+			// at the end of the identifier
+			return lit
+		}
+
+		if isIdentStart(r) || unicode.IsDigit(r) {
+			lit = lit + string(r)
+		} else {
+			// scanned something not in the identifier
+			l.backup()
+			return lit
+		}
+	}
+}
+
+// lexString scans a string literal, the opening quote has already been read. The literal is returned exactly as written, quotes and escapes included, so use strconv.Unquote to get the value. It returns false if the string is unterminated or has a bad escape sequence
+func (l *readerLexer) lexString() (string, bool) {
+	lit := `"`
+	for {
+		r, ok := l.read()
+		if !ok || r == '\n' {
+			if ok {
+				l.backup()
+			}
+			return lit, false
+		}
+		lit = lit + string(r)
+
+		switch r {
+		case '"':
+			return lit, true
+		case '\\':
+			esc, ok := l.read()
+			if !ok {
+				return lit, false
+			}
+			switch esc {
+			case 'n', 't', 'r', '\\', '"':
+				lit = lit + string(esc)
+			case '\n':
+				l.backup()
+				return lit, false
+			default:
+				lit = lit + string(esc)
+				l.skipString()
+				return lit, false
+			}
+		}
+	}
+}
+
+// skipString drops the rest of a broken string literal so that lexing can carry on after it
+func (l *readerLexer) skipString() {
+	for {
+		r, ok := l.read()
+		if !ok {
+			return
+		}
+		switch r {
+		case '"':
+			return
+		case '\n':
+			l.backup()
+			return
+		case '\\':
+			l.read()
+		}
+	}
+}
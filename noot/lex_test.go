@@ -0,0 +1,98 @@
+package noot
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// dumpTokens renders a token stream on one line as `line:col TOKEN lit` entries
+func dumpTokens(src string) string {
+	parts := []string{}
+	for _, t := range lexAll(strings.NewReader(src)) {
+		parts = append(parts, t.pos.String()+" "+t.String())
+	}
+	return strings.Join(parts, " | ")
+}
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"ident digits", "x1 _y a_2b",
+			`1:1 IDENT "x1" | 1:4 IDENT "_y" | 1:7 IDENT "a_2b" | 1:11 EOF`},
+		{"numbers", "1 1.5 2e10 3.25E-2 7. 4e",
+			`1:1 INT "1" | 1:3 FLOAT "1.5" | 1:7 FLOAT "2e10" | 1:12 FLOAT "3.25E-2" | 1:20 FLOAT "7." | 1:23 INT "4" | 1:24 IDENT "e" | 1:25 EOF`},
+		{"two char ops", "== != <= >= && || := = ! < >",
+			`1:1 == | 1:4 != | 1:7 <= | 1:10 >= | 1:13 && | 1:16 || | 1:19 := | 1:22 = | 1:24 ! | 1:26 < | 1:28 > | 1:29 EOF`},
+		{"lone ampersand", "a & b",
+			`1:1 IDENT "a" | 1:3 ILLEGAL "&" | 1:5 IDENT "b" | 1:6 EOF`},
+		{"strings", `"hi" "a\"b\n"`,
+			`1:1 STRING "\"hi\"" | 1:6 STRING "\"a\\\"b\\n\"" | 1:14 EOF`},
+		{"unterminated string", "\"abc\nx",
+			`1:1 ILLEGAL "\"abc" | 2:1 IDENT "x" | 2:2 EOF`},
+		{"bad escape", `"a\qb" x`,
+			`1:1 ILLEGAL "\"a\\q" | 1:8 IDENT "x" | 1:9 EOF`},
+		{"line comment", "x // comment\ny",
+			`1:1 IDENT "x" | 2:0 ; | 2:1 IDENT "y" | 2:2 EOF`},
+		{"block comment", "a /* one\ntwo */ b /* same line */ c",
+			`1:1 IDENT "a" | 1:3 ; | 2:8 IDENT "b" | 2:26 IDENT "c" | 2:27 EOF`},
+		{"unterminated block comment", "a\n/* never\nclosed",
+			`1:1 IDENT "a" | 2:0 ; | 2:1 ILLEGAL "comment not terminated" | 3:7 EOF`},
+		{"division", "a / b",
+			`1:1 IDENT "a" | 1:3 DIV | 1:5 IDENT "b" | 1:6 EOF`},
+		{"semicolon once", "x\n\n\ny",
+			`1:1 IDENT "x" | 2:0 ; | 4:1 IDENT "y" | 4:2 EOF`},
+		{"braces", "{}",
+			`1:1 { | 1:2 } | 1:3 EOF`},
+	}
+	for _, test := range tests {
+		if got := dumpTokens(test.src); got != test.want {
+			t.Errorf("%s:\n got: %s\nwant: %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLexReadError(t *testing.T) {
+	reader := io.MultiReader(strings.NewReader("x := 1"), iotest.ErrReader(errors.New("disk on fire")))
+	parts := []string{}
+	for _, t := range lexAll(reader) {
+		parts = append(parts, t.pos.String()+" "+t.String())
+	}
+	want := `1:1 IDENT "x" | 1:3 := | 1:6 INT "1" | 1:7 ILLEGAL "read error: disk on fire" | 1:7 EOF`
+	if got := strings.Join(parts, " | "); got != want {
+		t.Errorf("got:  %s\nwant: %s", got, want)
+	}
+}
+
+// Scanning doesn't allocate, and lexing only allocates a name the first time it is seen
+func TestLexAllocs(t *testing.T) {
+	src := []byte(strings.Repeat("count = count + 1 * scale\n", 100))
+	scan := testing.AllocsPerRun(10, func() {
+		lexer := NewByteLexer(src)
+		for lexer.Scan().token != EOF {
+		}
+	})
+	if scan > 2 { // The Lexer and its map of names
+		t.Errorf("Scan made %v allocations", scan)
+	}
+
+	lex := func(src []byte) float64 {
+		return testing.AllocsPerRun(10, func() {
+			lexer := NewByteLexer(src)
+			for {
+				if _, tok, _ := lexer.Lex(); tok == EOF {
+					break
+				}
+			}
+		})
+	}
+	once, many := lex([]byte("count = count + scale\n")), lex([]byte(strings.Repeat("count = count + scale\n", 100)))
+	if once != many {
+		t.Errorf("lexing the names 100 times made %v allocations, once made %v", many, once)
+	}
+}
@@ -0,0 +1,709 @@
+package noot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// --------------------------------------------------------------------------------
+// - Language Server
+// --------------------------------------------------------------------------------
+// A Language Server Protocol server for editors. Documents are fully resynced on every change and reanalysed from scratch, noot files are small enough that this is instant.
+// LSP positions are 0 based lines and UTF-16 offsets within the line, ours are 1 based lines and rune columns, so everything is converted at the edges.
+
+// JSON-RPC error codes
+const (
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams = -32602
+)
+
+type rpcError struct {
+	Code int `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcMessage is any incoming message. Requests have an ID, notifications don't
+type rpcMessage struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	Method string `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// readMessage reads one message framed with a Content-Length header
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %v", err)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return body, err
+}
+
+func writeMessage(w io.Writer, msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+type lspPosition struct {
+	Line int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI string `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range lspRange `json:"range"`
+	Severity int `json:"severity"`
+	Source string `json:"source"`
+	Message string `json:"message"`
+}
+
+type lspDocumentSymbol struct {
+	Name string `json:"name"`
+	Detail string `json:"detail"`
+	Kind int `json:"kind"`
+	Range lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+const (
+	lspSymbolMethod = 6
+	lspSymbolFunction = 12
+)
+
+type lspTextDocumentParams struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// Semantic token types, in the order they are given to the client in the legend
+var semanticTokenTypes = []string{"keyword", "function", "parameter", "variable", "type", "number", "string", "operator", "comment", "property", "method"}
+
+type LSPServer struct {
+	in *bufio.Reader
+	out io.Writer
+	docs map[string]*lspDocument
+	shutdown bool
+}
+
+func NewLSPServer(in io.Reader, out io.Writer) *LSPServer {
+	return &LSPServer{
+		in: bufio.NewReader(in),
+		out: out,
+		docs: make(map[string]*lspDocument),
+	}
+}
+
+// Serve handles messages until the client sends exit or closes the input
+func (s *LSPServer) Serve() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		msg := rpcMessage{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			continue // Notifications don't get a response, even when they fail
+		}
+		response := map[string]any{"jsonrpc": "2.0", "id": msg.ID}
+		if rerr != nil {
+			response["error"] = rerr
+		} else {
+			response["result"] = result
+		}
+		if err := writeMessage(s.out, response); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *LSPServer) notify(method string, params any) error {
+	return writeMessage(s.out, map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *LSPServer) handle(msg rpcMessage) (any, *rpcError) {
+	if s.shutdown {
+		return nil, &rpcError{rpcInvalidRequest, "server is shut down"}
+	}
+
+	params := lspTextDocumentParams{}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+	}
+	uri := params.TextDocument.URI
+	doc := s.docs[uri]
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": 1, // Full
+				"definitionProvider": true,
+				"hoverProvider": true,
+				"documentSymbolProvider": true,
+				"semanticTokensProvider": map[string]any{
+					"legend": map[string]any{"tokenTypes": semanticTokenTypes, "tokenModifiers": []string{}},
+					"full": true,
+				},
+			},
+			"serverInfo": map[string]any{"name": "noot"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		s.update(uri, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		if len(params.ContentChanges) > 0 {
+			s.update(uri, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": []lspDiagnostic{}})
+		return nil, nil
+	case "textDocument/definition":
+		if doc == nil {
+			return nil, nil
+		}
+		return doc.definition(doc.fromLSP(params.Position)), nil
+	case "textDocument/hover":
+		if doc == nil {
+			return nil, nil
+		}
+		return doc.hover(doc.fromLSP(params.Position)), nil
+	case "textDocument/documentSymbol":
+		if doc == nil {
+			return nil, nil
+		}
+		return doc.symbols(), nil
+	case "textDocument/semanticTokens/full":
+		if doc == nil {
+			return nil, nil
+		}
+		return map[string]any{"data": doc.semanticTokens()}, nil
+	}
+
+	if msg.ID == nil {
+		return nil, nil // Unknown notifications are allowed to be dropped
+	}
+	return nil, &rpcError{rpcMethodNotFound, "method not found: " + msg.Method}
+}
+
+// update reanalyses a document and sends its diagnostics
+func (s *LSPServer) update(uri, text string) {
+	doc := newLSPDocument(uri, text)
+	s.docs[uri] = doc
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": doc.diagnostics()})
+}
+
+// lspDocument is an open file and everything we worked out about it
+type lspDocument struct {
+	uri string
+	lines []string
+	tokens []PackedToken
+	comments []Comment
+	file *FileNode
+	errs []Diagnostic
+	refs []lspRef // Every identifier in the file, in source order
+}
+
+// lspRef is an identifier and what it refers to
+type lspRef struct {
+	pos Position
+	name string
+	kind string // One of semanticTokenTypes
+	def Position // Where the name is declared, zero for builtins and names that don't resolve
+	hover string
+}
+
+func newLSPDocument(uri, text string) *lspDocument {
+	d := &lspDocument{
+		uri: uri,
+		lines: strings.Split(text, "\n"),
+		tokens: lexAll(strings.NewReader(text)),
+	}
+
+	lexer := NewLexer(strings.NewReader(text))
+	parser := Parser{}
+	d.file, d.errs = parser.ParseFile(uri, NewLexStream(lexer))
+	d.comments = lexer.comments
+
+	// The checker expects a tree without holes in it, so files that don't parse only get resolved names without types
+	checker := NewChecker()
+	if len(d.errs) == 0 {
+		d.errs = checker.Check(d.file)
+	}
+	d.resolve(checker)
+	return d
+}
+
+// toLSP converts one of our positions to an LSP one
+func (d *lspDocument) toLSP(p Position) lspPosition {
+	line := p.line - 1
+	if line < 0 || line >= len(d.lines) {
+		return lspPosition{line, 0}
+	}
+	runes := []rune(d.lines[line])
+	column := p.column - 1
+	if column > len(runes) {
+		column = len(runes)
+	}
+	if column < 0 {
+		column = 0
+	}
+	return lspPosition{line, len(utf16.Encode(runes[:column]))}
+}
+
+func (d *lspDocument) fromLSP(p lspPosition) Position {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return Position{p.Line + 1, p.Character + 1}
+	}
+	units := 0
+	column := 1
+	for _, r := range d.lines[p.Line] {
+		if units >= p.Character {
+			break
+		}
+		units += len(utf16.Encode([]rune{r}))
+		column++
+	}
+	return Position{p.Line + 1, column}
+}
+
+// span is the range of a name or token of the given text starting at pos
+func (d *lspDocument) span(pos Position, text string) lspRange {
+	return lspRange{d.toLSP(pos), d.toLSP(after(pos, text))}
+}
+
+func (d *lspDocument) diagnostics() []lspDiagnostic {
+	out := []lspDiagnostic{}
+	for _, e := range d.errs {
+		// Underline the token the error is at, or a single character if there isn't one
+		text := " "
+		for _, t := range d.tokens {
+			if t.pos == e.pos && t.token != EOF && t.token != SEMI {
+				text = t.str
+				break
+			}
+		}
+		msg := e.msg
+		for _, note := range e.notes {
+			msg += "\n" + note
+		}
+		out = append(out, lspDiagnostic{d.span(e.pos, text), 1, "noot", msg})
+	}
+	return out
+}
+
+func (d *lspDocument) refAt(pos Position) *lspRef {
+	for i := range d.refs {
+		r := &d.refs[i]
+		// The cursor just after the last character still counts, that's where it is after typing a name
+		if r.pos.line == pos.line && !pos.before(r.pos) && !after(r.pos, r.name).before(pos) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (d *lspDocument) definition(pos Position) *lspLocation {
+	r := d.refAt(pos)
+	if r == nil || r.def == (Position{}) {
+		return nil
+	}
+	return &lspLocation{d.uri, d.span(r.def, r.name)}
+}
+
+func (d *lspDocument) hover(pos Position) any {
+	r := d.refAt(pos)
+	if r == nil || r.hover == "" {
+		return nil
+	}
+	return map[string]any{
+		"contents": map[string]any{"kind": "markdown", "value": "```noot\n" + r.hover + "\n```"},
+		"range": d.span(r.pos, r.name),
+	}
+}
+
+func (d *lspDocument) symbols() []lspDocumentSymbol {
+	out := []lspDocumentSymbol{}
+	for _, node := range d.file.nodes {
+		if f, ok := node.(*FuncNode); ok {
+			name, kind := f.funcName, lspSymbolFunction
+			if recv := receiver(f); recv != nil {
+				name, kind = recv.kind+"."+f.funcName, lspSymbolMethod
+			}
+			out = append(out, lspDocumentSymbol{
+				Name: name,
+				Detail: signature(f),
+				Kind: kind,
+				Range: lspRange{d.toLSP(f.Pos()), d.toLSP(f.End())},
+				SelectionRange: d.span(f.pos, f.funcName),
+			})
+		}
+	}
+	return out
+}
+
+// semanticTokens encodes a token type for every identifier, literal, operator and comment. Each token is five numbers: the line relative to the previous token, the start (relative to the previous token if on the same line), the length, the type and the modifiers
+func (d *lspDocument) semanticTokens() []int {
+	type semantic struct {
+		pos Position
+		length int // In runes
+		kind int
+	}
+	typeIndex := map[string]int{}
+	for i, t := range semanticTokenTypes {
+		typeIndex[t] = i
+	}
+
+	refs := map[Position]string{}
+	for _, r := range d.refs {
+		refs[r.pos] = r.kind
+	}
+
+	list := []semantic{}
+	for _, t := range d.tokens {
+		kind := ""
+		switch t.token {
+		case IDENT:
+			kind = "variable"
+			if keywords[t.str] {
+				kind = "keyword"
+			} else if k, ok := refs[t.pos]; ok {
+				kind = k
+			}
+		case INT, FLOAT:
+			kind = "number"
+		case STRING:
+			kind = "string"
+		case ADD, SUB, MUL, DIV, EQL, NEQ, LSS, GTR, LEQ, GEQ, LAND, LOR, NOT, ASSIGN, DEFINE:
+			kind = "operator"
+		default:
+			continue
+		}
+		list = append(list, semantic{t.pos, len([]rune(t.str)), typeIndex[kind]})
+	}
+	// Tokens can't span lines, so block comments are split up
+	for _, c := range d.comments {
+		for i, line := range strings.Split(c.text, "\n") {
+			pos := Position{c.pos.line + i, 1}
+			if i == 0 {
+				pos.column = c.pos.column
+			}
+			list = append(list, semantic{pos, len([]rune(line)), typeIndex["comment"]})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].pos.before(list[j].pos) })
+
+	data := []int{}
+	prev := lspPosition{}
+	for _, s := range list {
+		start := d.toLSP(s.pos)
+		end := d.toLSP(Position{s.pos.line, s.pos.column + s.length})
+		deltaStart := start.Character
+		if start.Line == prev.Line {
+			deltaStart -= prev.Character
+		}
+		data = append(data, start.Line-prev.Line, deltaStart, end.Character-start.Character, s.kind, 0)
+		prev = start
+	}
+	return data
+}
+
+// signature describes a function the way it was declared
+func signature(f *FuncNode) string {
+	args := []string{}
+	if a, ok := f.arguments.(*ArgNode); ok {
+		for _, arg := range a.args {
+			args = append(args, arg.name+" "+arg.kind)
+		}
+	}
+	s := "func " + f.funcName + "(" + strings.Join(args, ", ") + ")"
+	if recv := receiver(f); recv != nil {
+		s = "func (" + recv.name + " " + recv.kind + ") " + s[len("func "):]
+	}
+	if f.returnType != "" {
+		s += " " + f.returnType
+	}
+	return s
+}
+
+// receiver gives a method's receiver, or nil for functions and methods with a broken receiver list
+func receiver(f *FuncNode) *Arg {
+	if f.recv == nil || len(f.recv.args) != 1 {
+		return nil
+	}
+	return &f.recv.args[0]
+}
+
+// resolve finds every identifier in the file and what it refers to. It follows the same scoping rules as the checker, but carries on through broken code so that an editor still gets something useful while the file is being typed
+func (d *lspDocument) resolve(checker *Checker) {
+	funcs := map[string]*FuncNode{}
+	methods := map[string]map[string]*FuncNode{} // By receiver type
+	types := map[string]*TypeNode{}
+	for _, node := range d.file.nodes {
+		switch n := node.(type) {
+		case *FuncNode:
+			if n.recv != nil {
+				if recv := receiver(n); recv != nil {
+					if methods[recv.kind] == nil {
+						methods[recv.kind] = map[string]*FuncNode{}
+					}
+					methods[recv.kind][n.funcName] = n
+				}
+				continue
+			}
+			if _, exists := funcs[n.funcName]; !exists {
+				funcs[n.funcName] = n
+			}
+		case *TypeNode:
+			if _, exists := types[n.name]; !exists {
+				types[n.name] = n
+			}
+		}
+	}
+
+	add := func(r lspRef) {
+		d.refs = append(d.refs, r)
+	}
+	typeRef := func(name string, pos Position) {
+		if name == "" {
+			return
+		}
+		if t, ok := types[name]; ok {
+			add(lspRef{pos, name, "type", t.namePos, "type " + t.name + " " + t.kind})
+		} else {
+			add(lspRef{pos, name, "type", Position{}, "type " + name})
+		}
+	}
+
+	// structDecl follows a type to the struct declaration that gives it its fields
+	structDecl := func(t *Type) *TypeNode {
+		if t == nil {
+			return nil
+		}
+		n := types[t.name]
+		for i := 0; n != nil && !n.isStruct() && i < len(types); i++ {
+			n = types[n.kind]
+		}
+		if n == nil || !n.isStruct() {
+			return nil
+		}
+		return n
+	}
+	fieldRef := func(t *Type, name string, pos Position) {
+		if decl := structDecl(t); decl != nil {
+			for _, f := range decl.fields {
+				if f.name == name {
+					add(lspRef{pos, name, "property", f.pos, f.name + " " + f.kind})
+					return
+				}
+			}
+		}
+		add(lspRef{pos, name, "property", Position{}, ""})
+	}
+
+	// Scopes map names to the ref that declared them, innermost last
+	scopes := []map[string]lspRef{}
+	lookup := func(name string) (lspRef, bool) {
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if r, ok := scopes[i][name]; ok {
+				return r, true
+			}
+		}
+		return lspRef{}, false
+	}
+	declare := func(r lspRef) {
+		add(r)
+		scopes[len(scopes)-1][r.name] = r
+	}
+
+	calls := map[*SelectorNode]bool{} // Selectors that are method calls
+	expr := func(node Node) {
+		Inspect(node, func(n Node) bool {
+			switch n := n.(type) {
+			case *CallExprNode:
+				// Method names aren't expressions of their own, so they are found from the call
+				if sel, ok := n.fn.(*SelectorNode); ok {
+					calls[sel] = true
+					if t, ok := checker.exprTypes[sel.expr]; ok {
+						if f, ok := methods[t.name][sel.name]; ok {
+							add(lspRef{sel.namePos, sel.name, "method", f.pos, signature(f)})
+						}
+					}
+				}
+			case *SelectorNode:
+				if !calls[n] {
+					fieldRef(checker.exprTypes[n.expr], n.name, n.namePos)
+				}
+			case *CompositeLitNode:
+				typeRef(n.kind, n.pos)
+				for _, f := range n.fields {
+					if f.name != "" {
+						fieldRef(checker.exprTypes[n], f.name, f.namePos)
+					}
+				}
+			}
+			ident, ok := n.(*UnaryNode)
+			if !ok || ident.token.token != IDENT {
+				return true
+			}
+			name, pos := ident.token.str, ident.token.pos
+			if decl, ok := lookup(name); ok {
+				hover := decl.hover
+				if t, ok := checker.exprTypes[ident]; ok {
+					hover = name + " " + t.String()
+				}
+				add(lspRef{pos, name, decl.kind, decl.def, hover})
+			} else if f, ok := funcs[name]; ok {
+				add(lspRef{pos, name, "function", f.pos, signature(f)})
+			} else if _, ok := types[name]; ok {
+				typeRef(name, pos) // A conversion
+			}
+			return true
+		})
+	}
+
+	var stmt func(node Node)
+	stmt = func(node Node) {
+		switch n := node.(type) {
+		case *CurlyScope:
+			scopes = append(scopes, map[string]lspRef{})
+			for i := range n.nodes {
+				stmt(n.nodes[i])
+			}
+			scopes = scopes[:len(scopes)-1]
+		case *VarNode:
+			if n.expr != nil {
+				expr(n.expr)
+			}
+			typeRef(n.kind, n.kindPos)
+			kind := n.kind
+			if kind == "" {
+				if t, ok := checker.exprTypes[n.expr]; ok {
+					kind = defaultType(t).String()
+				}
+			}
+			declare(lspRef{n.namePos, n.name, "variable", n.namePos, strings.TrimSpace("var " + n.name + " " + kind)})
+		case *AssignNode:
+			if decl, ok := lookup(n.name); ok {
+				add(lspRef{n.pos, n.name, decl.kind, decl.def, decl.hover})
+			} else {
+				add(lspRef{n.pos, n.name, "variable", Position{}, ""})
+			}
+			expr(n.expr)
+		case *FieldAssignNode:
+			expr(n.target)
+			expr(n.expr)
+		case *ReturnNode:
+			if n.expr != nil {
+				expr(n.expr)
+			}
+		case *IfNode:
+			expr(n.cond)
+			stmt(n.then)
+			if n.els != nil {
+				stmt(n.els)
+			}
+		case *ForNode:
+			if n.cond != nil {
+				expr(n.cond)
+			}
+			stmt(n.body)
+		case *ExprStmtNode:
+			expr(n.expr)
+		}
+	}
+
+	for _, node := range d.file.nodes {
+		switch n := node.(type) {
+		case *TypeNode:
+			add(lspRef{n.namePos, n.name, "type", n.namePos, "type " + n.name + " " + n.kind})
+			if !n.isStruct() {
+				typeRef(n.kind, n.kindPos)
+			}
+			for _, f := range n.fields {
+				add(lspRef{f.pos, f.name, "property", f.pos, f.name + " " + f.kind})
+				typeRef(f.kind, f.kindPos)
+			}
+		case *FuncNode:
+			scopes = []map[string]lspRef{{}}
+			if n.recv == nil {
+				add(lspRef{n.pos, n.funcName, "function", n.pos, signature(n)})
+			} else {
+				add(lspRef{n.pos, n.funcName, "method", n.pos, signature(n)})
+				for _, arg := range n.recv.args {
+					declare(lspRef{arg.pos, arg.name, "parameter", arg.pos, arg.name + " " + arg.kind})
+					typeRef(arg.kind, arg.kindPos)
+				}
+			}
+			if args, ok := n.arguments.(*ArgNode); ok {
+				for _, arg := range args.args {
+					declare(lspRef{arg.pos, arg.name, "parameter", arg.pos, arg.name + " " + arg.kind})
+					typeRef(arg.kind, arg.kindPos)
+				}
+			}
+			typeRef(n.returnType, n.returnPos)
+			// The body shares the arguments' scope
+			if body, ok := n.body.(*CurlyScope); ok {
+				for i := range body.nodes {
+					stmt(body.nodes[i])
+				}
+			}
+		}
+	}
+	sort.SliceStable(d.refs, func(i, j int) bool { return d.refs[i].pos.before(d.refs[j].pos) })
+}
@@ -0,0 +1,412 @@
+package noot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lspClient talks to an LSPServer running in the same process over a pair of pipes
+type lspClient struct {
+	t *testing.T
+	w io.WriteCloser
+	messages chan lspTestMessage
+	notifications []lspTestMessage
+	nextID int
+	done chan error
+}
+
+type lspTestMessage struct {
+	ID json.RawMessage `json:"id"`
+	Method string `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error *rpcError `json:"error"`
+}
+
+func newLSPClient(t *testing.T) *lspClient {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	c := &lspClient{
+		t: t,
+		w: clientW,
+		messages: make(chan lspTestMessage, 100),
+		done: make(chan error, 1),
+	}
+	go func() {
+		c.done <- NewLSPServer(serverR, serverW).Serve()
+		serverW.Close()
+	}()
+	// Read everything the server sends straight away, the server blocks on writes otherwise
+	go func() {
+		r := bufio.NewReader(clientR)
+		for {
+			body, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			msg := lspTestMessage{}
+			if err := json.Unmarshal(body, &msg); err != nil {
+				panic(err)
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() { clientW.Close() })
+	return c
+}
+
+func (c *lspClient) next() lspTestMessage {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("server closed the connection")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+	return lspTestMessage{}
+}
+
+func (c *lspClient) notify(method string, params any) {
+	c.t.Helper()
+	if err := writeMessage(c.w, map[string]any{"jsonrpc": "2.0", "method": method, "params": params}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and decodes its result into result. Notifications that arrive first are kept for later
+func (c *lspClient) call(method string, params any, result any) *rpcError {
+	c.t.Helper()
+	c.nextID++
+	id := c.nextID
+	if err := writeMessage(c.w, map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.next()
+		if msg.ID == nil {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		if string(msg.ID) != fmt.Sprint(id) {
+			c.t.Fatalf("got response %s, expected %d", msg.ID, id)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return nil
+	}
+}
+
+// diagnostics waits for the next publishDiagnostics notification
+func (c *lspClient) diagnostics() []lspDiagnostic {
+	c.t.Helper()
+	for len(c.notifications) == 0 {
+		c.notifications = append(c.notifications, c.next())
+	}
+	msg := c.notifications[0]
+	c.notifications = c.notifications[1:]
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %s", msg.Method)
+	}
+	params := struct {
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}{}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return params.Diagnostics
+}
+
+func (c *lspClient) open(uri, text string) {
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "languageId": "noot", "version": 1, "text": text}})
+}
+
+func at(uri string, line, character int) map[string]any {
+	return map[string]any{"textDocument": map[string]any{"uri": uri}, "position": lspPosition{line, character}}
+}
+
+const lspTestSource = `type Meters int
+
+func double(m Meters) Meters {
+	return m + m
+}
+
+func main() int {
+	total := 0
+	for total < 10 {
+		total = total + int(double(Meters(2)))
+	}
+	return total
+}
+`
+
+func TestLSPLifecycle(t *testing.T) {
+	c := newLSPClient(t)
+
+	result := struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}{}
+	if err := c.call("initialize", map[string]any{"capabilities": map[string]any{}}, &result); err != nil {
+		t.Fatal(err)
+	}
+	for _, capability := range []string{"textDocumentSync", "definitionProvider", "hoverProvider", "documentSymbolProvider", "semanticTokensProvider"} {
+		if _, ok := result.Capabilities[capability]; !ok {
+			t.Errorf("missing capability %s", capability)
+		}
+	}
+	c.notify("initialized", map[string]any{})
+
+	if err := c.call("workspace/unknown", map[string]any{}, nil); err == nil || err.Code != rpcMethodNotFound {
+		t.Errorf("expected method not found, got %v", err)
+	}
+
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.call("textDocument/hover", at("file:///a.noot", 0, 0), nil); err == nil || err.Code != rpcInvalidRequest {
+		t.Errorf("expected requests after shutdown to fail, got %v", err)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLSPDiagnostics(t *testing.T) {
+	c := newLSPClient(t)
+	uri := "file:///test.noot"
+
+	c.open(uri, "func main() int {\n\treturn missing\n}\n")
+	diags := c.diagnostics()
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diags)
+	}
+	want := lspRange{lspPosition{1, 8}, lspPosition{1, 15}}
+	if diags[0].Range != want || diags[0].Message != "undefined: missing" {
+		t.Errorf("got %+v", diags[0])
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []any{map[string]any{"text": "func main() int {\n\treturn 1 +\n}\n"}},
+	})
+	if diags := c.diagnostics(); len(diags) != 1 || diags[0].Range.Start.Line != 2 {
+		t.Errorf("expected a parse error on the last line, got %v", diags)
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "version": 3},
+		"contentChanges": []any{map[string]any{"text": "func main() int {\n\treturn 1\n}\n"}},
+	})
+	if diags := c.diagnostics(); len(diags) != 0 {
+		t.Errorf("expected the diagnostics to clear, got %v", diags)
+	}
+
+	c.notify("textDocument/didClose", map[string]any{"textDocument": map[string]any{"uri": uri}})
+	if diags := c.diagnostics(); len(diags) != 0 {
+		t.Errorf("expected closing to clear diagnostics, got %v", diags)
+	}
+}
+
+func TestLSPDefinition(t *testing.T) {
+	c := newLSPClient(t)
+	uri := "file:///test.noot"
+	c.open(uri, lspTestSource)
+	c.diagnostics()
+
+	tests := []struct {
+		line, character int
+		want *lspRange
+	}{
+		{3, 8, &lspRange{lspPosition{2, 12}, lspPosition{2, 13}}}, // m in the body -> argument
+		{2, 16, &lspRange{lspPosition{0, 5}, lspPosition{0, 11}}}, // Meters in the argument list -> type
+		{9, 25, &lspRange{lspPosition{2, 5}, lspPosition{2, 11}}}, // call of double -> function
+		{9, 35, &lspRange{lspPosition{0, 5}, lspPosition{0, 11}}}, // Meters(2) conversion -> type
+		{9, 12, &lspRange{lspPosition{7, 1}, lspPosition{7, 6}}}, // total on the right -> its declaration
+		{9, 2, &lspRange{lspPosition{7, 1}, lspPosition{7, 6}}}, // total being assigned
+		{11, 13, &lspRange{lspPosition{7, 1}, lspPosition{7, 6}}}, // Cursor just after the name
+		{9, 19, nil}, // int is a builtin
+		{4, 0, nil}, // Nothing there
+	}
+	for _, test := range tests {
+		var loc *lspLocation
+		if err := c.call("textDocument/definition", at(uri, test.line, test.character), &loc); err != nil {
+			t.Fatal(err)
+		}
+		if test.want == nil {
+			if loc != nil {
+				t.Errorf("%d:%d: expected no definition, got %+v", test.line, test.character, loc)
+			}
+			continue
+		}
+		if loc == nil || loc.URI != uri || loc.Range != *test.want {
+			t.Errorf("%d:%d: got %+v, want %+v", test.line, test.character, loc, test.want)
+		}
+	}
+}
+
+func TestLSPHover(t *testing.T) {
+	c := newLSPClient(t)
+	uri := "file:///test.noot"
+	c.open(uri, lspTestSource)
+	c.diagnostics()
+
+	tests := []struct {
+		line, character int
+		want string
+	}{
+		{3, 8, "m Meters"},
+		{2, 12, "m Meters"},
+		{9, 25, "func double(m Meters) Meters"},
+		{7, 2, "var total int"},
+		{0, 6, "type Meters int"},
+	}
+	for _, test := range tests {
+		hover := struct {
+			Contents struct {
+				Value string `json:"value"`
+			} `json:"contents"`
+		}{}
+		if err := c.call("textDocument/hover", at(uri, test.line, test.character), &hover); err != nil {
+			t.Fatal(err)
+		}
+		if want := "```noot\n" + test.want + "\n```"; hover.Contents.Value != want {
+			t.Errorf("%d:%d: got %q, want %q", test.line, test.character, hover.Contents.Value, want)
+		}
+	}
+}
+
+func TestLSPDocumentSymbols(t *testing.T) {
+	c := newLSPClient(t)
+	uri := "file:///test.noot"
+	c.open(uri, lspTestSource)
+	c.diagnostics()
+
+	symbols := []lspDocumentSymbol{}
+	if err := c.call("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}}, &symbols); err != nil {
+		t.Fatal(err)
+	}
+	want := []lspDocumentSymbol{
+		{"double", "func double(m Meters) Meters", lspSymbolFunction, lspRange{lspPosition{2, 0}, lspPosition{4, 1}}, lspRange{lspPosition{2, 5}, lspPosition{2, 11}}},
+		{"main", "func main() int", lspSymbolFunction, lspRange{lspPosition{6, 0}, lspPosition{12, 1}}, lspRange{lspPosition{6, 5}, lspPosition{6, 9}}},
+	}
+	if !reflect.DeepEqual(symbols, want) {
+		t.Errorf("got %+v\nwant %+v", symbols, want)
+	}
+}
+
+func TestLSPSemanticTokens(t *testing.T) {
+	c := newLSPClient(t)
+	uri := "file:///test.noot"
+	c.open(uri, "// hi\nfunc f(x int) int { return x + 1 }\n")
+	c.diagnostics()
+
+	result := struct {
+		Data []int `json:"data"`
+	}{}
+	if err := c.call("textDocument/semanticTokens/full", map[string]any{"textDocument": map[string]any{"uri": uri}}, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode the relative positions back into absolute ones
+	got := []string{}
+	line, char := 0, 0
+	for i := 0; i+4 < len(result.Data); i += 5 {
+		if result.Data[i] > 0 {
+			char = 0
+		}
+		line += result.Data[i]
+		char += result.Data[i+1]
+		got = append(got, fmt.Sprintf("%d:%d+%d %s", line, char, result.Data[i+2], semanticTokenTypes[result.Data[i+3]]))
+	}
+	want := []string{
+		"0:0+5 comment",
+		"1:0+4 keyword",
+		"1:5+1 function",
+		"1:7+1 parameter",
+		"1:9+3 type",
+		"1:14+3 type",
+		"1:20+6 keyword",
+		"1:27+1 parameter",
+		"1:29+1 operator",
+		"1:31+1 number",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// Columns are runes for us and UTF-16 code units for the client
+func TestLSPPositionEncoding(t *testing.T) {
+	d := newLSPDocument("file:///u.noot", "func f() { g(\"é😀\", x) }\n")
+	// x is the 20th rune but the 21st UTF-16 unit, the emoji takes two
+	pos := Position{1, 20}
+	if got := d.toLSP(pos); got != (lspPosition{0, 20}) {
+		t.Errorf("toLSP: got %+v", got)
+	}
+	if got := d.fromLSP(lspPosition{0, 20}); got != pos {
+		t.Errorf("fromLSP: got %s", got)
+	}
+}
+
+func TestLSPStructs(t *testing.T) {
+	c := newLSPClient(t)
+	uri := "file:///structs.noot"
+	c.open(uri, "type Vec2 struct {\n\tX float\n}\n\nfunc (v Vec2) Len() float {\n\treturn v.X\n}\n\nfunc main() float {\n\treturn Vec2{X: 1}.Len()\n}\n")
+	c.diagnostics()
+
+	tests := []struct {
+		line, character int
+		hover string
+		def lspRange
+	}{
+		{5, 10, "X float", lspRange{lspPosition{1, 1}, lspPosition{1, 2}}}, // v.X -> the field
+		{9, 13, "X float", lspRange{lspPosition{1, 1}, lspPosition{1, 2}}}, // X: in the literal
+		{9, 19, "func (v Vec2) Len() float", lspRange{lspPosition{4, 14}, lspPosition{4, 17}}}, // .Len() -> the method
+		{5, 8, "v Vec2", lspRange{lspPosition{4, 6}, lspPosition{4, 7}}}, // The receiver
+	}
+	for _, test := range tests {
+		hover := struct {
+			Contents struct {
+				Value string `json:"value"`
+			} `json:"contents"`
+		}{}
+		if err := c.call("textDocument/hover", at(uri, test.line, test.character), &hover); err != nil {
+			t.Fatal(err)
+		}
+		if want := "```noot\n" + test.hover + "\n```"; hover.Contents.Value != want {
+			t.Errorf("%d:%d: got hover %q, want %q", test.line, test.character, hover.Contents.Value, want)
+		}
+
+		var loc *lspLocation
+		if err := c.call("textDocument/definition", at(uri, test.line, test.character), &loc); err != nil {
+			t.Fatal(err)
+		}
+		if loc == nil || loc.Range != test.def {
+			t.Errorf("%d:%d: got definition %+v, want %+v", test.line, test.character, loc, test.def)
+		}
+	}
+
+	symbols := []lspDocumentSymbol{}
+	if err := c.call("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}}, &symbols); err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols) != 2 || symbols[0].Name != "Vec2.Len" || symbols[0].Kind != lspSymbolMethod {
+		t.Errorf("unexpected symbols %+v", symbols)
+	}
+}
@@ -0,0 +1,335 @@
+package noot
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --------------------------------------------------------------------------------
+// - Packages and Imports
+// --------------------------------------------------------------------------------
+// A package is one file. `import "util/math"` loads <root>/util/math.noot, and its exported functions are called through the name in its package clause: math.Abs(x). Functions starting with a capital letter are exported, like Go.
+// Each package is parsed and checked on its own, dependencies first, with a Checker that can see the packages it imports. Link then merges a program and everything it imports into a single file, so that the interpreter, the VM and the code generators don't need to know about packages at all.
+
+// Package is a loaded and checked file
+type Package struct {
+	path string // The import path, relative to the loader's root and without the .noot extension
+	name string
+	file *FileNode
+	checker *Checker
+	imports []*Package // In the order they are imported
+	exports map[string]*FuncNode
+}
+
+func (p *Package) Name() string {
+	return p.name
+}
+
+func (p *Package) Path() string {
+	return p.path
+}
+
+// Lookup finds an exported function
+func (p *Package) Lookup(name string) (*FuncNode, bool) {
+	f, ok := p.exports[name]
+	return f, ok
+}
+
+func isExported(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+// Loader loads a program and the packages it imports, each only once
+type Loader struct {
+	root string
+	packages map[string]*Package // By import path. Packages that failed to load are nil
+	loading []string // Import paths of the packages being loaded, innermost last, to find cycles with
+	owners map[*FuncNode]*Checker
+	sources map[string]*SourceFile // By file name, for rendering diagnostics
+	errors []Diagnostic
+}
+
+// NewLoader makes a loader that resolves import paths relative to the root directory
+func NewLoader(root string) *Loader {
+	return &Loader{
+		root: root,
+		packages: make(map[string]*Package),
+		owners: make(map[*FuncNode]*Checker),
+		sources: make(map[string]*SourceFile),
+	}
+}
+
+// LoadFile loads the program in the named file, with src as its contents, along with every package it imports. Diagnostics from all of the files are returned, each with the name of its file set. The package is nil if the file didn't parse
+func (l *Loader) LoadFile(name string, src []byte) (*Package, []Diagnostic) {
+	pkg := l.load(l.importPath(name), name, src, "main")
+	return pkg, l.errors
+}
+
+// Source gives the contents of a file that was loaded
+func (l *Loader) Source(name string) *SourceFile {
+	return l.sources[name]
+}
+
+// importPath is the path a file under the root would be imported by, so that a program importing itself is found as a cycle
+func (l *Loader) importPath(name string) string {
+	rel, err := filepath.Rel(l.root, name)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return name
+	}
+	return strings.TrimSuffix(filepath.ToSlash(rel), ".noot")
+}
+
+func (l *Loader) errorf(file string, pos Position, format string, args ...any) {
+	l.errors = append(l.errors, Diagnostic{file: file, pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+// load parses and checks one file, after loading what it imports. name is the package name to use if the file has no package clause
+func (l *Loader) load(importPath, filename string, src []byte, name string) *Package {
+	l.sources[filename] = NewSourceFile(filename, src)
+	parser := Parser{}
+	file, errs := parser.ParseFile(filename, NewLexStream(NewByteLexer(src)))
+	if len(file.nodes) > 0 {
+		if clause, ok := file.nodes[0].(*PackageNode); ok {
+			name = clause.name
+		}
+	}
+
+	pkg := &Package{path: importPath, name: name, file: file, exports: make(map[string]*FuncNode)}
+	checker := NewChecker()
+	checker.owners = l.owners
+	checker.packages = make(map[string]*Package)
+	l.loading = append(l.loading, importPath)
+	for _, node := range file.nodes {
+		if n, ok := node.(*ImportNode); ok {
+			if dep := l.importPackage(n, filename); dep != nil {
+				checker.packages[n.path] = dep
+				pkg.imports = append(pkg.imports, dep)
+			}
+		}
+	}
+	l.loading = l.loading[:len(l.loading)-1]
+
+	if len(errs) > 0 {
+		l.report(filename, errs)
+		l.packages[importPath] = nil
+		return nil
+	}
+	for _, node := range file.nodes {
+		if f, ok := node.(*FuncNode); ok {
+			l.owners[f] = checker
+		}
+	}
+	l.report(filename, checker.Check(file))
+
+	pkg.checker = checker
+	for name, f := range checker.funcs {
+		if isExported(name) {
+			pkg.exports[name] = f
+		}
+	}
+	l.packages[importPath] = pkg
+	return pkg
+}
+
+func (l *Loader) report(filename string, errs []Diagnostic) {
+	for _, d := range errs {
+		d.file = filename
+		l.errors = append(l.errors, d)
+	}
+}
+
+// importPackage loads the package an import refers to, or gives nil if it can't be
+func (l *Loader) importPackage(n *ImportNode, from string) *Package {
+	if !validImportPath(n.path) {
+		l.errorf(from, n.pathPos, "invalid import path %s", n.lit)
+		return nil
+	}
+	for i, loading := range l.loading {
+		if loading == n.path {
+			cycle := append(append([]string{}, l.loading[i:]...), n.path)
+			l.errorf(from, n.pathPos, "import cycle not allowed: %s", strings.Join(cycle, " -> "))
+			return nil
+		}
+	}
+	pkg, ok := l.packages[n.path]
+	if !ok {
+		filename := filepath.Join(l.root, filepath.FromSlash(n.path)+".noot")
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			l.errorf(from, n.pathPos, "cannot find package %s", n.lit)
+			l.errors[len(l.errors)-1].notes = []string{err.Error()}
+			l.packages[n.path] = nil
+			return nil
+		}
+		pkg = l.load(n.path, filename, src, path.Base(n.path))
+	}
+	if pkg != nil && pkg.name == "main" {
+		l.errorf(from, n.pathPos, "import %s is a program, not an importable package", n.lit)
+		return nil
+	}
+	return pkg
+}
+
+// validImportPath reports whether p is a clean relative path that stays inside the root
+func validImportPath(p string) bool {
+	return p == path.Clean(p) && !path.IsAbs(p) && p != "." && p != ".." && !strings.HasPrefix(p, "../") && !strings.Contains(p, `\`)
+}
+
+// ----
+// Linking
+// ----
+
+// Link merges a program and the packages it imports into one file, and checks it. The file remembers which file each declaration came from, so that the backends and runtime errors can report them there. The functions and types of imported packages are renamed to names that can't clash, made from their import path, and calls between packages become plain calls to the renamed functions. The trees of the packages are rewritten in place, so they can't be used after linking
+func Link(entry *Package) (*FileNode, *Checker, []Diagnostic) {
+	// Dependencies come before the packages that import them
+	order := []*Package{}
+	seen := make(map[*Package]bool)
+	var visit func(p *Package)
+	visit = func(p *Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		for _, dep := range p.imports {
+			visit(dep)
+		}
+		order = append(order, p)
+	}
+	visit(entry)
+
+	taken := make(map[string]bool)
+	for _, p := range order {
+		for _, node := range p.file.nodes {
+			switch n := node.(type) {
+			case *FuncNode:
+				taken[n.funcName] = true
+			case *TypeNode:
+				taken[n.name] = true
+			}
+		}
+	}
+
+	renames := make(map[*Package]*linkNames)
+	for _, p := range order {
+		names := &linkNames{make(map[string]string), make(map[string]string)}
+		renames[p] = names
+		if p == entry {
+			continue // The program keeps its own names, so main is still main
+		}
+		for _, node := range p.file.nodes {
+			switch n := node.(type) {
+			case *FuncNode:
+				if n.recv == nil {
+					names.funcs[n.funcName] = mangle(p.path, n.funcName, taken)
+				}
+			case *TypeNode:
+				names.types[n.name] = mangle(p.path, n.name, taken)
+			}
+		}
+	}
+
+	nodes := []Node{}
+	origins := make(map[Node]string)
+	for _, p := range order {
+		renameDecls(p, renames)
+		for _, node := range p.file.nodes {
+			switch node.(type) {
+			case *PackageNode, *ImportNode:
+			default:
+				nodes = append(nodes, node)
+				origins[node] = p.file.filename
+			}
+		}
+	}
+
+	file := &FileNode{filename: entry.file.filename, nodes: nodes, origins: origins}
+	checker := NewChecker()
+	return file, checker, checker.Check(file)
+}
+
+// linkNames are the new names of a package's functions and types
+type linkNames struct {
+	funcs map[string]string
+	types map[string]string
+}
+
+// mangle makes a name that isn't taken yet out of an import path and a name: util/math and Abs give util_math_Abs
+func mangle(importPath, name string, taken map[string]bool) string {
+	prefix := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, importPath)
+	mangled := prefix + "_" + name
+	for i := 2; taken[mangled]; i++ {
+		mangled = fmt.Sprintf("%s_%s%d", prefix, name, i)
+	}
+	taken[mangled] = true
+	return mangled
+}
+
+// renameDecls renames a package's declarations and every reference to them, and turns its calls into other packages into calls to their renamed functions
+func renameDecls(p *Package, renames map[*Package]*linkNames) {
+	names := renames[p]
+	typeName := func(name string) string {
+		if renamed, ok := names.types[name]; ok {
+			return renamed
+		}
+		return name
+	}
+	args := func(args []Arg) {
+		for i := range args {
+			args[i].kind = typeName(args[i].kind)
+		}
+	}
+
+	for _, node := range p.file.nodes {
+		switch n := node.(type) {
+		case *TypeNode:
+			n.name = typeName(n.name)
+			if !n.isStruct() {
+				n.kind = typeName(n.kind)
+			}
+			args(n.fields)
+		case *FuncNode:
+			if n.recv == nil {
+				if renamed, ok := names.funcs[n.funcName]; ok {
+					n.funcName = renamed
+				}
+			} else {
+				args(n.recv.args)
+			}
+			args(n.arguments.(*ArgNode).args)
+			n.returnType = typeName(n.returnType)
+
+			n.body = Rewrite(n.body, func(node Node) Node {
+				switch n := node.(type) {
+				case *VarNode:
+					n.kind = typeName(n.kind)
+				case *CompositeLitNode:
+					n.kind = typeName(n.kind)
+				case *CallExprNode:
+					switch fn := n.fn.(type) {
+					case *UnaryNode:
+						// Calling a variable is an error, so a name being called is always a function
+						if renamed, ok := names.funcs[fn.token.str]; ok {
+							fn.token.str = renamed
+						}
+					case *SelectorNode:
+						if target, ok := p.checker.pkgRefs[fn]; ok {
+							n.fn = &UnaryNode{PackedToken{fn.Pos(), IDENT, renames[target].funcs[fn.name]}}
+						}
+					}
+				}
+				return node
+			})
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package noot
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes a tree of source files into a new directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// loadProgram loads main.noot from dir
+func loadProgram(t *testing.T, dir string) (*Package, []Diagnostic) {
+	name := filepath.Join(dir, "main.noot")
+	src, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLoader(dir).LoadFile(name, src)
+}
+
+var modulePrograms = map[string]string{
+	"main.noot": `package main
+
+import "util/math"
+import "shapes"
+
+func main(x int) int {
+	p := shapes.Origin()
+	return math.Abs(x) + math.Square(3) + shapes.Area(p)
+}
+`,
+	"util/math.noot": `package math
+
+func Abs(x int) int {
+	if x < 0 {
+		return neg(x)
+	}
+	return x
+}
+
+func neg(x int) int {
+	return -x
+}
+
+func Square(x int) int {
+	return x * x
+}
+`,
+	// Both packages have a function called neg, which linking has to keep apart
+	"shapes.noot": `package shapes
+
+import "util/math"
+
+type Point struct {
+	x int
+	y int
+}
+
+func (p Point) area() int {
+	return math.Square(p.x) + neg(p.y)
+}
+
+func neg(x int) int {
+	return 0 - x
+}
+
+func Origin() Point {
+	return Point{2, -1}
+}
+
+func Area(p Point) int {
+	return p.area()
+}
+`,
+}
+
+func TestLoadAndLink(t *testing.T) {
+	pkg, errs := loadProgram(t, writeFiles(t, modulePrograms))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if pkg.Name() != "main" || pkg.Path() != "main" || len(pkg.imports) != 2 {
+		t.Fatalf("got package %s at %s with %d imports", pkg.Name(), pkg.Path(), len(pkg.imports))
+	}
+	math := pkg.imports[0]
+	exports := []string{}
+	for name := range math.exports {
+		exports = append(exports, name)
+	}
+	sort.Strings(exports)
+	if strings.Join(exports, " ") != "Abs Square" {
+		t.Errorf("got exports %v", exports)
+	}
+	if _, ok := math.Lookup("neg"); ok {
+		t.Error("neg shouldn't be exported")
+	}
+	if pkg.imports[1].imports[0] != math {
+		t.Error("util/math should only be loaded once")
+	}
+
+	file, checker, errs := Link(pkg)
+	if len(errs) > 0 {
+		t.Fatalf("%v\n%s", errs, formatFile(file))
+	}
+	for _, node := range file.nodes {
+		switch node.(type) {
+		case *PackageNode, *ImportNode:
+			t.Errorf("linked file still has %s", sexpr(node))
+		}
+	}
+
+	// -4 gives 4 + 9 + 5
+	got, err := NewInterpreter(file).Call("main", -4)
+	if err != nil || got != 18 {
+		t.Errorf("interpreter: got %d %v, want 18\n%s", got, err, formatFile(file))
+	}
+	if _, err := GenerateGo(file, "main"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := checker.funcs["util_math_neg"]; !ok {
+		t.Errorf("expected the functions of util/math to be renamed\n%s", formatFile(file))
+	}
+}
+
+func TestLinkRunsOnVM(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.noot": "import \"num\"\n\nfunc main(x int) int {\n\treturn num.Fib(x) + fib(x)\n}\n\nfunc fib(n int) int {\n\treturn n\n}\n",
+		"num.noot": "package num\n\nfunc Fib(n int) int {\n\tif n < 2 {\n\t\treturn n\n\t}\n\treturn Fib(n - 1) + Fib(n - 2)\n}\n",
+	})
+	pkg, errs := loadProgram(t, dir)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	file, checker, errs := Link(pkg)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	program, errs := compileChecked(file, checker, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got, err := NewVM(program).Call("main", 10)
+	if err != nil || got != 65 {
+		t.Errorf("got %d %v, want 65", got, err)
+	}
+}
+
+// Linking merges the files into one, but errors still have to point into the file they happened in
+func TestLinkedErrorsKeepTheirFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.noot": "import \"geo\"\n\nfunc main(x int) int {\n\treturn geo.Div(x) + 1\n}\n",
+		"geo.noot": "package geo\n\ntype P struct {\n\tX int\n}\n\nfunc Div(x int) int {\n\treturn 10 / x\n}\n",
+	})
+	pkg, errs := loadProgram(t, dir)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	file, checker, errs := Link(pkg)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	geo := filepath.Join(dir, "geo.noot")
+	want := geo + ":8:12: division by zero"
+
+	program, errs := compileChecked(file, checker, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, err := NewVM(program).Call("main", 0); err == nil || err.Error() != want {
+		t.Errorf("vm: got %v, want %s", err, want)
+	}
+	if _, err := NewInterpreter(file).Call("main", 0); err == nil || err.Error() != want {
+		t.Errorf("interpreter: got %v, want %s", err, want)
+	}
+	if _, errs := GenerateWasm(file, checker); len(errs) != 1 || errs[0].Error() != geo+":3:1: struct types are not supported by the wasm backend" {
+		t.Errorf("wasm: got %v", errs)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	lib := "package lib\n\nfunc Exported() int {\n\treturn hidden()\n}\n\nfunc hidden() int {\n\treturn 1\n}\n"
+	tests := []struct {
+		name string
+		files map[string]string
+		want []string
+	}{
+		{"unexported", map[string]string{
+			"main.noot": "import \"lib\"\n\nfunc main() int {\n\treturn lib.hidden()\n}\n",
+			"lib.noot": lib,
+		}, []string{"main.noot:4:13: hidden not exported by package lib"}},
+		{"undefined", map[string]string{
+			"main.noot": "import \"lib\"\n\nfunc main() int {\n\treturn lib.Exportd()\n}\n",
+			"lib.noot": lib,
+		}, []string{"main.noot:4:13: undefined: lib.Exportd (did you mean Exported?)"}},
+		{"not called", map[string]string{
+			"main.noot": "import \"lib\"\n\nfunc main() int {\n\tx := lib\n\ty := lib.Exported\n\treturn 1\n}\n",
+			"lib.noot": lib,
+		}, []string{
+			"main.noot:4:7: use of package lib without selector",
+			"main.noot:5:11: function lib.Exported must be called",
+		}},
+		{"unused", map[string]string{
+			"main.noot": "import \"lib\"\n\nfunc main() int {\n\treturn 1\n}\n",
+			"lib.noot": lib,
+		}, []string{`main.noot:1:8: "lib" imported and not used`}},
+		{"shadowed", map[string]string{
+			"main.noot": "import \"lib\"\n\nfunc main(lib int) int {\n\treturn lib.Exported()\n}\n",
+			"lib.noot": lib,
+		}, []string{
+			"main.noot:4:13: lib.Exported undefined (type int has no method Exported)",
+			`main.noot:1:8: "lib" imported and not used`,
+		}},
+		{"missing", map[string]string{
+			"main.noot": "import \"nope\"\n\nfunc main() int {\n\treturn nope.F()\n}\n",
+		}, []string{`main.noot:1:8: cannot find package "nope"`}},
+		{"cycle", map[string]string{
+			"main.noot": "import \"a\"\n\nfunc main() int {\n\treturn a.A()\n}\n",
+			"a.noot": "package a\n\nimport \"b\"\n\nfunc A() int {\n\treturn b.B()\n}\n",
+			"b.noot": "package b\n\nimport \"a\"\n\nfunc B() int {\n\treturn a.A()\n}\n",
+		}, []string{`b.noot:3:8: import cycle not allowed: a -> b -> a`}},
+		{"self", map[string]string{
+			"main.noot": "import \"main\"\n\nfunc main() {\n}\n",
+		}, []string{`main.noot:1:8: import cycle not allowed: main -> main`}},
+		{"program", map[string]string{
+			"main.noot": "import \"other\"\n\nfunc main() {\n}\n",
+			"other.noot": "package main\n\nfunc main() {\n}\n",
+		}, []string{`main.noot:1:8: import "other" is a program, not an importable package`}},
+		{"invalid path", map[string]string{
+			"main.noot": "import \"../lib\"\n\nfunc main() {\n}\n",
+		}, []string{`main.noot:1:8: invalid import path "../lib"`}},
+		{"placement", map[string]string{
+			"main.noot": "func main() {\n\timport \"lib\"\n}\n\npackage main\nimport \"lib\"\n",
+			"lib.noot": lib,
+		}, []string{
+			"main.noot:5:1: package clause must come first",
+			"main.noot:6:1: imports must come before other declarations",
+			"main.noot:2:2: imports are only allowed at the top level",
+			`main.noot:6:8: "lib" imported and not used`,
+		}},
+		{"errors in the import", map[string]string{
+			"main.noot": "import \"lib\"\n\nfunc main() int {\n\treturn lib.F()\n}\n",
+			"lib.noot": "package lib\n\nfunc F() int {\n\treturn true\n}\n",
+		}, []string{"lib.noot:4:2: cannot return bool as int"}},
+	}
+	for _, test := range tests {
+		dir := writeFiles(t, test.files)
+		_, errs := loadProgram(t, dir)
+		got := []string{}
+		for _, e := range errs {
+			msg := strings.TrimPrefix(filepath.ToSlash(e.Error()), filepath.ToSlash(dir)+"/")
+			for _, note := range e.notes {
+				if !strings.Contains(note, "no such file") {
+					msg += " (" + note + ")"
+				}
+			}
+			got = append(got, msg)
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestPackageSyntax(t *testing.T) {
+	src := "package shapes\n\nimport \"util/math\"\nimport \"lib\"\n\nfunc F() {}\n"
+	file, errs := parseString(t, src)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := sexpr(file); got != "(package shapes)\n(import \"util/math\")\n(import \"lib\")\n(func F (args) (block))" {
+		t.Errorf("got %s", got)
+	}
+	if path := file.nodes[1].(*ImportNode).path; path != "util/math" {
+		t.Errorf("got path %q, want it unquoted", path)
+	}
+
+	out, errs := Format([]byte(src))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if string(out) != src {
+		t.Errorf("got\n%s\nwant\n%s", out, src)
+	}
+
+	data, err := MarshalAST(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnmarshalAST(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(file, decoded) {
+		t.Errorf("round trip changed the tree\nbefore: %s\nafter:  %s", sexpr(file), sexpr(decoded))
+	}
+
+	for _, bad := range []string{"package\n", "package 1\n", "import lib\n", "import \"\"\n", "import \"a\" \"b\"\n"} {
+		if _, errs := parseString(t, bad); len(errs) == 0 {
+			t.Errorf("%q: expected a parse error", bad)
+		}
+	}
+}
+
+// The commands load imports from the directory the file is in. Tools that only see one file, like the language server, leave calls into other packages unchecked rather than reporting them
+func TestCheckSourceWithImports(t *testing.T) {
+	dir := writeFiles(t, modulePrograms)
+	name := filepath.Join(dir, "main.noot")
+	s := &source{name: name, data: []byte(modulePrograms["main.noot"])}
+	file, checker, errs := s.check()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, ok := checker.funcs["shapes_Area"]; !ok {
+		t.Errorf("expected a linked file\n%s", formatFile(file))
+	}
+	if s.files[filepath.Join(dir, "shapes.noot")] == nil {
+		t.Error("expected the imported files to be kept for reporting")
+	}
+
+	file, errs = parseString(t, modulePrograms["main.noot"])
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if errs := NewChecker().Check(file); len(errs) > 0 {
+		t.Errorf("unloaded imports: %v", errs)
+	}
+	d := newLSPDocument("file:///main.noot", modulePrograms["main.noot"])
+	if len(d.errs) > 0 || len(d.symbols()) != 1 {
+		t.Errorf("lsp: got %v with symbols %v", d.errs, d.symbols())
+	}
+}
@@ -0,0 +1,383 @@
+package noot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// --------------------------------------------------------------------------------
+// - Optimiser
+// --------------------------------------------------------------------------------
+// Passes that rewrite a checked FileNode into a smaller one that computes the same results. Each pass is a tree to tree rewrite, so the output can still be printed, interpreted, compiled or translated like anything the parser makes.
+//   fold      evaluates operators on constants: 1 + 2 * 3 becomes 7
+//   simplify  removes operations that do nothing: x * 1, x / 1, x - 0 and, for non floats, x + 0
+//   dce       drops statements that can never run: anything after a return, break or continue, and the dead branch of `if true`/`if false`
+// Constants are evaluated with the interpreter's own arithmetic, so folding can't change what a program computes. Anything that could fail at runtime, like a division by zero, and integer results that would wrap around are left for runtime.
+// The passes keep the checker's types up to date for the nodes they make, so the bytecode compiler still picks the right instructions.
+
+// Pass is one rewrite of the tree
+type Pass struct {
+	name string
+	run func(o *Optimizer, file *FileNode)
+}
+
+var passes = []Pass{
+	{"fold", (*Optimizer).fold},
+	{"simplify", (*Optimizer).simplify},
+	{"dce", (*Optimizer).deadCode},
+}
+
+// PassNames lists the passes in the order they run by default
+func PassNames() []string {
+	names := make([]string, len(passes))
+	for i := range passes {
+		names[i] = passes[i].name
+	}
+	return names
+}
+
+type Optimizer struct {
+	types map[Node]*Type
+	passes []Pass
+	trace func(pass string, after bool, file *FileNode)
+	shadowed bool // The function being optimised declares a variable called true or false, so they can't be folded
+}
+
+// NewOptimizer makes an optimiser for a file that checker has checked, running every pass
+func NewOptimizer(checker *Checker) *Optimizer {
+	return &Optimizer{
+		types: checker.exprTypes,
+		passes: passes,
+	}
+}
+
+// SetPasses picks the passes to run, in order, by name
+func (o *Optimizer) SetPasses(names []string) error {
+	o.passes = nil
+	for _, name := range names {
+		found := false
+		for _, p := range passes {
+			if p.name == name {
+				o.passes = append(o.passes, p)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown pass %q, expected one of %s", name, strings.Join(PassNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// SetTrace makes Optimize call fn with the tree before and after every pass, for debugging
+func (o *Optimizer) SetTrace(fn func(pass string, after bool, file *FileNode)) {
+	o.trace = fn
+}
+
+// Optimize runs the passes over the file. The tree is changed in place
+func (o *Optimizer) Optimize(file *FileNode) {
+	for _, p := range o.passes {
+		if o.trace != nil {
+			o.trace(p.name, false, file)
+		}
+		p.run(o, file)
+		if o.trace != nil {
+			o.trace(p.name, true, file)
+		}
+	}
+}
+
+// eachFunc runs fn over the body of every function. true and false can be shadowed, so the optimiser first notes whether the function does that
+func (o *Optimizer) eachFunc(file *FileNode, fn func(body *CurlyScope)) {
+	for _, node := range file.nodes {
+		f, ok := node.(*FuncNode)
+		if !ok {
+			continue
+		}
+		body, ok := f.body.(*CurlyScope)
+		if !ok {
+			continue
+		}
+
+		o.shadowed = false
+		args := f.arguments.(*ArgNode).args
+		if f.recv != nil {
+			args = append(append([]Arg{}, f.recv.args...), args...)
+		}
+		for _, arg := range args {
+			o.shadowed = o.shadowed || arg.name == "true" || arg.name == "false"
+		}
+		Inspect(body, func(n Node) bool {
+			if v, ok := n.(*VarNode); ok {
+				o.shadowed = o.shadowed || v.name == "true" || v.name == "false"
+			}
+			return true
+		})
+		fn(body)
+	}
+}
+
+// constant gives the value of a literal, or of true or false if they haven't been shadowed
+func (o *Optimizer) constant(node Node) (value, bool) {
+	switch n := node.(type) {
+	case *ExprNode:
+		return o.constant(n.expr)
+	case *UnaryNode:
+		switch n.token.token {
+		case INT:
+			v, err := strconv.Atoi(n.token.str)
+			return value{kind: KindInt, untyped: true, i: v}, err == nil
+		case FLOAT:
+			f, err := strconv.ParseFloat(n.token.str, 64)
+			return value{kind: KindFloat, untyped: true, f: f}, err == nil
+		case IDENT:
+			if !o.shadowed && (n.token.str == "true" || n.token.str == "false") {
+				return boolValue(n.token.str == "true"), true
+			}
+		}
+	}
+	return value{}, false
+}
+
+// literal makes the node for a constant that replaces old
+func (o *Optimizer) literal(old Node, v value) Node {
+	tok := PackedToken{pos: old.Pos()}
+	switch v.kind {
+	case KindFloat:
+		tok.token = FLOAT
+		tok.str = strconv.FormatFloat(v.f, 'g', -1, 64)
+		if !strings.ContainsAny(tok.str, ".e") {
+			tok.str += ".0" // Still a float when it is read back
+		}
+	case KindBool:
+		tok.token = IDENT
+		tok.str = strconv.FormatBool(v.i != 0)
+	default:
+		tok.token = INT
+		tok.str = strconv.Itoa(v.i)
+	}
+
+	lit := &UnaryNode{tok}
+	if t, ok := o.types[old]; ok {
+		o.types[lit] = t
+	}
+	return lit
+}
+
+// ----
+// Constant folding
+// ----
+
+func (o *Optimizer) fold(file *FileNode) {
+	o.eachFunc(file, func(body *CurlyScope) {
+		Rewrite(body, o.foldNode)
+	})
+}
+
+// foldNode replaces an operator whose operands are constants with its result. Rewrite works bottom up, so the operands have already been folded
+func (o *Optimizer) foldNode(node Node) Node {
+	switch n := node.(type) {
+	case *ExprNode:
+		if _, ok := o.constant(n.expr); ok {
+			return n.expr // Constants don't need their parentheses
+		}
+	case *PrefixExprNode:
+		v, ok := o.constant(n.expr)
+		if !ok {
+			return n
+		}
+		switch {
+		case n.op == OpNot && v.kind == KindBool:
+			return o.literal(n, boolValue(v.i == 0))
+		case n.op == OpSub && v.kind == KindFloat:
+			v.f = -v.f
+			return o.literal(n, v)
+		case n.op == OpSub && v.kind == KindInt && v.i != math.MinInt:
+			v.i = -v.i
+			return o.literal(n, v)
+		}
+	case *BinaryExprNode:
+		a, ok := o.constant(n.left)
+		if !ok {
+			return n
+		}
+		b, ok := o.constant(n.right)
+		if !ok {
+			return n
+		}
+		if n.op == OpAnd || n.op == OpOr {
+			if a.kind != KindBool || b.kind != KindBool || o.shadowed {
+				return n
+			}
+			if n.op == OpAnd {
+				return o.literal(n, boolValue(a.i != 0 && b.i != 0))
+			}
+			return o.literal(n, boolValue(a.i != 0 || b.i != 0))
+		}
+		v, err := binary(n.pos, n.op, a, b)
+		if err != nil || v.kind == KindFloat && (math.IsInf(v.f, 0) || math.IsNaN(v.f)) {
+			return n // There's no literal for these, and errors have to happen at runtime
+		}
+		if v.kind == KindInt && overflows(n.op, a.i, b.i, v.i) {
+			return n // Wrapping around would give a different number than the one written down
+		}
+		if v.kind == KindBool && o.shadowed {
+			return n // true would mean the variable
+		}
+		return o.literal(n, v)
+	}
+	return node
+}
+
+// ----
+// Algebraic simplification
+// ----
+
+func (o *Optimizer) simplify(file *FileNode) {
+	o.eachFunc(file, func(body *CurlyScope) {
+		Rewrite(body, o.simplifyNode)
+	})
+}
+
+// simplifyNode replaces an operation that gives back its other operand unchanged with that operand. x + 0 is kept for floats, because -0 + 0 is 0
+func (o *Optimizer) simplifyNode(node Node) Node {
+	n, ok := node.(*BinaryExprNode)
+	if !ok {
+		return node
+	}
+	is := func(side Node, want float64) bool {
+		v, ok := o.constant(side)
+		switch {
+		case !ok:
+			return false
+		case v.kind == KindInt:
+			return float64(v.i) == want
+		case v.kind == KindFloat:
+			return v.f == want
+		}
+		return false
+	}
+	isFloat := false
+	if t, ok := o.types[n]; ok {
+		isFloat = t.kind == KindFloat || t.kind == KindUntypedFloat
+	}
+
+	switch n.op {
+	case OpMul:
+		if is(n.right, 1) {
+			return n.left
+		}
+		if is(n.left, 1) {
+			return n.right
+		}
+	case OpDiv:
+		if is(n.right, 1) {
+			return n.left
+		}
+	case OpAdd:
+		if isFloat {
+			break
+		}
+		if is(n.right, 0) {
+			return n.left
+		}
+		if is(n.left, 0) {
+			return n.right
+		}
+	case OpSub:
+		if is(n.right, 0) {
+			return n.left
+		}
+	}
+	return n
+}
+
+// ----
+// Dead code elimination
+// ----
+
+func (o *Optimizer) deadCode(file *FileNode) {
+	o.eachFunc(file, func(body *CurlyScope) {
+		body.nodes = o.liveStmts(body.nodes)
+	})
+}
+
+// liveStmts drops the statements that can't be reached
+func (o *Optimizer) liveStmts(nodes []Node) []Node {
+	live := []Node{}
+	for _, node := range nodes {
+		stmts := o.liveStmt(node)
+		live = append(live, stmts...)
+		if len(stmts) == 0 {
+			continue
+		}
+		last := stmts[len(stmts)-1]
+		if _, isBranch := last.(*BranchNode); isBranch || terminates(last) {
+			break
+		}
+	}
+	return live
+}
+
+// liveStmt removes the dead code inside a statement, giving the statements to put in its place
+func (o *Optimizer) liveStmt(node Node) []Node {
+	switch n := node.(type) {
+	case *CurlyScope:
+		n.nodes = o.liveStmts(n.nodes)
+	case *IfNode:
+		taken, ok := o.liveIf(n)
+		if !ok {
+			break
+		}
+		switch t := taken.(type) {
+		case nil:
+			return nil
+		case *CurlyScope:
+			if declares(t) {
+				return []Node{node} // There are no bare blocks, so the if has to stay to keep the variables in their scope
+			}
+			return t.nodes
+		}
+		return []Node{taken}
+	case *ForNode:
+		if cond, ok := o.constant(n.cond); ok && cond.i == 0 {
+			return nil
+		}
+		o.liveStmt(n.body)
+	}
+	return []Node{node}
+}
+
+// liveIf removes the dead code inside the branches of an if. If the condition is constant it also gives the branch that is always taken, which is nil for a false if without an else
+func (o *Optimizer) liveIf(n *IfNode) (Node, bool) {
+	o.liveStmt(n.then)
+	switch els := n.els.(type) {
+	case *CurlyScope:
+		o.liveStmt(els)
+	case *IfNode:
+		if taken, ok := o.liveIf(els); ok {
+			n.els = taken
+		}
+	}
+
+	cond, ok := o.constant(n.cond)
+	if !ok {
+		return nil, false
+	}
+	if cond.i != 0 {
+		return n.then, true
+	}
+	return n.els, true
+}
+
+// declares reports whether a block declares variables of its own
+func declares(block *CurlyScope) bool {
+	for _, node := range block.nodes {
+		if _, ok := node.(*VarNode); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package noot
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+const optimizeProgram = `func consts(x int) int {
+	a := x * 1 + 0 - 0
+	a = a + (2 + 3) * (4 - 6) / 3
+	if 1 < 2 && !false {
+		a = a + 7 / 2
+	} else {
+		return 0
+	}
+	if false {
+		a = 0
+	} else if 2 >= 2 {
+		a = a * 1
+	}
+	for 2 > 3 {
+		a = 1
+	}
+	if true {
+		b := 4
+		a = a + b
+	}
+	return a - -(1 + 1)
+}
+
+func floats(x int) float {
+	var m float = 7 / 2 + 0.5 * -2
+	var f float = 1
+	if x < 0 {
+		f = -0.0 * 1
+	}
+	f = f + 0
+	return m * (1 + 1) / 1 + f * (1.5 + 0.5)
+}
+
+func dead(x int) int {
+	for {
+		if x > 10 {
+			break
+			x = 0
+		}
+		x = x + 3
+		continue
+		x = 100
+	}
+	return x
+	x = 2
+	return 1
+}
+
+func divide(x int) int {
+	return x * (1 / 1) / (x - x)
+}
+
+func shadow(true bool, x int) bool {
+	false := 1 < 2
+	return !true && 1 < 2 || false == (x > 0)
+}
+
+func logic(x int) bool {
+	if true || false {
+		x = x + 1
+	}
+	if false && true {
+		x = 0
+	}
+	return (1 < 2 && !(2.5 > 3) || false) == (x > 0)
+}
+
+func main(x int) bool {
+	return consts(x) == 3 || shadow(x > 0, x) && !(1 == 1.0)
+}
+`
+
+// Each pass has to give the same results as the program it started from. Passes change the tree in place, so every run gets a fresh parse
+func TestOptimizePreservesResults(t *testing.T) {
+	data, err := os.ReadFile("input.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		src string
+		funcs []string
+	}{
+		{"input.test", string(data), []string{"FunctionA", "FunctionB", "FunctionC"}},
+		{"program.noot", gogenProgram, []string{"fib", "collatz", "isPrime", "shadow"}},
+		{"optimize.noot", optimizeProgram, []string{"consts", "floats", "dead", "divide", "shadow", "logic", "main"}},
+	}
+	args := []int{-7, -1, 1, 2, 5, 12} // Not 0, collatz never finishes on it
+	pipelines := [][]string{{"fold"}, {"simplify"}, {"dce"}, {"fold", "simplify", "dce"}, {"dce", "simplify", "fold", "fold"}}
+
+	load := func(name, src string) (*FileNode, *Checker) {
+		file, checker, errs := (&source{name: name, data: []byte(src)}).check()
+		if len(errs) > 0 {
+			t.Fatalf("%s: %v", name, errs)
+		}
+		return file, checker
+	}
+	// results runs every function over every argument, with the second argument of two argument functions fixed
+	results := func(file *FileNode, funcs []string) []string {
+		in := NewInterpreter(file)
+		out := []string{}
+		for _, name := range funcs {
+			for _, x := range args {
+				values := []value{{kind: KindInt, i: x}}
+				if len(in.funcs[name].arguments.(*ArgNode).args) == 2 {
+					values = append([]value{boolValue(x > 0)}, values...)
+					if name != "shadow" {
+						values = []value{{kind: KindInt, i: x}, {kind: KindInt, i: 3}}
+					}
+				}
+				v, err := in.call(in.funcs[name], nil, values)
+				out = append(out, fmt.Sprintf("%s(%d) = %v %v", name, x, v, err))
+			}
+		}
+		return out
+	}
+
+	for _, test := range tests {
+		file, _ := load(test.name, test.src)
+		want := results(file, test.funcs)
+
+		for _, pipeline := range pipelines {
+			file, checker := load(test.name, test.src)
+			o := NewOptimizer(checker)
+			if err := o.SetPasses(pipeline); err != nil {
+				t.Fatal(err)
+			}
+			o.Optimize(file)
+
+			got := results(file, test.funcs)
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("%s %v: got %s, want %s\n%s", test.name, pipeline, got[i], want[i], formatFile(file))
+				}
+			}
+
+			// The optimised tree is still a valid program
+			if _, errs := Format(formatFile(file)); len(errs) > 0 {
+				t.Errorf("%s %v: %v\n%s", test.name, pipeline, errs, formatFile(file))
+			}
+		}
+	}
+}
+
+func TestOptimizeOutput(t *testing.T) {
+	file, checker, errs := (&source{name: "optimize.noot", data: []byte(optimizeProgram)}).check()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	NewOptimizer(checker).Optimize(file)
+
+	want := map[string]string{
+		"consts": "(func consts (args (x int)) int (block (:= a x) (= a (+ a -3)) (= a (+ a 3)) (= a a) (if true (block (:= b 4) (= a (+ a b)))) (return (- a -2))))",
+		"floats": "(func floats (args (x int)) float (block (var m float 2.0) (var f float 1) (if (< x 0) (block (= f -0.0))) (= f (+ f 0)) (return (+ (* m 2) (* f 2.0)))))",
+		"dead": "(func dead (args (x int)) int (block (for (block (if (> x 10) (block (break))) (= x (+ x 3)) (continue))) (return x)))",
+		"divide": "(func divide (args (x int)) int (block (return (/ x (- x x)))))",
+		"logic": "(func logic (args (x int)) bool (block (= x (+ x 1)) (return (== true (> x 0)))))",
+		"shadow": "(func shadow (args (true bool) (x int)) bool (block (:= false (< 1 2)) (return (|| (&& (! true) (< 1 2)) (== false (> x 0))))))",
+	}
+	for _, node := range file.nodes {
+		f, ok := node.(*FuncNode)
+		if !ok || want[f.funcName] == "" {
+			continue
+		}
+		if got := sexpr(f); got != want[f.funcName] {
+			t.Errorf("%s:\ngot  %s\nwant %s", f.funcName, got, want[f.funcName])
+		}
+	}
+}
+
+// Folding can't wrap around, so integer results that don't fit are left as they are
+func TestFoldOverflow(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"9223372036854775807 + 1", "(+ 9223372036854775807 1)"},
+		{"-9223372036854775807 - 2", "(- -9223372036854775807 2)"},
+		{"3037000500 * 3037000500", "(* 3037000500 3037000500)"},
+		{"-(-9223372036854775807 - 1)", "(- -9223372036854775808)"},
+		{"9223372036854775806 + 1", "9223372036854775807"},
+		{"-3037000499 * 3037000499", "-9223372030926249001"},
+	}
+	for _, test := range tests {
+		file, errs := parseString(t, "func F() int {\n\treturn "+test.expr+"\n}\n")
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		o := NewOptimizer(NewChecker())
+		if err := o.SetPasses([]string{"fold"}); err != nil {
+			t.Fatal(err)
+		}
+		o.Optimize(file)
+		want := "(func F (args) int (block (return " + test.want + ")))"
+		if got := sexpr(file); got != want {
+			t.Errorf("%s: got %s, want %s", test.expr, got, want)
+		}
+	}
+}
+
+// The compiler relies on the checker's types, so the nodes the optimiser makes need them too
+func TestOptimizeKeepsTypesForVM(t *testing.T) {
+	src := "func F(x int) float {\n\tvar f float = 2\n\tvar g float = 5\n\treturn f * (1 + 1) + g * (3 - 1)\n}\n"
+	file, checker, errs := (&source{name: "f.noot", data: []byte(src)}).check()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	NewOptimizer(checker).Optimize(file)
+
+	program, errs := compileChecked(file, checker, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got, err := NewVM(program).Call("F", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valueToFloat(got) != 14 {
+		t.Errorf("got %v, want 14\n%s", valueToFloat(got), program.Disassemble())
+	}
+}
+
+func TestSetPasses(t *testing.T) {
+	o := NewOptimizer(NewChecker())
+	if err := o.SetPasses([]string{"fold", "nope"}); err == nil {
+		t.Error("expected an error for an unknown pass")
+	}
+}
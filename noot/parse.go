@@ -0,0 +1,795 @@
+package noot
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// lexAll runs the lexer over the whole reader. The last token is always EOF
+func lexAll(reader io.Reader) []PackedToken {
+	tokens := make([]PackedToken, 0)
+	lexer := NewLexer(reader)
+	for {
+		pos, tok, lit := lexer.Lex()
+		tokens = append(tokens, PackedToken{pos, tok, lit})
+		if tok == EOF {
+			return tokens
+		}
+	}
+}
+
+type PackedToken struct {
+	pos Position
+	token Token
+	str string
+}
+
+func (t PackedToken) String() string {
+	switch t.token {
+	case IDENT, INT, FLOAT, STRING, ILLEGAL:
+		return fmt.Sprintf("%s %q", t.token, t.str)
+	}
+	return t.token.String()
+}
+
+// TokenStream is where the parser pulls its tokens from. Once the input runs out every call returns an EOF token, so the parser can never read past the end
+type TokenStream interface {
+	Peek() PackedToken
+	PeekN(n int) PackedToken // Looks n tokens past the next one, PeekN(0) is the same as Peek()
+	Next() PackedToken
+}
+
+// Tokens is a TokenStream over a token slice that has already been lexed
+type Tokens struct {
+	list []PackedToken
+}
+
+func (t *Tokens) Len() int {
+	return len(t.list)
+}
+func (t *Tokens) Peek() PackedToken {
+	return t.PeekN(0)
+}
+func (t *Tokens) PeekN(n int) PackedToken {
+	if n >= len(t.list) {
+		return t.eof()
+	}
+	return t.list[n]
+}
+func (t *Tokens) Next() PackedToken {
+	token := t.Peek()
+	if token.token == EOF {
+		return token // Keep returning EOF so that error recovery can't run off the end
+	}
+	t.list = t.list[1:]
+	return token
+}
+
+// eof makes an EOF token for when the list runs out without one, at the position of the last token we had
+func (t *Tokens) eof() PackedToken {
+	if len(t.list) > 0 {
+		return PackedToken{t.list[len(t.list)-1].pos, EOF, "EOF"}
+	}
+	return PackedToken{Position{line: 1}, EOF, "EOF"}
+}
+
+// LexStream is a TokenStream that runs the lexer lazily. Only the tokens that have been peeked at but not consumed yet are kept around, so the memory used doesn't grow with the size of the input
+type LexStream struct {
+	lexer *Lexer
+	buf []PackedToken // Lookahead, next token first
+}
+
+func NewLexStream(lexer *Lexer) *LexStream {
+	return &LexStream{
+		lexer: lexer,
+		buf: make([]PackedToken, 0, 4),
+	}
+}
+
+func (s *LexStream) Peek() PackedToken {
+	return s.PeekN(0)
+}
+
+func (s *LexStream) PeekN(n int) PackedToken {
+	for len(s.buf) <= n {
+		if len(s.buf) > 0 && s.buf[len(s.buf)-1].token == EOF {
+			return s.buf[len(s.buf)-1] // Nothing past the end
+		}
+		pos, tok, lit := s.lexer.Lex()
+		s.buf = append(s.buf, PackedToken{pos, tok, lit})
+	}
+	return s.buf[n]
+}
+
+func (s *LexStream) Next() PackedToken {
+	token := s.Peek()
+	if token.token == EOF {
+		return token
+	}
+	// Shift down rather than reslicing so that the buffer's backing array gets reused
+	copy(s.buf, s.buf[1:])
+	s.buf = s.buf[:len(s.buf)-1]
+	return token
+}
+
+// commentCheck reports a block comment that is never closed, which the lexer gives as an ILLEGAL token just before EOF. The parser sees the end of the input there instead, so the comment is reported once rather than as whatever token the parser wanted next
+type commentCheck struct {
+	TokenStream
+	p *Parser
+	reported bool
+}
+
+func (s *commentCheck) check(t PackedToken) PackedToken {
+	if t.token != ILLEGAL || t.str != errCommentNotTerminated {
+		return t
+	}
+	if !s.reported {
+		s.p.errorf(t.pos, "%s", errCommentNotTerminated)
+		s.reported = true
+	}
+	return PackedToken{t.pos, EOF, "EOF"}
+}
+
+func (s *commentCheck) Peek() PackedToken {
+	return s.check(s.TokenStream.Peek())
+}
+func (s *commentCheck) PeekN(n int) PackedToken {
+	return s.check(s.TokenStream.PeekN(n))
+}
+func (s *commentCheck) Next() PackedToken {
+	return s.check(s.TokenStream.Next())
+}
+
+// --------------------------------------------------------------------------------
+// - Parser
+// --------------------------------------------------------------------------------
+
+// Diagnostic is a single error, positioned at the token that caused it. Notes are extra lines of explanation or hints on how to fix it, which Renderer prints under the error. file is only set for programs spread over several files, where it names the one the error is in
+type Diagnostic struct {
+	file string
+	pos Position
+	msg string
+	notes []string
+}
+
+func (d Diagnostic) Error() string {
+	if d.file != "" {
+		return fmt.Sprintf("%s:%s: %s", d.file, d.pos, d.msg)
+	}
+	return fmt.Sprintf("%s: %s", d.pos, d.msg)
+}
+
+// ParseFile parses every declaration in the token list. Errors don't stop the parse, so the returned FileNode may be partial and contain BadNodes in the places that failed to parse.
+func (p *Parser) ParseFile(name string, tokens TokenStream) (*FileNode, []Diagnostic) {
+	tokens = &commentCheck{TokenStream: tokens, p: p}
+	nodes, _ := p.ParseTil(tokens, EOF)
+	file := &FileNode{filename: name, nodes: nodes}
+	return file, p.errors
+}
+
+type Parser struct {
+	errors []Diagnostic
+	noLit bool // Set while parsing an if or for condition, where T{ starts the block rather than a composite literal
+}
+
+func (p *Parser) errorf(pos Position, format string, args ...any) {
+	p.errors = append(p.errors, Diagnostic{pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+// expect consumes the next token and records an error if it isn't the token we wanted
+func (p *Parser) expect(tokens TokenStream, want Token) (PackedToken, bool) {
+	next := tokens.Next()
+	if next.token != want {
+		p.errorf(next.pos, "expected %s, found %s", want, next)
+		return next, false
+	}
+	return next, true
+}
+
+// sync does panic-mode recovery: it drops tokens until the end of the current statement (a SEMI) or the end of the current scope (an RBRACE). Braces opened while skipping are skipped as a whole so that a broken function header throws away the entire function body.
+func (p *Parser) sync(tokens TokenStream) {
+	depth := 0
+	for {
+		switch tokens.Peek().token {
+		case EOF:
+			return
+		case SEMI:
+			if depth == 0 {
+				tokens.Next()
+				return
+			}
+		case LBRACE:
+			depth++
+		case RBRACE:
+			if depth == 0 {
+				return // Leave it for the enclosing scope
+			}
+			depth--
+			if depth == 0 {
+				tokens.Next()
+				return
+			}
+		}
+		tokens.Next()
+	}
+}
+
+// endStatement consumes the semicolon at the end of a statement. A closing brace on the same line also ends the statement but is left for the scope to consume. If the statement doesn't end here the unexpected token is returned
+func (p *Parser) endStatement(tokens TokenStream) (PackedToken, bool) {
+	next := tokens.Peek()
+	switch next.token {
+	case SEMI:
+		tokens.Next()
+		return next, true
+	case RBRACE, EOF:
+		return next, true
+	}
+	return next, false
+}
+
+// bad records an error at pos, recovers to the next statement and returns a placeholder node
+func (p *Parser) bad(tokens TokenStream, pos Position, format string, args ...any) Node {
+	p.errorf(pos, format, args...)
+	return p.skip(tokens, pos)
+}
+
+// skip recovers to the next statement after an error that has already been reported. The BadNode covers everything from pos to where parsing resumes
+func (p *Parser) skip(tokens TokenStream, pos Position) Node {
+	p.sync(tokens)
+	return &BadNode{pos, tokens.Peek().pos}
+}
+
+// ParseTil parses declarations until stopToken, which is consumed and returned
+func (p *Parser) ParseTil(tokens TokenStream, stopToken Token) ([]Node, PackedToken) {
+	nodes := make([]Node, 0)
+	for {
+		node := p.ParseDecl(tokens)
+		if node != nil {
+			nodes = append(nodes, node)
+			continue
+		}
+
+		next := tokens.Next()
+		if next.token == stopToken {
+			return nodes, next
+		}
+		if next.token == EOF {
+			p.errorf(next.pos, "expected %s, found EOF", stopToken)
+			return nodes, next
+		}
+		if next.token != SEMI {
+			nodes = append(nodes, p.bad(tokens, next.pos, "expected %s, found %s", stopToken, next))
+		}
+	}
+}
+
+func (p *Parser) ParseDecl(tokens TokenStream) Node {
+	next := tokens.Peek()
+	switch next.token {
+	case SEMI, RBRACE, EOF:
+		return nil
+	}
+
+	if next.token == IDENT {
+		switch next.str {
+		case "func":
+			tokens.Next()
+			return p.ParseFuncNode(tokens, next.pos)
+		case "return":
+			tokens.Next()
+			return p.ParseReturnNode(tokens, next.pos)
+		case "type":
+			tokens.Next()
+			return p.ParseTypeNode(tokens, next.pos)
+		case "package":
+			tokens.Next()
+			return p.ParsePackageNode(tokens, next.pos)
+		case "import":
+			tokens.Next()
+			return p.ParseImportNode(tokens, next.pos)
+		case "var":
+			tokens.Next()
+			return p.ParseVarNode(tokens, next.pos)
+		case "if":
+			tokens.Next()
+			return p.ParseIfNode(tokens, next.pos)
+		case "for":
+			tokens.Next()
+			return p.ParseForNode(tokens, next.pos)
+		case "break", "continue":
+			tokens.Next()
+			if end, ok := p.endStatement(tokens); !ok {
+				return p.bad(tokens, end.pos, "unexpected %s after %s", end, next.str)
+			}
+			return &BranchNode{next.pos, next.str}
+		}
+	}
+
+	return p.ParseSimpleStmt(tokens)
+}
+
+// Parsing functions
+
+
+func (p *Parser) ParseFuncNode(tokens TokenStream, pos Position) Node {
+	var recv *ArgNode
+	if tokens.Peek().token == LPAREN {
+		r := p.ParseArgNode(tokens)
+		if _, bad := r.(*BadNode); bad {
+			return r
+		}
+		recv = r.(*ArgNode)
+	}
+
+	next, ok := p.expect(tokens, IDENT)
+	if !ok {
+		return p.skip(tokens, next.pos)
+	}
+
+	args := p.ParseArgNode(tokens)
+	if _, bad := args.(*BadNode); bad {
+		return args
+	}
+
+	f := FuncNode{
+		funcPos: pos,
+		recv: recv,
+		pos: next.pos,
+		funcName: next.str,
+		arguments: args,
+	}
+	if ret := tokens.Peek(); ret.token == IDENT {
+		tokens.Next()
+		f.returnType = ret.str
+		f.returnPos = ret.pos
+	}
+
+	f.body = p.ParseCurlyScope(tokens)
+
+	return &f
+}
+
+func (p *Parser) ParseCurlyScope(tokens TokenStream) Node {
+	next, ok := p.expect(tokens, LBRACE)
+	if !ok {
+		return p.skip(tokens, next.pos)
+	}
+
+	body, rbrace := p.ParseTil(tokens, RBRACE)
+
+	return &CurlyScope{next.pos, body, rbrace.pos}
+}
+
+
+// ParsePackageNode parses the rest of a package clause: name
+func (p *Parser) ParsePackageNode(tokens TokenStream, pos Position) Node {
+	name, ok := p.expect(tokens, IDENT)
+	if !ok {
+		return p.skip(tokens, name.pos)
+	}
+	if next, ok := p.endStatement(tokens); !ok {
+		return p.bad(tokens, next.pos, "unexpected %s after package clause", next)
+	}
+	return &PackageNode{pos, name.str, name.pos}
+}
+
+// ParseImportNode parses the rest of an import declaration: "path"
+func (p *Parser) ParseImportNode(tokens TokenStream, pos Position) Node {
+	lit, ok := p.expect(tokens, STRING)
+	if !ok {
+		return p.skip(tokens, lit.pos)
+	}
+	path, err := strconv.Unquote(lit.str)
+	if err != nil || path == "" {
+		return p.bad(tokens, lit.pos, "invalid import path %s", lit.str)
+	}
+	if next, ok := p.endStatement(tokens); !ok {
+		return p.bad(tokens, next.pos, "unexpected %s after import", next)
+	}
+	return &ImportNode{pos, path, lit.str, lit.pos}
+}
+
+// ParseTypeNode parses the rest of a type declaration: Name Kind
+func (p *Parser) ParseTypeNode(tokens TokenStream, pos Position) Node {
+	name, ok := p.expect(tokens, IDENT)
+	if !ok {
+		return p.skip(tokens, name.pos)
+	}
+	kind, ok := p.expect(tokens, IDENT)
+	if !ok {
+		return p.skip(tokens, kind.pos)
+	}
+
+	n := &TypeNode{pos: pos, name: name.str, namePos: name.pos, kind: kind.str, kindPos: kind.pos}
+	if n.isStruct() {
+		if !p.parseFields(tokens, n) {
+			return p.skip(tokens, pos)
+		}
+	}
+
+	if next, ok := p.endStatement(tokens); !ok {
+		return p.bad(tokens, next.pos, "unexpected %s after type declaration", next)
+	}
+	return n
+}
+
+// parseFields parses the body of a struct type: { name kind; ... }
+func (p *Parser) parseFields(tokens TokenStream, n *TypeNode) bool {
+	if _, ok := p.expect(tokens, LBRACE); !ok {
+		return false
+	}
+	for {
+		next := tokens.Peek()
+		switch next.token {
+		case SEMI:
+			tokens.Next()
+			continue
+		case RBRACE:
+			n.rbrace = tokens.Next().pos
+			return true
+		}
+
+		field, ok := p.ParseTypedArg(tokens)
+		if !ok {
+			return false
+		}
+		n.fields = append(n.fields, field)
+
+		if next := tokens.Peek(); next.token != SEMI && next.token != RBRACE {
+			p.errorf(next.pos, "expected ; or }, found %s", next)
+			return false
+		}
+	}
+}
+
+// ParseVarNode parses the rest of a variable declaration: name [kind] [= expr]
+func (p *Parser) ParseVarNode(tokens TokenStream, pos Position) Node {
+	name, ok := p.expect(tokens, IDENT)
+	if !ok {
+		return p.skip(tokens, name.pos)
+	}
+
+	v := VarNode{pos: pos, name: name.str, namePos: name.pos}
+	if kind := tokens.Peek(); kind.token == IDENT {
+		tokens.Next()
+		v.kind = kind.str
+		v.kindPos = kind.pos
+	}
+	if tokens.Peek().token == ASSIGN {
+		tokens.Next()
+		v.expr = p.ParseExprNode(tokens)
+		if _, bad := v.expr.(*BadNode); bad {
+			return v.expr
+		}
+	} else if v.kind == "" {
+		next := tokens.Peek()
+		return p.bad(tokens, next.pos, "expected type or =, found %s", next)
+	}
+
+	if next, ok := p.endStatement(tokens); !ok {
+		return p.bad(tokens, next.pos, "unexpected %s after variable declaration", next)
+	}
+	return &v
+}
+
+// ParseIfNode parses the rest of an if statement, including any else if chain
+func (p *Parser) ParseIfNode(tokens TokenStream, pos Position) Node {
+	cond := p.parseCond(tokens)
+	if _, bad := cond.(*BadNode); bad {
+		return cond
+	}
+	then := p.ParseCurlyScope(tokens)
+	if _, bad := then.(*BadNode); bad {
+		return then
+	}
+
+	n := IfNode{pos: pos, cond: cond, then: then}
+	if next := tokens.Peek(); next.token == IDENT && next.str == "else" {
+		tokens.Next()
+		if elseIf := tokens.Peek(); elseIf.token == IDENT && elseIf.str == "if" {
+			tokens.Next()
+			n.els = p.ParseIfNode(tokens, elseIf.pos)
+		} else {
+			n.els = p.ParseCurlyScope(tokens)
+		}
+	}
+	return &n
+}
+
+// ParseForNode parses the rest of a for loop. The condition is optional
+func (p *Parser) ParseForNode(tokens TokenStream, pos Position) Node {
+	n := ForNode{pos: pos}
+	if tokens.Peek().token != LBRACE {
+		n.cond = p.parseCond(tokens)
+		if _, bad := n.cond.(*BadNode); bad {
+			return n.cond
+		}
+	}
+	n.body = p.ParseCurlyScope(tokens)
+	return &n
+}
+
+// parseCond parses the condition of an if or for. Composite literals have to be in parentheses there, like in Go
+func (p *Parser) parseCond(tokens TokenStream) Node {
+	noLit := p.noLit
+	p.noLit = true
+	cond := p.ParseExprNode(tokens)
+	p.noLit = noLit
+	return cond
+}
+
+// ParseSimpleStmt parses the statements that don't start with a keyword: x := expr, x = expr, x.f = expr and function calls
+func (p *Parser) ParseSimpleStmt(tokens TokenStream) Node {
+	lhs := p.ParseExprNode(tokens)
+	if _, bad := lhs.(*BadNode); bad {
+		return lhs
+	}
+
+	op := tokens.Peek()
+	if _, call := lhs.(*CallExprNode); call && op.token != DEFINE && op.token != ASSIGN {
+		if next, ok := p.endStatement(tokens); !ok {
+			return p.bad(tokens, next.pos, "unexpected %s after expression", next)
+		}
+		return &ExprStmtNode{lhs}
+	}
+	if op.token != DEFINE && op.token != ASSIGN {
+		return p.bad(tokens, op.pos, "expected := or =, found %s", op)
+	}
+	tokens.Next()
+
+	sel, isField := lhs.(*SelectorNode)
+	ident, ok := lhs.(*UnaryNode)
+	if isField && op.token == DEFINE {
+		return p.bad(tokens, op.pos, "cannot declare a field with :=")
+	}
+	if !isField && (!ok || ident.token.token != IDENT) {
+		return p.bad(tokens, op.pos, "cannot assign to expression")
+	}
+
+	expr := p.ParseExprNode(tokens)
+	if _, bad := expr.(*BadNode); bad {
+		return expr
+	}
+	if next, ok := p.endStatement(tokens); !ok {
+		return p.bad(tokens, next.pos, "unexpected %s after expression", next)
+	}
+
+	if isField {
+		return &FieldAssignNode{sel, expr}
+	}
+	if op.token == DEFINE {
+		return &VarNode{pos: ident.token.pos, name: ident.token.str, namePos: ident.token.pos, expr: expr, short: true}
+	}
+	return &AssignNode{ident.token.pos, ident.token.str, expr}
+}
+
+// ParseReturnNode parses the rest of a return statement. The expression is left nil for a bare return
+func (p *Parser) ParseReturnNode(tokens TokenStream, pos Position) Node {
+	if _, ok := p.endStatement(tokens); ok {
+		return &ReturnNode{pos: pos}
+	}
+
+	expr := p.ParseExprNode(tokens)
+	if _, bad := expr.(*BadNode); bad {
+		return expr
+	}
+
+	if next, ok := p.endStatement(tokens); !ok {
+		return p.bad(tokens, next.pos, "unexpected %s after expression", next)
+	}
+
+	r := ReturnNode{
+		pos: pos,
+		expr: expr,
+	}
+	return &r
+}
+
+func (p *Parser) ParseArgNode(tokens TokenStream) Node {
+	next, ok := p.expect(tokens, LPAREN)
+	if !ok {
+		return p.skip(tokens, next.pos)
+	}
+
+	args := ArgNode{lparen: next.pos, args: make([]Arg, 0)}
+	for {
+		if tokens.Peek().token == RPAREN { break }
+
+		arg, ok := p.ParseTypedArg(tokens)
+		if !ok {
+			return p.skip(tokens, next.pos)
+		}
+		args.args = append(args.args, arg)
+
+		switch after := tokens.Peek(); after.token {
+		case COMMA:
+			tokens.Next()
+		case RPAREN:
+		default:
+			p.errorf(after.pos, "expected , or ), found %s", after)
+			return p.skip(tokens, next.pos)
+		}
+	}
+
+	args.rparen = tokens.Next().pos
+
+	return &args
+}
+
+func (p *Parser) ParseTypedArg(tokens TokenStream) (Arg, bool) {
+	name, ok := p.expect(tokens, IDENT)
+	if !ok {
+		return Arg{}, false
+	}
+
+	kind, ok := p.expect(tokens, IDENT)
+	if !ok {
+		return Arg{}, false
+	}
+
+	return Arg{name.str, kind.str, name.pos, kind.pos}, true
+}
+
+// ParseExprNode parses a full expression by precedence climbing. If any part of the expression is malformed the whole expression becomes a BadNode
+func (p *Parser) ParseExprNode(tokens TokenStream) Node {
+	return p.parseBinaryExpr(tokens, 1)
+}
+
+// parseBinaryExpr parses operands joined by operators that bind at least as tightly as minPrec. The right hand side is parsed one level tighter, which makes operators of equal precedence left associative
+func (p *Parser) parseBinaryExpr(tokens TokenStream, minPrec int) Node {
+	lhs := p.parseUnaryExpr(tokens)
+	for {
+		if _, bad := lhs.(*BadNode); bad {
+			return lhs
+		}
+
+		next := tokens.Peek()
+		binary, ok := binaryOps[next.token]
+		if !ok || binary.prec < minPrec {
+			return lhs
+		}
+		tokens.Next()
+
+		rhs := p.parseBinaryExpr(tokens, binary.prec+1)
+		if _, bad := rhs.(*BadNode); bad {
+			return rhs
+		}
+		lhs = &BinaryExprNode{
+			pos: next.pos,
+			op: binary.op,
+			left: lhs,
+			right: rhs,
+		}
+	}
+}
+
+func (p *Parser) parseUnaryExpr(tokens TokenStream) Node {
+	next := tokens.Peek()
+	switch next.token {
+	case IDENT:
+		var expr Node = &UnaryNode{tokens.Next()}
+		if tokens.Peek().token == LBRACE && !p.noLit {
+			expr = p.parseCompositeLit(tokens, next)
+		}
+		return p.parsePostfix(tokens, expr)
+	case INT, FLOAT, STRING:
+		return &UnaryNode{tokens.Next()}
+	case SUB, NOT:
+		tokens.Next()
+		expr := p.parseUnaryExpr(tokens)
+		if _, bad := expr.(*BadNode); bad {
+			return expr
+		}
+		op := OpSub
+		if next.token == NOT {
+			op = OpNot
+		}
+		return &PrefixExprNode{next.pos, op, expr}
+	case LPAREN:
+		tokens.Next()
+		noLit := p.noLit
+		p.noLit = false
+		expr := p.parseBinaryExpr(tokens, 1)
+		p.noLit = noLit
+		if _, bad := expr.(*BadNode); bad {
+			return expr
+		}
+		if closing := tokens.Peek(); closing.token != RPAREN {
+			return p.bad(tokens, closing.pos, "expected ), found %s", closing)
+		}
+		return p.parsePostfix(tokens, &ExprNode{next.pos, expr, tokens.Next().pos})
+	}
+	return p.bad(tokens, next.pos, "expected operand, found %s", next)
+}
+
+// parsePostfix parses any calls and selectors after an operand: f(x).y()
+func (p *Parser) parsePostfix(tokens TokenStream, expr Node) Node {
+	for {
+		if _, bad := expr.(*BadNode); bad {
+			return expr
+		}
+		switch tokens.Peek().token {
+		case LPAREN:
+			expr = p.parseCall(tokens, expr)
+		case DOT:
+			tokens.Next()
+			name, ok := p.expect(tokens, IDENT)
+			if !ok {
+				return p.skip(tokens, name.pos)
+			}
+			expr = &SelectorNode{expr, name.str, name.pos}
+		default:
+			return expr
+		}
+	}
+}
+
+// parseCompositeLit parses the elements of a struct literal, kind is the type name before the {
+func (p *Parser) parseCompositeLit(tokens TokenStream, kind PackedToken) Node {
+	noLit := p.noLit
+	p.noLit = false
+	defer func() { p.noLit = noLit }()
+
+	// Recovery stops at our closing brace, which would otherwise be taken as the end of the enclosing block
+	skipLit := func() Node {
+		if tokens.Peek().token == RBRACE {
+			tokens.Next()
+			p.sync(tokens)
+		}
+		return &BadNode{kind.pos, tokens.Peek().pos}
+	}
+
+	lit := CompositeLitNode{pos: kind.pos, kind: kind.str, lbrace: tokens.Next().pos}
+	for tokens.Peek().token != RBRACE {
+		field := FieldInit{}
+		if name := tokens.Peek(); name.token == IDENT && tokens.PeekN(1).token == COLON {
+			tokens.Next()
+			tokens.Next()
+			field.name = name.str
+			field.namePos = name.pos
+		}
+		field.expr = p.ParseExprNode(tokens)
+		if _, bad := field.expr.(*BadNode); bad {
+			return skipLit()
+		}
+		lit.fields = append(lit.fields, field)
+
+		next := tokens.Peek()
+		if next.token == COMMA {
+			tokens.Next()
+		} else if next.token != RBRACE {
+			p.errorf(next.pos, "expected , or }, found %s", next)
+			p.sync(tokens)
+			return skipLit()
+		}
+	}
+	lit.rbrace = tokens.Next().pos
+	return &lit
+}
+
+// parseCall parses the argument list of a call to fn
+func (p *Parser) parseCall(tokens TokenStream, fn Node) Node {
+	noLit := p.noLit
+	p.noLit = false
+	defer func() { p.noLit = noLit }()
+
+	lparen := tokens.Next()
+	call := CallExprNode{pos: lparen.pos, fn: fn}
+	for tokens.Peek().token != RPAREN {
+		arg := p.ParseExprNode(tokens)
+		if _, bad := arg.(*BadNode); bad {
+			return arg
+		}
+		call.args = append(call.args, arg)
+
+		next := tokens.Peek()
+		if next.token == COMMA {
+			tokens.Next()
+		} else if next.token != RPAREN {
+			return p.bad(tokens, next.pos, "expected , or ), found %s", next)
+		}
+	}
+	call.rparen = tokens.Next().pos
+	return &call
+}
@@ -0,0 +1,289 @@
+package noot
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseString(t *testing.T, src string) (*FileNode, []Diagnostic) {
+	t.Helper()
+	parser := Parser{}
+	return parser.ParseFile("test", NewLexStream(NewLexer(strings.NewReader(src))))
+}
+
+func TestParseRecoversFromErrors(t *testing.T) {
+	src := `func 1(x X) {
+	return x
+}
+
+func B(x X y) {
+	return x
+}
+
+func C(x X) {
+	return x + ;
+}
+
+func D(x X) {
+	return x
+}
+
+func E x X) {
+	return x
+}
+
+func F(x X) {
+	return x ) (
+}
+
+func G(x X, y Y) {
+	return x + y
+}
+`
+	file, errs := parseString(t, src)
+	if len(errs) != 5 {
+		t.Fatalf("expected 5 errors, got %d: %v", len(errs), errs)
+	}
+
+	wantLines := []int{1, 5, 10, 17, 22}
+	for i, e := range errs {
+		if e.pos.line != wantLines[i] {
+			t.Errorf("error %d: expected line %d, got %s", i, wantLines[i], e)
+		}
+	}
+
+	// Broken headers become BadNodes, broken bodies still give a FuncNode
+	funcs, bad := 0, 0
+	for _, n := range file.nodes {
+		switch n.(type) {
+		case *FuncNode:
+			funcs++
+		case *BadNode:
+			bad++
+		}
+	}
+	if funcs != 4 || bad != 3 {
+		t.Errorf("expected 4 functions and 3 bad nodes, got %d and %d", funcs, bad)
+	}
+}
+
+func TestParseArgsNeedCommas(t *testing.T) {
+	file, errs := parseString(t, "func f(a int b int) int {\n\treturn a + b\n}\n\nfunc g(a int, b int) int {\n\treturn a\n}\n")
+	if len(errs) != 1 || errs[0].Error() != "1:14: expected , or ), found IDENT \"b\"" {
+		t.Fatalf("got %v", errs)
+	}
+	if _, bad := file.nodes[0].(*BadNode); !bad || len(file.nodes) != 2 {
+		t.Errorf("expected the broken function to be skipped, got %s", sexpr(file))
+	}
+}
+
+func TestParseInputFile(t *testing.T) {
+	src := `func FunctionA(x X, y Y) {
+     return x + 1
+}
+
+func FunctionB(x X, y Y) {
+     return (x + 1 * (1 + 2 + (2 + 2) + 4))
+}
+`
+	file, errs := parseString(t, src)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(file.nodes) != 2 {
+		t.Fatalf("expected 2 functions, got %d", len(file.nodes))
+	}
+}
+
+func TestParseExprPrecedence(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"x + 1 * (1 + 2)", "(+ x (* 1 (+ 1 2)))"},
+		{"1 * 2 + 3", "(+ (* 1 2) 3)"},
+		{"x - 1 - 2", "(- (- x 1) 2)"},
+		{"x / y * z", "(* (/ x y) z)"},
+		{"-x * 2", "(* (- x) 2)"},
+		{"-(x + 1)", "(- (+ x 1))"},
+		{"((x))", "x"},
+		{"(x + 1 * (1 + 2 + (2 + 2) + 4))", "(+ x (* 1 (+ (+ (+ 1 2) (+ 2 2)) 4)))"},
+		{"f(x, 1) * 2", "(* (call f x 1) 2)"},
+		{"f() + g(h(x), (1 + 2))", "(+ (call f) (call g (call h x) (+ 1 2)))"},
+		{"-f(x)", "(- (call f x))"},
+	}
+	for _, test := range tests {
+		file, errs := parseString(t, "func F(x X) {\n\treturn "+test.src+"\n}\n")
+		if len(errs) != 0 {
+			t.Errorf("%s: unexpected errors: %v", test.src, errs)
+			continue
+		}
+		ret := file.nodes[0].(*FuncNode).body.(*CurlyScope).nodes[0].(*ReturnNode)
+		if got := sexpr(ret.expr); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.src, got, test.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, src := range []string{"(x + 1", "x +", "x y", "* 2", "()", "f(x", "f(x y)", "f(,)"} {
+		_, errs := parseString(t, "func F(x X) {\n\treturn "+src+"\n}\n")
+		if len(errs) != 1 {
+			t.Errorf("%s: expected 1 error, got %v", src, errs)
+		}
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	file, errs := parseString(t, `func F(n int) int {
+	var total = 0
+	var i int
+	for i < n {
+		i = i + 1
+		if i == 3 {
+			continue
+		} else if i > 8 && !(n == 100) {
+			break
+		} else {
+			total = total + i
+		}
+	}
+	for {
+		x := 1
+		break
+	}
+	return total
+}
+`)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	body := file.nodes[0].(*FuncNode).body.(*CurlyScope).nodes
+	if len(body) != 5 {
+		t.Fatalf("expected 5 statements, got %d", len(body))
+	}
+	if v := body[0].(*VarNode); v.name != "total" || v.kind != "" || v.expr == nil {
+		t.Errorf("bad var: %+v", v)
+	}
+	if v := body[1].(*VarNode); v.name != "i" || v.kind != "int" || v.expr != nil {
+		t.Errorf("bad var: %+v", v)
+	}
+
+	loop := body[2].(*ForNode)
+	if got := sexpr(loop.cond); got != "(< i n)" {
+		t.Errorf("bad loop condition: %s", got)
+	}
+	stmts := loop.body.(*CurlyScope).nodes
+	if a := stmts[0].(*AssignNode); a.name != "i" || sexpr(a.expr) != "(+ i 1)" {
+		t.Errorf("bad assignment: %+v", a)
+	}
+	elseIf := stmts[1].(*IfNode).els.(*IfNode)
+	if got := sexpr(elseIf.cond); got != "(&& (> i 8) (! (== n 100)))" {
+		t.Errorf("bad else if condition: %s", got)
+	}
+	if _, ok := elseIf.els.(*CurlyScope); !ok {
+		t.Errorf("expected final else block, got %T", elseIf.els)
+	}
+
+	forever := body[3].(*ForNode)
+	if forever.cond != nil {
+		t.Errorf("expected no loop condition")
+	}
+	if v := forever.body.(*CurlyScope).nodes[0].(*VarNode); !v.short || v.name != "x" {
+		t.Errorf("bad short var: %+v", v)
+	}
+}
+
+func TestParseStructs(t *testing.T) {
+	file, errs := parseString(t, `type V struct { X float; Y float }
+type Empty struct {}
+func (v V) Len() float {
+	v.X = -v.Y
+	if v == (V{}) {
+		return V{X: 1, Y: v.X}.Y + f(V{1, 2}).X
+	}
+	return v.Scale(2).X
+}
+`)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := []string{
+		"(type V struct (X float) (Y float))",
+		"(type Empty struct)",
+		"(func (args (v V)) Len (args) float (block (= (. v X) (- (. v Y))) (if (== v (lit V)) (block (return (+ (. (lit V (X 1) (Y (. v X))) Y) (. (call f (lit V 1 2)) X))))) (return (. (call (. v Scale) 2) X))))",
+	}
+	for i := range want {
+		if got := sexpr(file.nodes[i]); got != want[i] {
+			t.Errorf("got %s\nwant %s", got, want[i])
+		}
+	}
+}
+
+func TestParseStatementErrors(t *testing.T) {
+	for _, src := range []string{"var", "var x", "x + 1", "1 = x", "x := ", "if x { ", "break x", "f() 1", "f() = 1", "x.Y := 1", "x. = 1", "V{X: }", "V{1 2}"} {
+		_, errs := parseString(t, "func F(x X) {\n\t"+src+"\n}\n")
+		if len(errs) == 0 {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}
+
+func TestParseUnterminatedComment(t *testing.T) {
+	_, errs := parseString(t, "func F() int {\n\treturn 1\n}\n/* never closed")
+	if len(errs) != 1 || errs[0].Error() != "4:1: comment not terminated" {
+		t.Errorf("got %v", errs)
+	}
+}
+
+func TestLexStream(t *testing.T) {
+	stream := NewLexStream(NewLexer(strings.NewReader("a + b")))
+	if got := stream.PeekN(2).str; got != "b" {
+		t.Errorf("PeekN(2): got %s, want b", got)
+	}
+	if got := stream.PeekN(10).token; got != EOF {
+		t.Errorf("PeekN past the end: got %s, want EOF", got)
+	}
+	for _, want := range []string{"a", "+", "b", "EOF", "EOF"} {
+		if got := stream.Next().str; got != want {
+			t.Errorf("Next: got %s, want %s", got, want)
+		}
+	}
+	if len(stream.buf) != 1 {
+		t.Errorf("expected only the EOF to be buffered, got %v", stream.buf)
+	}
+}
+
+func TestTokensEndWithoutEOF(t *testing.T) {
+	// A slice that doesn't end in EOF used to index out of range
+	tokens := &Tokens{[]PackedToken{{Position{1, 1}, IDENT, "func"}}}
+	if got := tokens.PeekN(3).token; got != EOF {
+		t.Errorf("got %s, want EOF", got)
+	}
+	parser := Parser{}
+	_, errs := parser.ParseFile("test", tokens)
+	if len(errs) != 1 {
+		t.Errorf("expected 1 error, got %v", errs)
+	}
+}
+
+// The parser should only ever hold on to a handful of tokens, however long the input is
+func TestLexStreamLookaheadStaysSmall(t *testing.T) {
+	src := strings.Builder{}
+	for i := 0; i < 2000; i++ {
+		src.WriteString("func F(x int) int {\n\ty := x * 2 + (x - 1)\n\tif y > 3 {\n\t\treturn y\n\t}\n\treturn f(x, y)\n}\n")
+	}
+	stream := NewLexStream(NewLexer(strings.NewReader(src.String())))
+	parser := Parser{}
+	file, errs := parser.ParseFile("test", stream)
+	if len(errs) != 0 {
+		t.Fatal(errs[0])
+	}
+	if len(file.nodes) != 2000 {
+		t.Errorf("expected 2000 functions, got %d", len(file.nodes))
+	}
+	if cap(stream.buf) > 4 {
+		t.Errorf("lookahead buffer grew to %d", cap(stream.buf))
+	}
+}
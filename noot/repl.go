@@ -0,0 +1,272 @@
+package noot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// --------------------------------------------------------------------------------
+// - REPL
+// --------------------------------------------------------------------------------
+// An interactive session on top of the checker and the tree-walking interpreter. Each chunk of input is either a single expression, which is evaluated and printed, or a list of declarations and statements. Type and function declarations are kept and rechecked with every chunk, variables declared by statements live on in a global environment.
+
+const replHelp = `enter an expression to print its value, or declarations and statements to run them
+  :ast SRC      print the syntax tree of SRC
+  :tokens SRC   print the tokens of SRC
+  :type EXPR    print the type of EXPR
+  :help         print this message
+  :quit         leave the repl
+`
+
+type Repl struct {
+	out io.Writer
+	decls []Node // The type and function declarations so far, in the order they were entered
+	globals map[string]string // The type of each global variable, by name. Declared types get new pointers from every Checker so they are looked up again each time
+	values *env
+	quit bool
+}
+
+func NewRepl(out io.Writer) *Repl {
+	return &Repl{
+		out: out,
+		globals: make(map[string]string),
+		values: &env{vars: make(map[string]value)},
+	}
+}
+
+// Run reads input until EOF or :quit. Lines are collected until their braces balance, so functions and blocks can be spread over several lines
+func (r *Repl) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	buf := strings.Builder{}
+	for !r.quit {
+		if buf.Len() == 0 {
+			fmt.Fprint(r.out, "> ")
+		} else {
+			fmt.Fprint(r.out, "... ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		buf.WriteString(scanner.Text())
+		buf.WriteString("\n")
+		if braceDepth(buf.String()) > 0 {
+			continue
+		}
+		r.Eval(buf.String())
+		buf.Reset()
+	}
+	if buf.Len() > 0 {
+		r.Eval(buf.String()) // Let the parser report what was left unclosed
+	}
+	return scanner.Err()
+}
+
+// braceDepth counts the braces in src that haven't been closed yet
+func braceDepth(src string) int {
+	depth := 0
+	for _, t := range lexAll(strings.NewReader(src)) {
+		switch t.token {
+		case LBRACE:
+			depth++
+		case RBRACE:
+			depth--
+		}
+	}
+	return depth
+}
+
+// Eval runs one complete chunk of input, printing its result or diagnostics
+func (r *Repl) Eval(src string) {
+	trimmed := strings.TrimSpace(src)
+	if trimmed == "" {
+		return
+	}
+	if !strings.HasPrefix(trimmed, ":") {
+		r.exec(src)
+		return
+	}
+
+	cmd, arg, _ := strings.Cut(trimmed[1:], " ")
+	switch cmd {
+	case "ast":
+		expr, nodes, errs := parseChunk(arg)
+		if expr != nil {
+			fmt.Fprintln(r.out, sexpr(expr))
+		} else {
+			writeAST(r.out, &FileNode{nodes: nodes}, "sexpr")
+		}
+		r.report(arg, errs)
+	case "tokens":
+		printTokens(r.out, lexAll(strings.NewReader(arg)))
+	case "type":
+		expr, _, errs := parseChunk(arg)
+		if len(errs) > 0 {
+			r.report(arg, errs)
+			return
+		}
+		if expr == nil {
+			fmt.Fprintln(r.out, ":type needs an expression")
+			return
+		}
+		c, s, errs := r.check(r.decls)
+		t := c.exprType(expr, s)
+		errs = append(errs, c.errors...)
+		if len(errs) > 0 {
+			r.report(arg, errs)
+			return
+		}
+		fmt.Fprintln(r.out, t)
+	case "help":
+		fmt.Fprint(r.out, replHelp)
+	case "quit", "q":
+		r.quit = true
+	default:
+		fmt.Fprintf(r.out, "unknown command :%s, try :help\n", cmd)
+	}
+}
+
+// report prints diagnostics against the chunk of input they were found in
+func (r *Repl) report(src string, errs []Diagnostic) {
+	renderer := NewRenderer(NewSourceFile("<repl>", []byte(src)), false)
+	for _, d := range errs {
+		renderer.Render(r.out, d)
+	}
+}
+
+// reportErr prints an error from running a chunk, with the source line for runtime errors
+func (r *Repl) reportErr(src string, err error) {
+	if rerr, ok := err.(*RuntimeError); ok {
+		r.report(src, []Diagnostic{rerr.Diagnostic()})
+		return
+	}
+	fmt.Fprintln(r.out, err)
+}
+
+// parseChunk parses src as a lone expression if it is one, otherwise as a list of declarations and statements
+func parseChunk(src string) (Node, []Node, []Diagnostic) {
+	tokens := lexAll(strings.NewReader(src))
+	if first := tokens[0]; first.token != IDENT || !keywords[first.str] {
+		parser := Parser{}
+		stream := &Tokens{tokens}
+		expr := parser.ParseExprNode(stream)
+		for stream.Peek().token == SEMI {
+			stream.Next()
+		}
+		if len(parser.errors) == 0 && stream.Peek().token == EOF {
+			return expr, nil, nil
+		}
+	}
+
+	parser := Parser{}
+	file, errs := parser.ParseFile("", &Tokens{tokens})
+	return nil, file.nodes, errs
+}
+
+// check makes a checker for the given declarations, and the scope that the globals are visible in
+func (r *Repl) check(decls []Node) (*Checker, *scope, []Diagnostic) {
+	c := NewChecker()
+	errs := c.Check(&FileNode{nodes: decls})
+	c.errors = nil
+	c.ret = nil
+	c.loops = 0
+
+	vars := make(map[string]*Type, len(r.globals))
+	for name, kind := range r.globals {
+		vars[name] = c.resolveTypeQuiet(kind)
+	}
+	return c, &scope{universe, vars}, errs
+}
+
+// funcKey tells functions apart from methods of the same name, which don't replace each other
+func funcKey(f *FuncNode) string {
+	if f.recv != nil && len(f.recv.args) == 1 {
+		return "method " + f.recv.args[0].kind + "." + f.funcName
+	}
+	return "func " + f.funcName
+}
+
+// exec checks and runs a chunk of input. Nothing is kept from a chunk that doesn't check
+func (r *Repl) exec(src string) {
+	expr, nodes, errs := parseChunk(src)
+	if len(errs) > 0 {
+		r.report(src, errs)
+		return
+	}
+
+	if expr != nil {
+		c, s, errs := r.check(r.decls)
+		t := c.exprType(expr, s)
+		c.exprTypes[expr] = t
+		errs = append(errs, c.errors...)
+		if len(errs) > 0 {
+			r.report(src, errs)
+			return
+		}
+
+		in := NewInterpreter(&FileNode{nodes: r.decls})
+		v, err := in.eval(expr, r.values)
+		if err != nil {
+			r.reportErr(src, err)
+			return
+		}
+		if t == typeNone {
+			return
+		}
+		fmt.Fprintln(r.out, v)
+		return
+	}
+
+	// New declarations replace old ones with the same name
+	decls := []Node{}
+	stmts := []Node{}
+	declared := make(map[string]bool)
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TypeNode:
+			declared["type "+n.name] = true
+			decls = append(decls, n)
+		case *FuncNode:
+			declared[funcKey(n)] = true
+			decls = append(decls, n)
+		default:
+			stmts = append(stmts, n)
+		}
+	}
+	for i := len(r.decls) - 1; i >= 0; i-- {
+		switch n := r.decls[i].(type) {
+		case *TypeNode:
+			if declared["type "+n.name] {
+				continue
+			}
+		case *FuncNode:
+			if declared[funcKey(n)] {
+				continue
+			}
+		}
+		decls = append([]Node{r.decls[i]}, decls...)
+	}
+
+	c, s, errs := r.check(decls)
+	local := &scope{s, make(map[string]*Type)}
+	c.checkStmts(stmts, local)
+	errs = append(errs, c.errors...)
+	if len(errs) > 0 {
+		r.report(src, errs)
+		return
+	}
+	r.decls = decls
+
+	// Variables declared before a runtime error are kept, like they would be if they had been entered one at a time
+	in := NewInterpreter(&FileNode{nodes: decls})
+	e := &env{r.values, make(map[string]value)}
+	_, _, err := in.execStmts(stmts, e)
+	for name, v := range e.vars {
+		r.values.vars[name] = v
+		r.globals[name] = local.vars[name].name
+	}
+	if err != nil {
+		r.reportErr(src, err)
+	}
+}
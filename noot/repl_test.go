@@ -0,0 +1,70 @@
+package noot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// replSession runs the input through a repl and returns everything it printed, without the prompts
+func replSession(t *testing.T, input string) string {
+	t.Helper()
+	out := bytes.Buffer{}
+	if err := NewRepl(&out).Run(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	s := strings.ReplaceAll(out.String(), "... ", "")
+	return strings.ReplaceAll(s, "> ", "")
+}
+
+func TestRepl(t *testing.T) {
+	tests := []struct {
+		name string
+		input string
+		want string
+	}{
+		{"expressions", "1 + 2 * 3\n(1 < 2) && !false\n", "7\ntrue\n"},
+		{"globals persist", "x := 4\ny := x * 2\nx = x + y\nx\n", "12\n"},
+		{"multi-line function", "func fib(n int) int {\n  if n < 2 {\n    return n\n  }\n  return fib(n-1) + fib(n-2)\n}\nfib(10)\n", "55\n"},
+		{"redeclaring a function", "func f() int { return 1 }\nfunc f() int { return 2 }\nf()\n", "2\n"},
+		{"declared types", "type Age int\nfunc older(a Age) Age { return a + 1 }\nvar a Age = 3\nolder(a)\n", "4\n"},
+		{"statements", "n := 0\nfor n < 5 { n = n + 2 }\nn\n", "6\n"},
+		{"calls with no value print nothing", "func f() {}\nf()\n", ""},
+		{"diagnostics", "x := true + 1\nundefined\n", "<repl>:1:11: error: mismatched types bool and untyped int\n1 | x := true + 1\n  |           ^\n<repl>:1:1: error: undefined: undefined\n1 | undefined\n  | ^~~~~~~~~\n"},
+		{"parse errors", "x := \n", "<repl>:2:0: error: expected operand, found EOF\n2 | \n  | ^\n"},
+		{"failed chunks leave nothing behind", "func g() int { return true }\ng()\n", "<repl>:1:16: error: cannot return bool as int\n1 | func g() int { return true }\n  |                ^~~~~~\n<repl>:1:1: error: undefined: g\n1 | g()\n  | ^\n"},
+		{"runtime errors", "x := 1\nx / (x - 1)\n", "<repl>:1:3: error: division by zero\n1 | x / (x - 1)\n  |   ^\n"},
+		{"quit", "1\n:quit\n2\n", "1\n"},
+		{"unclosed input at EOF", "func f() {\n", "<repl>:2:0: error: expected }, found EOF\n2 | \n  | ^\n"},
+	}
+	for _, test := range tests {
+		if got := replSession(t, test.input); got != test.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReplMetaCommands(t *testing.T) {
+	tests := []struct {
+		input string
+		want string
+	}{
+		{":type 1 + 2\n", "untyped int\n"},
+		{"type X bool\nvar x X\n:type !x\n", "X\n"},
+		{":type nope\n", "<repl>:1:1: error: undefined: nope\n1 | nope\n  | ^~~~\n"},
+		{":ast a + b * 2\n", "(+ a (* b 2))\n"},
+		{":tokens x := 1\n", "1:1\tIDENT\tx\n1:3\t:=\t:=\n1:6\tINT\t1\n1:7\tEOF\tEOF\n"},
+		{":what\n", "unknown command :what, try :help\n"},
+	}
+	for _, test := range tests {
+		if got := replSession(t, test.input); got != test.want {
+			t.Errorf("%q: got:\n%q\nwant:\n%q", test.input, got, test.want)
+		}
+	}
+
+	// :ast follows braces onto the next lines like any other input
+	got := replSession(t, ":ast func f() {\n  return\n}\n")
+	if got != "(func f (args) (block (return)))\n" {
+		t.Errorf("unexpected :ast output:\n%s", got)
+	}
+}
@@ -0,0 +1,521 @@
+package noot
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// --------------------------------------------------------------------------------
+// - Bytecode
+// --------------------------------------------------------------------------------
+// Every instruction is a single opcode byte, some are followed by operands. Operands are little endian uint16s unless noted otherwise, so a function can have at most 65536 constants and local slots and 65535 bytes of code, and a program 65536 functions and strings. The compiler reports anything bigger.
+// Values are untagged ints, the compiler picks the instructions from the types the checker found. Floats are stored as their IEEE 754 bits, which needs 64 bit ints. Strings are indices into the VM's strings: the program's constants come first, then the strings a call makes, which are dropped when the next call starts.
+
+type Opcode uint8
+const (
+	CodeConst Opcode = iota // idx: push constants[idx]
+	CodeLoad // slot: push the local in slot
+	CodeStore // slot: pop into the local in slot
+	CodePop // drop the top of the stack
+	CodeAdd
+	CodeSub
+	CodeMul
+	CodeDiv
+	CodeNeg
+	CodeEq
+	CodeNeq
+	CodeLt
+	CodeGt
+	CodeLe
+	CodeGe
+	CodeNot
+	CodeJump // target: continue at target
+	CodeJumpFalse // target: pop and continue at target if the value was zero
+	CodeCall // fn, argc (one byte): call program.funcs[fn] with the top argc values as arguments
+	CodeReturn // pop the result, all of the chunk's result slots for a struct, and return it to the caller
+
+	// The float versions of the arithmetic and comparisons
+	CodeAddF
+	CodeSubF
+	CodeMulF
+	CodeDivF
+	CodeNegF
+	CodeEqF
+	CodeNeqF
+	CodeLtF
+	CodeGtF
+	CodeLeF
+	CodeGeF
+
+	CodeCallHost // fn, argc (one byte): call the Go function program.hosts[fn]
+	CodeIntToFloat // convert the int on top of the stack to a float
+
+	CodeString // idx: push program.strings[idx]
+	CodeConcat
+	CodeEqS
+	CodeNeqS
+	CodeLtS
+	CodeGtS
+	CodeLeS
+	CodeGeS
+)
+
+var opcodes = []struct{
+	name string
+	operands int // Size of the operands in bytes
+}{
+	CodeConst: {"CONST", 2},
+	CodeLoad: {"LOAD", 2},
+	CodeStore: {"STORE", 2},
+	CodePop: {"POP", 0},
+	CodeAdd: {"ADD", 0},
+	CodeSub: {"SUB", 0},
+	CodeMul: {"MUL", 0},
+	CodeDiv: {"DIV", 0},
+	CodeNeg: {"NEG", 0},
+	CodeEq: {"EQ", 0},
+	CodeNeq: {"NEQ", 0},
+	CodeLt: {"LT", 0},
+	CodeGt: {"GT", 0},
+	CodeLe: {"LE", 0},
+	CodeGe: {"GE", 0},
+	CodeNot: {"NOT", 0},
+	CodeJump: {"JUMP", 2},
+	CodeJumpFalse: {"JUMP_FALSE", 2},
+	CodeCall: {"CALL", 3},
+	CodeReturn: {"RETURN", 0},
+
+	CodeAddF: {"ADDF", 0},
+	CodeSubF: {"SUBF", 0},
+	CodeMulF: {"MULF", 0},
+	CodeDivF: {"DIVF", 0},
+	CodeNegF: {"NEGF", 0},
+	CodeEqF: {"EQF", 0},
+	CodeNeqF: {"NEQF", 0},
+	CodeLtF: {"LTF", 0},
+	CodeGtF: {"GTF", 0},
+	CodeLeF: {"LEF", 0},
+	CodeGeF: {"GEF", 0},
+
+	CodeCallHost: {"CALL_HOST", 3},
+	CodeIntToFloat: {"ITOF", 0},
+
+	CodeString: {"STRING", 2},
+	CodeConcat: {"CONCAT", 0},
+	CodeEqS: {"EQS", 0},
+	CodeNeqS: {"NEQS", 0},
+	CodeLtS: {"LTS", 0},
+	CodeGtS: {"GTS", 0},
+	CodeLeS: {"LES", 0},
+	CodeGeS: {"GES", 0},
+}
+
+func (o Opcode) String() string {
+	if int(o) < len(opcodes) {
+		return opcodes[o].name
+	}
+	return fmt.Sprintf("OP_%d", o)
+}
+
+// Chunk is the compiled code of a single function
+type Chunk struct {
+	name string
+	file string // The file the function was declared in, for programs made by Link
+	arity int // Slots taken by the receiver and arguments, more than one each for structs
+	results int // Slots taken by the result
+	numLocals int // Includes the arguments
+	code []byte
+	lines []int // The source line of each byte in code
+	positions []Position // The full source position of each byte in code, for runtime errors
+	constants []int
+	constIndex map[int]uint16 // Where each constant is in constants, so big functions don't search it for every one
+}
+
+func (c *Chunk) emit(pos Position, b ...byte) int {
+	offset := len(c.code)
+	for i := range b {
+		c.code = append(c.code, b[i])
+		c.lines = append(c.lines, pos.line)
+		c.positions = append(c.positions, pos)
+	}
+	return offset
+}
+
+func (c *Chunk) emitArg(pos Position, op Opcode, arg uint16) int {
+	return c.emit(pos, byte(op), byte(arg), byte(arg>>8))
+}
+
+// patch overwrites the uint16 operand at offset, this is used to fill in jump targets once they are known
+func (c *Chunk) patch(offset int, arg uint16) {
+	c.code[offset] = byte(arg)
+	c.code[offset+1] = byte(arg >> 8)
+}
+
+func (c *Chunk) addConstant(v int) uint16 {
+	if i, ok := c.constIndex[v]; ok {
+		return i
+	}
+	if c.constIndex == nil {
+		c.constIndex = make(map[int]uint16)
+	}
+	c.constants = append(c.constants, v)
+	c.constIndex[v] = uint16(len(c.constants) - 1)
+	return c.constIndex[v]
+}
+
+func readUint16(code []byte, offset int) uint16 {
+	return uint16(code[offset]) | uint16(code[offset+1])<<8
+}
+
+func floatToValue(f float64) int {
+	return int(math.Float64bits(f))
+}
+
+func valueToFloat(v int) float64 {
+	return math.Float64frombits(uint64(v))
+}
+
+type Program struct {
+	funcs []*Chunk
+	index map[string]int
+	hosts []*hostFunc
+	strings []string // String constants. The first is always "", which is what the zero value 0 refers to
+	stringIndex map[string]int
+}
+
+func (p *Program) addString(s string) int {
+	if p.stringIndex == nil {
+		p.stringIndex = make(map[string]int, len(p.strings))
+		for i := range p.strings {
+			p.stringIndex[p.strings[i]] = i
+		}
+	}
+	if i, ok := p.stringIndex[s]; ok {
+		return i
+	}
+	p.strings = append(p.strings, s)
+	p.stringIndex[s] = len(p.strings) - 1
+	return len(p.strings) - 1
+}
+
+// hostFunc is a Go function that scripts can call. call gets the arguments as VM values and returns the result as one
+type hostFunc struct {
+	name string
+	arity int
+	call func(vm *VM, args []int) (int, error)
+}
+
+// Disassemble prints the bytecode of every function. Each line has the byte offset, the source line (or | if it is the same as the previous instruction), the opcode and its operands
+func (p *Program) Disassemble() string {
+	buf := bytes.Buffer{}
+	for _, c := range p.funcs {
+		c.disassemble(p, &buf)
+	}
+	return buf.String()
+}
+
+func (c *Chunk) disassemble(p *Program, buf *bytes.Buffer) {
+	buf.WriteString(fmt.Sprintf("== %s (args: %d, locals: %d) ==\n", c.name, c.arity, c.numLocals))
+	for offset := 0; offset < len(c.code); {
+		op := Opcode(c.code[offset])
+
+		line := fmt.Sprintf("%4d", c.lines[offset])
+		if offset > 0 && c.lines[offset] == c.lines[offset-1] {
+			line = "   |"
+		}
+		buf.WriteString(fmt.Sprintf("%04d %s %-10s", offset, line, op))
+
+		switch op {
+		case CodeConst:
+			idx := readUint16(c.code, offset+1)
+			buf.WriteString(fmt.Sprintf(" %d (%d)", idx, c.constants[idx]))
+		case CodeString:
+			idx := readUint16(c.code, offset+1)
+			buf.WriteString(fmt.Sprintf(" %d (%q)", idx, p.strings[idx]))
+		case CodeLoad, CodeStore, CodeJump, CodeJumpFalse:
+			buf.WriteString(fmt.Sprintf(" %d", readUint16(c.code, offset+1)))
+		case CodeCall:
+			fn := readUint16(c.code, offset+1)
+			buf.WriteString(fmt.Sprintf(" %d (%s) %d", fn, p.funcs[fn].name, c.code[offset+3]))
+		case CodeCallHost:
+			fn := readUint16(c.code, offset+1)
+			buf.WriteString(fmt.Sprintf(" %d (%s) %d", fn, p.hosts[fn].name, c.code[offset+3]))
+		}
+		buf.WriteString("\n")
+
+		if int(op) >= len(opcodes) {
+			return // Don't know how long this one is, so nothing after it can be trusted
+		}
+		offset += 1 + opcodes[op].operands
+	}
+}
+
+// --------------------------------------------------------------------------------
+// - VM
+// --------------------------------------------------------------------------------
+
+type frame struct {
+	chunk *Chunk
+	ip int
+	base int // Index of the first local on the stack
+}
+
+// VM runs a compiled Program. Locals live on the value stack: the arguments are pushed by the caller and become the first locals of the callee's frame.
+type VM struct {
+	program *Program
+	stack []int
+	frames []frame
+	strings []string // The program's strings, then the ones made since the last call started
+	limit int // The most instructions a call may run, 0 for no limit
+}
+
+func NewVM(program *Program) *VM {
+	return &VM{
+		program: program,
+		stack: make([]int, 0, 256),
+		frames: make([]frame, 0, 64),
+		strings: program.strings[:len(program.strings):len(program.strings)], // Appending copies them instead of writing over the program's
+	}
+}
+
+// Call runs the function with the given name, the same as Interpreter.Call. A struct result comes back as its first slot
+func (vm *VM) Call(name string, args ...int) (int, error) {
+	vm.clearStrings()
+	results, err := vm.call(name, args)
+	if err != nil || len(results) == 0 {
+		return 0, err
+	}
+	return results[0], nil
+}
+
+// clearStrings drops the strings made by earlier calls. Nothing can refer to them once their results have been read
+func (vm *VM) clearStrings() {
+	vm.strings = vm.strings[:len(vm.program.strings)]
+}
+
+// intern adds a string made at runtime and gives the value that refers to it
+func (vm *VM) intern(s string) int {
+	vm.strings = append(vm.strings, s)
+	return len(vm.strings) - 1
+}
+
+// call runs a function with its arguments already laid out in slots, and gives all of its result slots. They are only valid until the next call, and strings among them until clearStrings
+func (vm *VM) call(name string, args []int) ([]int, error) {
+	fn, ok := vm.program.index[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined function: %s", name)
+	}
+	chunk := vm.program.funcs[fn]
+	if chunk.arity != len(args) {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, chunk.arity, len(args))
+	}
+
+	vm.stack = append(vm.stack[:0], args...)
+	vm.frames = vm.frames[:0]
+	vm.push(chunk, 0)
+	if err := vm.run(); err != nil {
+		return nil, err
+	}
+	return vm.stack, nil
+}
+
+// SetLimit stops every later call with an error once it has run n instructions. 0 removes the limit
+func (vm *VM) SetLimit(n int) {
+	vm.limit = n
+}
+
+// push enters a new frame for chunk. The arguments are already on the stack, space for the rest of the locals is reserved here
+func (vm *VM) push(chunk *Chunk, base int) {
+	for i := chunk.arity; i < chunk.numLocals; i++ {
+		vm.stack = append(vm.stack, 0)
+	}
+	vm.frames = append(vm.frames, frame{chunk, 0, base})
+}
+
+// run executes until the outermost frame returns, which leaves only its results on the stack
+func (vm *VM) run() error {
+	f := &vm.frames[len(vm.frames)-1]
+	code := f.chunk.code
+	budget := vm.limit
+	for {
+		if vm.limit > 0 {
+			if budget == 0 {
+				return vm.errorf("instruction limit of %d exceeded", vm.limit)
+			}
+			budget--
+		}
+		op := Opcode(code[f.ip])
+		f.ip++
+
+		switch op {
+		case CodeConst:
+			idx := readUint16(code, f.ip)
+			f.ip += 2
+			vm.stack = append(vm.stack, f.chunk.constants[idx])
+		case CodeLoad:
+			slot := readUint16(code, f.ip)
+			f.ip += 2
+			vm.stack = append(vm.stack, vm.stack[f.base+int(slot)])
+		case CodeStore:
+			slot := readUint16(code, f.ip)
+			f.ip += 2
+			vm.stack[f.base+int(slot)] = vm.stack[len(vm.stack)-1]
+			vm.stack = vm.stack[:len(vm.stack)-1]
+		case CodePop:
+			vm.stack = vm.stack[:len(vm.stack)-1]
+		case CodeAdd, CodeSub, CodeMul, CodeDiv, CodeEq, CodeNeq, CodeLt, CodeGt, CodeLe, CodeGe:
+			top := len(vm.stack) - 1
+			a, b := vm.stack[top-1], vm.stack[top]
+			switch op {
+			case CodeAdd:
+				a += b
+			case CodeSub:
+				a -= b
+			case CodeMul:
+				a *= b
+			case CodeDiv:
+				if b == 0 {
+					return vm.errorf("division by zero")
+				}
+				a /= b
+			case CodeEq:
+				a = boolToInt(a == b)
+			case CodeNeq:
+				a = boolToInt(a != b)
+			case CodeLt:
+				a = boolToInt(a < b)
+			case CodeGt:
+				a = boolToInt(a > b)
+			case CodeLe:
+				a = boolToInt(a <= b)
+			case CodeGe:
+				a = boolToInt(a >= b)
+			}
+			vm.stack[top-1] = a
+			vm.stack = vm.stack[:top]
+		case CodeAddF, CodeSubF, CodeMulF, CodeDivF, CodeEqF, CodeNeqF, CodeLtF, CodeGtF, CodeLeF, CodeGeF:
+			top := len(vm.stack) - 1
+			a, b := valueToFloat(vm.stack[top-1]), valueToFloat(vm.stack[top])
+			var result int
+			switch op {
+			case CodeAddF:
+				result = floatToValue(a + b)
+			case CodeSubF:
+				result = floatToValue(a - b)
+			case CodeMulF:
+				result = floatToValue(a * b)
+			case CodeDivF:
+				result = floatToValue(a / b) // Gives an infinity or NaN for zero, like Go
+			case CodeEqF:
+				result = boolToInt(a == b)
+			case CodeNeqF:
+				result = boolToInt(a != b)
+			case CodeLtF:
+				result = boolToInt(a < b)
+			case CodeGtF:
+				result = boolToInt(a > b)
+			case CodeLeF:
+				result = boolToInt(a <= b)
+			case CodeGeF:
+				result = boolToInt(a >= b)
+			}
+			vm.stack[top-1] = result
+			vm.stack = vm.stack[:top]
+		case CodeString:
+			vm.stack = append(vm.stack, int(readUint16(code, f.ip)))
+			f.ip += 2
+		case CodeConcat, CodeEqS, CodeNeqS, CodeLtS, CodeGtS, CodeLeS, CodeGeS:
+			top := len(vm.stack) - 1
+			a, b := vm.strings[vm.stack[top-1]], vm.strings[vm.stack[top]]
+			var result int
+			switch op {
+			case CodeConcat:
+				result = vm.intern(a + b)
+			case CodeEqS:
+				result = boolToInt(a == b)
+			case CodeNeqS:
+				result = boolToInt(a != b)
+			case CodeLtS:
+				result = boolToInt(a < b)
+			case CodeGtS:
+				result = boolToInt(a > b)
+			case CodeLeS:
+				result = boolToInt(a <= b)
+			case CodeGeS:
+				result = boolToInt(a >= b)
+			}
+			vm.stack[top-1] = result
+			vm.stack = vm.stack[:top]
+		case CodeNeg:
+			top := len(vm.stack) - 1
+			vm.stack[top] = -vm.stack[top]
+		case CodeNegF:
+			top := len(vm.stack) - 1
+			vm.stack[top] = floatToValue(-valueToFloat(vm.stack[top]))
+		case CodeIntToFloat:
+			top := len(vm.stack) - 1
+			vm.stack[top] = floatToValue(float64(vm.stack[top]))
+		case CodeNot:
+			top := len(vm.stack) - 1
+			vm.stack[top] = boolToInt(vm.stack[top] == 0)
+		case CodeJump:
+			f.ip = int(readUint16(code, f.ip))
+		case CodeJumpFalse:
+			target := readUint16(code, f.ip)
+			f.ip += 2
+			top := len(vm.stack) - 1
+			cond := vm.stack[top]
+			vm.stack = vm.stack[:top]
+			if cond == 0 {
+				f.ip = int(target)
+			}
+		case CodeCall:
+			fn := readUint16(code, f.ip)
+			argc := int(code[f.ip+2])
+			f.ip += 3
+			if callee := vm.program.funcs[fn]; callee.arity != argc {
+				return vm.errorf("%s expects %d arguments, got %d", callee.name, callee.arity, argc)
+			}
+			if len(vm.frames) >= maxCallDepth {
+				return vm.errorf("stack overflow calling %s", vm.program.funcs[fn].name)
+			}
+			vm.push(vm.program.funcs[fn], len(vm.stack)-argc)
+			f = &vm.frames[len(vm.frames)-1]
+			code = f.chunk.code
+		case CodeCallHost:
+			host := vm.program.hosts[readUint16(code, f.ip)]
+			argc := int(code[f.ip+2])
+			f.ip += 3
+			args := vm.stack[len(vm.stack)-argc:]
+			result, err := host.call(vm, args)
+			if err != nil {
+				return vm.errorf("%s: %v", host.name, err)
+			}
+			vm.stack = append(vm.stack[:len(vm.stack)-argc], result)
+		case CodeReturn:
+			results := vm.stack[len(vm.stack)-f.chunk.results:]
+			vm.stack = append(vm.stack[:f.base], results...)
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == 0 {
+				return nil
+			}
+			f = &vm.frames[len(vm.frames)-1]
+			code = f.chunk.code
+		default:
+			return vm.errorf("unknown opcode %s", op)
+		}
+	}
+}
+
+// errorf builds a runtime error at the position of the instruction that is currently executing
+func (vm *VM) errorf(format string, args ...any) *RuntimeError {
+	f := vm.frames[len(vm.frames)-1]
+	pos := Position{}
+	if f.ip > 0 {
+		pos = f.chunk.positions[f.ip-1]
+	}
+	err := runtimeErrorf(pos, format, args...)
+	err.file = f.chunk.file
+	return err
+}
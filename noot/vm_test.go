@@ -0,0 +1,453 @@
+package noot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func compileInputTest(t testing.TB) *Program {
+	t.Helper()
+	file, err := os.Open("input.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	parser := Parser{}
+	tree, errs := parser.ParseFile("input.test", &Tokens{lexAll(file)})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	program, errs := Compile(tree)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected compile errors: %v", errs)
+	}
+	return program
+}
+
+// The VM has to agree with the interpreter on everything
+func TestVMMatchesInterpreter(t *testing.T) {
+	in := loadInputTest(t)
+	vm := NewVM(compileInputTest(t))
+
+	for _, name := range []string{"FunctionA", "FunctionB", "FunctionC"} {
+		for x := -3; x <= 3; x++ {
+			want, err := in.Call(name, x, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := vm.Call(name, x, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("%s(%d, 7): vm got %d, interpreter got %d", name, x, got, want)
+			}
+		}
+	}
+}
+
+// Untyped int constants are worked out with ints even when they end up as floats, like Go. The wasm tests run these too
+const untypedConsts = `func half(x float) float {
+	var h float = 1 / 2
+	return h + x
+}
+
+func mixed(x float) float {
+	var f float = (3 / 2) + 0.5
+	return f * -(7 / 2) + 1 / 2.0 + x
+}
+
+func scale(x float) float {
+	return x * (5 / 2) + -(9 / 4)
+}
+
+func above(x float) bool {
+	return x > 7 / 2
+}
+`
+
+// Structs on the VM are copied whenever they are stored, the same as the interpreter's values
+const vmStructs = `type Vec struct {
+	X float
+	Y float
+}
+
+type Body struct {
+	pos Vec
+	vel Vec
+	mass int
+}
+
+type Pair struct {
+	a int
+	b int
+}
+
+func (v Vec) add(o Vec) Vec {
+	return Vec{v.X + o.X, v.Y + o.Y}
+}
+
+func (v Vec) scale(k float) Vec {
+	v.X = v.X * k
+	v.Y = v.Y * k
+	return v
+}
+
+func (p Pair) sum() int {
+	return p.a + p.b
+}
+
+func step(b Body, dt float) Body {
+	b.pos = b.pos.add(b.vel.scale(dt))
+	return b
+}
+
+func simulate(n int) float {
+	b := Body{vel: Vec{1, 2}, mass: n}
+	start := b
+	for n > 0 {
+		b = step(b, 0.5)
+		n = n - 1
+	}
+	if b == start {
+		return -1
+	}
+	return b.pos.X + b.pos.Y * 10 + start.pos.X
+}
+
+func copies(n int) int {
+	a := Body{mass: n}
+	b := a
+	b.mass = 7
+	(b.pos).X = 1.5
+	if a == b {
+		return 0
+	}
+	v := Vec{1, 2}
+	w := v.scale(3)
+	if a.mass == n && a.pos.X == 0 && v.X == 1 && w.X == 3 {
+		return 1
+	}
+	return 2
+}
+
+func order(n int) int {
+	p := Pair{b: next(n), a: next(n * 2)}
+	var q Pair
+	q.a = n
+	return p.a * 100 + p.b + pair(n).b * 1000 + q.sum() * 10000 + Pair{n, 1}.sum()
+}
+
+func next(n int) int {
+	return n + 1
+}
+
+func pair(n int) Pair {
+	return Pair{n, n + 1}
+}
+
+func floats(n int) int {
+	z := 0.0
+	nan := z / z
+	count := 0
+	if (Vec{nan, 1}) != (Vec{nan, 1}) {
+		count = count + 1
+	}
+	if (Vec{-z, 1}) == (Vec{z, 1}) {
+		count = count + 10
+	}
+	if (Body{mass: n}) == (Body{mass: 3}) {
+		count = count + 100
+	}
+	return count
+}
+`
+
+const vmStrings = `type Named struct {
+	name string
+	n int
+}
+
+func build(n int) int {
+	s := "a"
+	var t string
+	for n > 0 {
+		t = t + s
+		s = s + "b"
+		n = n - 1
+	}
+	count := 0
+	if t == "aab" {
+		count = count + 1
+	}
+	if t < s {
+		count = count + 10
+	}
+	if t != "" {
+		count = count + 100
+	}
+	if (Named{t, 1}) == (Named{"a" + "ab", 1}) {
+		count = count + 1000
+	}
+	if "x\ty" >= "x\n" {
+		count = count + 10000
+	}
+	return count
+}
+
+func pick(n int) bool {
+	names := Named{"b", n}
+	if n > 1 {
+		names.name = "a" + names.name
+	}
+	return names.name <= "ab"
+}
+`
+
+// Checked programs use the float instructions, so they are run through the checker first
+func TestVMMatchesInterpreterChecked(t *testing.T) {
+	tests := []struct {
+		name string
+		src string
+		calls [][]int
+		funcs []string
+	}{
+		{"program.noot", gogenProgram, [][]int{{1}, {2}, {7}, {12}, {-4}}, []string{"fib", "collatz", "isPrime", "shadow", "nothing"}},
+		{"floats.noot", wasmFloats, [][]int{{-3, floatToValue(0.25)}, {0, floatToValue(-1)}, {4, floatToValue(1e10)}}, []string{"avg", "clamp"}},
+		{"logic.noot", wasmFloats, [][]int{{-4}, {-1}, {0}, {6}, {9}}, []string{"logic", "count", "sink"}},
+		{"consts.noot", untypedConsts, [][]int{{floatToValue(0)}, {floatToValue(3.25)}, {floatToValue(-1.5)}}, []string{"half", "mixed", "scale", "above"}},
+		{"structs.noot", vmStructs, [][]int{{0}, {1}, {3}, {-5}}, []string{"simulate", "copies", "order", "floats"}},
+		{"strings.noot", vmStrings, [][]int{{0}, {1}, {2}, {3}}, []string{"build", "pick"}},
+	}
+
+	for _, test := range tests {
+		file, checker, errs := (&source{name: test.name, data: []byte(test.src)}).check()
+		if len(errs) > 0 {
+			t.Fatalf("%s: %v", test.name, errs)
+		}
+		program, errs := compileChecked(file, checker, nil)
+		if len(errs) > 0 {
+			t.Fatalf("%s: %v", test.name, errs)
+		}
+		vm := NewVM(program)
+		in := NewInterpreter(file)
+		for _, name := range test.funcs {
+			for _, args := range test.calls {
+				want, err := in.Call(name, args...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := vm.Call(name, args...)
+				if err != nil {
+					t.Errorf("%s: %s%v: %v", test.name, name, args, err)
+					continue
+				}
+				if got != want {
+					t.Errorf("%s: %s%v: vm got %d, interpreter got %d", test.name, name, args, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestVMErrors(t *testing.T) {
+	tree, _ := parseString(t, "func F(x int) int {\n\treturn 1 + x / 0\n}\n")
+	program, errs := Compile(tree)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	_, err := NewVM(program).Call("F", 1)
+	if err == nil || err.Error() != "2:15: division by zero" {
+		t.Errorf("expected division by zero at the / on line 2, got %v", err)
+	}
+
+	// How big a struct is comes from the checker, so they can't be compiled without it
+	tree, _ = parseString(t, "type V struct {\n\tX int\n}\nfunc F(x int) int {\n\tv := V{x}\n\treturn v.X\n}\n")
+	if _, errs := Compile(tree); len(errs) != 1 || errs[0].Error() != "1:1: struct type V can only be compiled after type checking" {
+		t.Errorf("expected struct errors, got %v", errs)
+	}
+}
+
+// A struct result takes up more than one slot, which call gives back together
+func TestVMStructResults(t *testing.T) {
+	file, checker, errs := (&source{name: "structs.noot", data: []byte(vmStructs)}).check()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	program, errs := compileChecked(file, checker, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want, err := NewInterpreter(file).call(checker.funcs["step"], nil, []value{
+		{kind: KindStruct, typ: "Body", fields: []value{
+			{kind: KindStruct, typ: "Vec", fields: []value{{kind: KindFloat, f: 1}, {kind: KindFloat, f: 2}}},
+			{kind: KindStruct, typ: "Vec", fields: []value{{kind: KindFloat, f: -4}, {kind: KindFloat, f: 0.5}}},
+			{kind: KindInt, i: 3},
+		}},
+		{kind: KindFloat, f: 0.25},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := NewVM(program).call("step", []int{floatToValue(1), floatToValue(2), floatToValue(-4), floatToValue(0.5), 3, floatToValue(0.25)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fromVMSlots(results, checker.types["Body"], nil); got.String() != want.String() || got.String() != "Body{Vec{0, 2.125}, Vec{-4, 0.5}, 3}" {
+		t.Errorf("vm got %s, interpreter got %s", got, want)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	tree, _ := parseString(t, "func F(x int, y int) int {\n\treturn x + 2 *\n\t\t-y\n}\n")
+	program, _ := Compile(tree)
+	want := `== F (args: 2, locals: 2) ==
+0000    2 LOAD       0
+0003    | CONST      0 (2)
+0006    3 LOAD       1
+0009    | NEG       
+0010    2 MUL       
+0011    | ADD       
+0012    | RETURN    
+`
+	if got := program.Disassemble(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// --------------------------------------------------------------------------------
+// - Benchmarks
+// --------------------------------------------------------------------------------
+// How much do we pay for running noot code instead of Go code? Here are the input.test functions written directly in Go, these are the best case that the VM and the interpreter are chasing.
+type X int
+type Y int
+
+func FunctionA(x X, y Y) X {
+	return x + 1
+}
+
+func FunctionB(x X, y Y) X {
+	return (x + 1*(1+2+(2+2)+4))
+}
+
+func FunctionC(x X, y Y) X {
+	return x + 3
+}
+
+// The Go compiler would happily inline these and fold the loop away, so we stash the results somewhere it can't see through
+var sink int
+
+func BenchmarkGoFunctionB(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink += int(FunctionB(X(i), 2))
+	}
+}
+
+func BenchmarkInterpreterFunctionB(b *testing.B) {
+	in := loadInputTest(b)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		v, _ := in.Call("FunctionB", i, 2)
+		sink += v
+	}
+}
+
+func BenchmarkVMFunctionB(b *testing.B) {
+	vm := NewVM(compileInputTest(b))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		v, _ := vm.Call("FunctionB", i, 2)
+		sink += v
+	}
+}
+
+// Same again but for the smallest function, this is mostly measuring the cost of getting in and out of a call
+func BenchmarkGoFunctionA(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink += int(FunctionA(X(i), 2)) + int(FunctionC(X(i), 2))
+	}
+}
+
+func BenchmarkInterpreterFunctionA(b *testing.B) {
+	in := loadInputTest(b)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		a, _ := in.Call("FunctionA", i, 2)
+		c, _ := in.Call("FunctionC", i, 2)
+		sink += a + c
+	}
+}
+
+func BenchmarkVMFunctionA(b *testing.B) {
+	vm := NewVM(compileInputTest(b))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		a, _ := vm.Call("FunctionA", i, 2)
+		c, _ := vm.Call("FunctionC", i, 2)
+		sink += a + c
+	}
+}
+
+// Operands are uint16s, so functions that need bigger ones have to be rejected rather than wrap around
+func TestCompileLimits(t *testing.T) {
+	// Each struct is twice the size of the one before, so B17 takes 131072 slots
+	locals := strings.Builder{}
+	locals.WriteString("type B0 struct {\n\tX int\n}\n")
+	for i := 1; i <= 17; i++ {
+		fmt.Fprintf(&locals, "type B%d struct {\n\tL B%d\n\tR B%d\n}\n", i, i-1, i-1)
+	}
+	locals.WriteString("func F() int {\n\tvar b B17\n\treturn b.L.L.L.L.L.L.L.L.L.L.L.L.L.L.L.L.L.X\n}\n")
+
+	constants := strings.Builder{}
+	constants.WriteString("func F() int {\n\tx := 0\n")
+	for i := 1; i <= maxOperand+1; i++ {
+		fmt.Fprintf(&constants, "\tx = %d\n", i)
+	}
+	constants.WriteString("\treturn x\n}\n")
+
+	code := strings.Builder{}
+	code.WriteString("func F(x int) int {\n\tfor x < 10 {\n")
+	for i := 0; i < 7000; i++ {
+		code.WriteString("\t\tx = x + 1\n")
+	}
+	code.WriteString("\t}\n\treturn x\n}\n")
+
+	strs := strings.Builder{}
+	strs.WriteString("func F() string {\n\ts := \"\"\n")
+	for i := 1; i <= maxOperand+1; i++ {
+		fmt.Fprintf(&strs, "\ts = \"%d\"\n", i)
+	}
+	strs.WriteString("\treturn s\n}\n")
+
+	tests := []struct {
+		name string
+		src string
+		want string
+	}{
+		{"locals", locals.String(), "too many local variables in F: the VM can address at most 65536 slots"},
+		{"constants", constants.String(), "too many constants in F: the VM can hold at most 65536"},
+		{"code", code.String(), "F is too long: the VM can jump within at most 65535 bytes of code"},
+		{"strings", strs.String(), "too many strings: the VM can hold at most 65536"},
+	}
+	for _, test := range tests {
+		file, checker, errs := (&source{name: test.name + ".noot", data: []byte(test.src)}).check()
+		if len(errs) > 0 {
+			t.Fatalf("%s: %v", test.name, errs)
+		}
+		_, errs = compileChecked(file, checker, nil)
+		found := false
+		for _, e := range errs {
+			found = found || strings.HasSuffix(e.Error(), test.want)
+		}
+		if !found {
+			t.Errorf("%s: expected %q, got %v", test.name, test.want, errs)
+		}
+	}
+}
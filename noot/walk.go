@@ -0,0 +1,173 @@
+package noot
+
+import "fmt"
+
+// --------------------------------------------------------------------------------
+// - Traversal
+// --------------------------------------------------------------------------------
+// Walk and Rewrite are the only places that need to know the children of every node type. Passes over the tree are written against these instead of switching on every node themselves.
+
+// Visitor is called for each node by Walk. If the returned visitor w is not nil, Walk visits each of the node's children with w, followed by a call of w.Visit(nil)
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree depth first in source order, starting at node
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	walkList := func(nodes []Node) {
+		for i := range nodes {
+			Walk(v, nodes[i])
+		}
+	}
+
+	switch n := node.(type) {
+	case *FileNode:
+		walkList(n.nodes)
+	case *FuncNode:
+		if n.recv != nil {
+			Walk(v, n.recv)
+		}
+		Walk(v, n.arguments)
+		Walk(v, n.body)
+	case *CurlyScope:
+		walkList(n.nodes)
+	case *ReturnNode:
+		if n.expr != nil {
+			Walk(v, n.expr)
+		}
+	case *VarNode:
+		if n.expr != nil {
+			Walk(v, n.expr)
+		}
+	case *AssignNode:
+		Walk(v, n.expr)
+	case *FieldAssignNode:
+		Walk(v, n.target)
+		Walk(v, n.expr)
+	case *IfNode:
+		Walk(v, n.cond)
+		Walk(v, n.then)
+		if n.els != nil {
+			Walk(v, n.els)
+		}
+	case *ForNode:
+		if n.cond != nil {
+			Walk(v, n.cond)
+		}
+		Walk(v, n.body)
+	case *ExprNode:
+		Walk(v, n.expr)
+	case *ExprStmtNode:
+		Walk(v, n.expr)
+	case *BinaryExprNode:
+		Walk(v, n.left)
+		Walk(v, n.right)
+	case *PrefixExprNode:
+		Walk(v, n.expr)
+	case *CallExprNode:
+		Walk(v, n.fn)
+		walkList(n.args)
+	case *SelectorNode:
+		Walk(v, n.expr)
+	case *CompositeLitNode:
+		for _, field := range n.fields {
+			Walk(v, field.expr)
+		}
+	case *ArgNode, *TypeNode, *BranchNode, *UnaryNode, *BadNode, *PackageNode, *ImportNode:
+		// Leaves
+	default:
+		panic(fmt.Sprintf("Walk: unexpected node %T", node))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect calls f for every node in the tree. If f returns false the children of that node are skipped. After the children f is called once more with nil
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite replaces nodes in the tree bottom up: the children of a node are rewritten first, then f is called on the node itself and whatever it returns takes the node's place in its parent. Returning the node unchanged leaves it where it is. The new root is returned
+func Rewrite(node Node, f func(Node) Node) Node {
+	if node == nil {
+		return nil
+	}
+
+	rewriteList := func(nodes []Node) {
+		for i := range nodes {
+			nodes[i] = Rewrite(nodes[i], f)
+		}
+	}
+
+	switch n := node.(type) {
+	case *FileNode:
+		rewriteList(n.nodes)
+	case *FuncNode:
+		// The receiver has to stay an ArgNode, anything else f returns for it is ignored
+		if n.recv != nil {
+			if recv, ok := Rewrite(n.recv, f).(*ArgNode); ok {
+				n.recv = recv
+			}
+		}
+		n.arguments = Rewrite(n.arguments, f)
+		n.body = Rewrite(n.body, f)
+	case *CurlyScope:
+		rewriteList(n.nodes)
+	case *ReturnNode:
+		n.expr = Rewrite(n.expr, f)
+	case *VarNode:
+		n.expr = Rewrite(n.expr, f)
+	case *AssignNode:
+		n.expr = Rewrite(n.expr, f)
+	case *FieldAssignNode:
+		// The target has to stay a selector, anything else f returns for it is ignored
+		if target, ok := Rewrite(n.target, f).(*SelectorNode); ok {
+			n.target = target
+		}
+		n.expr = Rewrite(n.expr, f)
+	case *IfNode:
+		n.cond = Rewrite(n.cond, f)
+		n.then = Rewrite(n.then, f)
+		n.els = Rewrite(n.els, f)
+	case *ForNode:
+		n.cond = Rewrite(n.cond, f)
+		n.body = Rewrite(n.body, f)
+	case *ExprNode:
+		n.expr = Rewrite(n.expr, f)
+	case *ExprStmtNode:
+		n.expr = Rewrite(n.expr, f)
+	case *BinaryExprNode:
+		n.left = Rewrite(n.left, f)
+		n.right = Rewrite(n.right, f)
+	case *PrefixExprNode:
+		n.expr = Rewrite(n.expr, f)
+	case *CallExprNode:
+		n.fn = Rewrite(n.fn, f)
+		rewriteList(n.args)
+	case *SelectorNode:
+		n.expr = Rewrite(n.expr, f)
+	case *CompositeLitNode:
+		for i := range n.fields {
+			n.fields[i].expr = Rewrite(n.fields[i].expr, f)
+		}
+	case *ArgNode, *TypeNode, *BranchNode, *UnaryNode, *BadNode, *PackageNode, *ImportNode:
+		// Leaves
+	default:
+		panic(fmt.Sprintf("Rewrite: unexpected node %T", node))
+	}
+
+	return f(node)
+}
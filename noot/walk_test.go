@@ -0,0 +1,215 @@
+package noot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sourceText returns the text between two positions, End positions are exclusive
+func sourceText(src string, from, to Position) string {
+	lines := strings.Split(src, "\n")
+	offset := func(p Position) int {
+		n := 0
+		for i := 0; i < p.line-1; i++ {
+			n += len([]rune(lines[i])) + 1
+		}
+		return n + p.column - 1
+	}
+	runes := []rune(src)
+	return string(runes[offset(from):offset(to)])
+}
+
+func TestNodePositions(t *testing.T) {
+	src := `type X int
+func f(a int, b X) int {
+	x := (a + 1) * 2
+	var y int
+	if !true { y = -x } else if false { return } else { for { break } }
+	g(x, "héllo")
+	return x
+}`
+	file, errs := parseString(t, src)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	want := map[string]string{
+		"*noot.TypeNode": "type X int",
+		"*noot.FuncNode": src[strings.Index(src, "func"):],
+		"*noot.ArgNode": "(a int, b X)",
+		"*noot.VarNode": "x := (a + 1) * 2",
+		"*noot.BinaryExprNode": "(a + 1) * 2",
+		"*noot.ExprNode": "(a + 1)",
+		"*noot.IfNode": "if !true { y = -x } else if false { return } else { for { break } }",
+		"*noot.PrefixExprNode": "!true",
+		"*noot.AssignNode": "y = -x",
+		"*noot.ForNode": "for { break }",
+		"*noot.BranchNode": "break",
+		"*noot.ExprStmtNode": `g(x, "héllo")`,
+		"*noot.CallExprNode": `g(x, "héllo")`,
+		"*noot.ReturnNode": "return",
+	}
+	seen := map[string]bool{}
+	Inspect(file, func(n Node) bool {
+		key := fmt.Sprintf("%T", n)
+		if w, ok := want[key]; ok && !seen[key] {
+			seen[key] = true
+			if got := sourceText(src, n.Pos(), n.End()); got != w {
+				t.Errorf("%s: got %q, want %q", key, got, w)
+			}
+		}
+		// Every node has to fit inside its parent, so checking the children against the whole file catches anything that runs off the end
+		if n != nil && n.End().line > 8 {
+			t.Errorf("%T ends at %s, past the end of the file", n, n.End())
+		}
+		return true
+	})
+	for key := range want {
+		if !seen[key] {
+			t.Errorf("never visited a %s", key)
+		}
+	}
+}
+
+func TestBadNodeCoversSkippedTokens(t *testing.T) {
+	src := "x := 1 + + 2\ny := 3\n"
+	file, _ := parseString(t, src)
+	bad, ok := file.nodes[0].(*BadNode)
+	if !ok {
+		t.Fatalf("expected a BadNode, got %s", sexpr(file.nodes[0]))
+	}
+	if bad.Pos() != (Position{1, 10}) || bad.End() != (Position{2, 1}) {
+		t.Errorf("got %s-%s", bad.Pos(), bad.End())
+	}
+}
+
+// counter is a Visitor that records the nesting of every node it sees
+type counter struct {
+	depth int
+	out *[]string
+}
+
+func (c counter) Visit(node Node) Visitor {
+	if node == nil {
+		*c.out = append(*c.out, strings.Repeat(" ", c.depth-1)+"end")
+		return nil
+	}
+	*c.out = append(*c.out, strings.Repeat(" ", c.depth)+sexpr(node))
+	return counter{c.depth + 1, c.out}
+}
+
+func TestWalk(t *testing.T) {
+	file, _ := parseString(t, "return f(1, -x)\n")
+	out := []string{}
+	Walk(counter{0, &out}, file.nodes[0])
+	want := []string{
+		"(return (call f 1 (- x)))",
+		" (call f 1 (- x))",
+		"  f",
+		"  end",
+		"  1",
+		"  end",
+		"  (- x)",
+		"   x",
+		"   end",
+		"  end",
+		" end",
+		"end",
+	}
+	if strings.Join(out, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(out, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	file, _ := parseString(t, "func f() { g(1) }\nfunc h() { g(2) }\n")
+	calls := 0
+	Inspect(file, func(n Node) bool {
+		if f, ok := n.(*FuncNode); ok && f.funcName == "h" {
+			return false
+		}
+		if _, ok := n.(*CallExprNode); ok {
+			calls++
+		}
+		return true
+	})
+	if calls != 1 {
+		t.Errorf("expected to only see the call in f, saw %d calls", calls)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	file, _ := parseString(t, "func f() int { return (1 + 2) * x + -(3 + 4) }\n")
+
+	// Fold additions of two literals, bottom up so that nested sums are folded first
+	Rewrite(file, func(n Node) Node {
+		switch n := n.(type) {
+		case *ExprNode:
+			return n.expr
+		case *BinaryExprNode:
+			l, lok := n.left.(*UnaryNode)
+			r, rok := n.right.(*UnaryNode)
+			if n.op == OpAdd && lok && rok && l.token.token == INT && r.token.token == INT {
+				var a, b int
+				fmt.Sscan(l.token.str, &a)
+				fmt.Sscan(r.token.str, &b)
+				return &UnaryNode{PackedToken{l.token.pos, INT, fmt.Sprint(a + b)}}
+			}
+		}
+		return n
+	})
+
+	if got, want := sexpr(file), "(func f (args) int (block (return (+ (* 3 x) (- 7)))))"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// strayNode is a node type that the traversals don't know about
+type strayNode struct{}
+
+func (strayNode) Pos() Position { return Position{} }
+func (strayNode) End() Position { return Position{} }
+
+// Walk and Rewrite have to agree on the children of every node
+func TestWalkAndRewriteVisitTheSameNodes(t *testing.T) {
+	src := "package p\n\nimport \"lib\"\n\ntype V struct {\n\tX int\n}\n\nfunc (v V) Get(k int) int {\n\tvar y int = k\n\ty = -v.X\n\tv.X = V{X: 1}.X\n\tif y > 0 {\n\t\treturn lib.F(y)\n\t} else {\n\t\tfor y < 0 {\n\t\t\tbreak\n\t\t}\n\t}\n\treturn (y)\n}\n"
+	file, errs := parseString(t, src)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	walked, rewritten := []string{}, []string{}
+	Inspect(file, func(n Node) bool {
+		if n != nil {
+			walked = append(walked, fmt.Sprintf("%T", n))
+		}
+		return true
+	})
+	Rewrite(file, func(n Node) Node {
+		rewritten = append(rewritten, fmt.Sprintf("%T", n))
+		return n
+	})
+	sort.Strings(walked)
+	sort.Strings(rewritten)
+	if strings.Join(walked, " ") != strings.Join(rewritten, " ") {
+		t.Errorf("Walk saw %v\nRewrite saw %v", walked, rewritten)
+	}
+	if !strings.Contains(strings.Join(rewritten, " "), "*noot.ArgNode *noot.ArgNode") {
+		t.Errorf("expected the receiver and the arguments to be rewritten, got %v", rewritten)
+	}
+
+	for name, traverse := range map[string]func(){
+		"Walk": func() { Inspect(strayNode{}, func(Node) bool { return true }) },
+		"Rewrite": func() { Rewrite(strayNode{}, func(n Node) Node { return n }) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic on an unknown node", name)
+				}
+			}()
+			traverse()
+		}()
+	}
+}
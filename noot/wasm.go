@@ -0,0 +1,596 @@
+package noot
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// --------------------------------------------------------------------------------
+// - WebAssembly Backend
+// --------------------------------------------------------------------------------
+// Compiles a checked FileNode into a binary WebAssembly module, with a type, function, export and code section. Every function is exported under its own name, so a page can run it with:
+//   const { instance } = await WebAssembly.instantiate(bytes)
+//   instance.exports.fib(10n)
+// ints are i64 (BigInts on the JavaScript side), floats are f64 and bools are i32. Structs, methods and strings have no wasm values yet and are reported as errors.
+// Runtime errors become traps, like dividing by zero. Dividing the smallest int by -1 overflows back to itself, as in Go and the other backends, rather than trapping like i64.div_s does.
+
+// Value types and opcodes, from the WebAssembly core specification
+const (
+	wasmI32 byte = 0x7f
+	wasmI64 byte = 0x7e
+	wasmF64 byte = 0x7c
+	wasmEmpty byte = 0x40 // The block type of a block that leaves nothing on the stack
+
+	wasmUnreachable byte = 0x00
+	wasmBlock byte = 0x02
+	wasmLoop byte = 0x03
+	wasmIf byte = 0x04
+	wasmElse byte = 0x05
+	wasmEnd byte = 0x0b
+	wasmBr byte = 0x0c
+	wasmBrIf byte = 0x0d
+	wasmReturn byte = 0x0f
+	wasmCall byte = 0x10
+	wasmDrop byte = 0x1a
+	wasmLocalGet byte = 0x20
+	wasmLocalSet byte = 0x21
+	wasmI32Const byte = 0x41
+	wasmI64Const byte = 0x42
+	wasmF64Const byte = 0x44
+	wasmI32Eqz byte = 0x45
+	wasmI32Eq byte = 0x46
+	wasmI32Ne byte = 0x47
+	wasmF64Neg byte = 0x9a
+	wasmF64ConvertI64 byte = 0xb9 // convert_i64_s
+)
+
+var wasmIntCodes = map[Operator]byte{
+	OpAdd: 0x7c,
+	OpSub: 0x7d,
+	OpMul: 0x7e,
+	OpDiv: 0x7f, // div_s
+	OpEql: 0x51,
+	OpNeq: 0x52,
+	OpLss: 0x53,
+	OpGtr: 0x55,
+	OpLeq: 0x57,
+	OpGeq: 0x59,
+}
+
+var wasmFloatCodes = map[Operator]byte{
+	OpAdd: 0xa0,
+	OpSub: 0xa1,
+	OpMul: 0xa2,
+	OpDiv: 0xa3,
+	OpEql: 0x61,
+	OpNeq: 0x62,
+	OpLss: 0x63,
+	OpGtr: 0x64,
+	OpLeq: 0x65,
+	OpGeq: 0x66,
+}
+
+type wasmGen struct {
+	checker *Checker
+	index map[string]uint32 // Function indices, in the order the functions are declared
+	sigs [][]byte // Encoded function types, each one only once
+	file string // Where the declaration being compiled came from, if it was linked in
+	errors []Diagnostic
+
+	// State for the function being compiled
+	code bytes.Buffer
+	locals []byte // Types of the locals after the parameters
+	numParams int
+	scopes []map[string]uint32
+	depth int // How many blocks, loops and ifs we are inside
+	loops []wasmLabels
+	intConst bool // Compiling an untyped int constant that is used as a float, which is worked out with ints
+}
+
+// wasmLabels are the depths of the block around a loop, which break jumps to the end of, and of the loop itself, which continue jumps to the start of
+type wasmLabels struct {
+	brk int
+	cont int
+}
+
+func (g *wasmGen) errorf(pos Position, format string, args ...any) {
+	g.errors = append(g.errors, Diagnostic{file: g.file, pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+// GenerateWasm compiles the file, which has to have passed checker, into a WebAssembly module
+func GenerateWasm(file *FileNode, checker *Checker) ([]byte, []Diagnostic) {
+	g := wasmGen{
+		checker: checker,
+		index: make(map[string]uint32),
+	}
+
+	// Number the functions up front so that calls can refer to functions declared later in the file
+	funcs := []*FuncNode{}
+	for _, node := range file.nodes {
+		g.file = file.origins[node]
+		switch n := node.(type) {
+		case *TypeNode:
+			if n.isStruct() {
+				g.errorf(n.pos, "struct types are not supported by the wasm backend")
+			}
+		case *FuncNode:
+			if n.recv != nil {
+				g.errorf(n.pos, "method %s: methods are not supported by the wasm backend", n.funcName)
+				continue
+			}
+			g.index[n.funcName] = uint32(len(funcs))
+			funcs = append(funcs, n)
+		}
+	}
+
+	types := []uint32{}
+	bodies := [][]byte{}
+	for _, f := range funcs {
+		g.file = file.origins[f]
+		types = append(types, g.signature(f))
+		bodies = append(bodies, g.function(f))
+	}
+	if len(g.errors) > 0 {
+		return nil, g.errors
+	}
+
+	out := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+
+	section := []byte{}
+	section = wasmUint(section, uint64(len(g.sigs)))
+	for _, sig := range g.sigs {
+		section = append(section, sig...)
+	}
+	out = wasmSection(out, 1, section)
+
+	section = wasmUint(nil, uint64(len(types)))
+	for _, t := range types {
+		section = wasmUint(section, uint64(t))
+	}
+	out = wasmSection(out, 3, section)
+
+	section = wasmUint(nil, uint64(len(funcs)))
+	for i, f := range funcs {
+		section = wasmName(section, f.funcName)
+		section = append(section, 0x00) // A function export
+		section = wasmUint(section, uint64(i))
+	}
+	out = wasmSection(out, 7, section)
+
+	section = wasmUint(nil, uint64(len(bodies)))
+	for _, body := range bodies {
+		section = wasmUint(section, uint64(len(body)))
+		section = append(section, body...)
+	}
+	out = wasmSection(out, 10, section)
+	return out, nil
+}
+
+// ----
+// Encoding
+// ----
+
+// wasmUint appends v as an unsigned LEB128 number
+func wasmUint(buf []byte, v uint64) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+// wasmInt appends v as a signed LEB128 number
+func wasmInt(buf []byte, v int64) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && b&0x40 == 0 || v == -1 && b&0x40 != 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func wasmName(buf []byte, name string) []byte {
+	buf = wasmUint(buf, uint64(len(name)))
+	return append(buf, name...)
+}
+
+func wasmSection(buf []byte, id byte, content []byte) []byte {
+	buf = append(buf, id)
+	buf = wasmUint(buf, uint64(len(content)))
+	return append(buf, content...)
+}
+
+// valueType is the wasm type of values of t
+func (g *wasmGen) valueType(t *Type, pos Position) byte {
+	switch t.kind {
+	case KindInt, KindUntypedInt:
+		return wasmI64
+	case KindFloat, KindUntypedFloat:
+		return wasmF64
+	case KindBool:
+		return wasmI32
+	}
+	g.errorf(pos, "%s values are not supported by the wasm backend", t)
+	return wasmI64
+}
+
+// signature adds the function's type to the type section, if an identical one isn't there already, and gives its index
+func (g *wasmGen) signature(f *FuncNode) uint32 {
+	params := f.arguments.(*ArgNode).args
+	sig := []byte{0x60}
+	sig = wasmUint(sig, uint64(len(params)))
+	for i := range params {
+		sig = append(sig, g.valueType(g.checker.resolveTypeQuiet(params[i].kind), params[i].kindPos))
+	}
+	if f.returnType == "" {
+		sig = append(sig, 0)
+	} else {
+		sig = append(sig, 1, g.valueType(g.checker.resolveTypeQuiet(f.returnType), f.returnPos))
+	}
+
+	for i := range g.sigs {
+		if bytes.Equal(g.sigs[i], sig) {
+			return uint32(i)
+		}
+	}
+	g.sigs = append(g.sigs, sig)
+	return uint32(len(g.sigs) - 1)
+}
+
+// ----
+// Code
+// ----
+
+// function compiles a function body into its entry in the code section: the locals, then the code
+func (g *wasmGen) function(f *FuncNode) []byte {
+	params := f.arguments.(*ArgNode).args
+	g.code.Reset()
+	g.locals = nil
+	g.numParams = len(params)
+	g.scopes = []map[string]uint32{make(map[string]uint32)}
+	g.depth = 0
+	for i := range params {
+		g.scopes[0][params[i].name] = uint32(i)
+	}
+
+	// The body shares the arguments' scope, like in Go
+	if body, ok := f.body.(*CurlyScope); ok {
+		for i := range body.nodes {
+			g.stmt(body.nodes[i])
+		}
+	}
+	if f.returnType != "" {
+		g.emit(wasmUnreachable) // Every path has returned, but wasm's validator can't see that through an if
+	}
+	g.emit(wasmEnd)
+
+	// Locals are declared in runs of the same type
+	entry := []byte{}
+	runs := 0
+	for i := range g.locals {
+		if i == 0 || g.locals[i] != g.locals[i-1] {
+			runs++
+		}
+	}
+	entry = wasmUint(entry, uint64(runs))
+	for i := 0; i < len(g.locals); {
+		j := i
+		for j < len(g.locals) && g.locals[j] == g.locals[i] {
+			j++
+		}
+		entry = wasmUint(entry, uint64(j-i))
+		entry = append(entry, g.locals[i])
+		i = j
+	}
+	return append(entry, g.code.Bytes()...)
+}
+
+func (g *wasmGen) emit(code ...byte) {
+	g.code.Write(code)
+}
+
+func (g *wasmGen) emitUint(op byte, v uint32) {
+	g.code.Write(wasmUint([]byte{op}, uint64(v)))
+}
+
+// declare gives a new variable its own local. Locals aren't reused when a block ends
+func (g *wasmGen) declare(name string, t byte) uint32 {
+	local := g.temp(t)
+	g.scopes[len(g.scopes)-1][name] = local
+	return local
+}
+
+// temp adds a local that no variable refers to
+func (g *wasmGen) temp(t byte) uint32 {
+	g.locals = append(g.locals, t)
+	return uint32(g.numParams + len(g.locals) - 1)
+}
+
+// isIntLiteral reports whether n is an int written as a number, which can't be -1
+func isIntLiteral(n Node) bool {
+	u, ok := n.(*UnaryNode)
+	return ok && u.token.token == INT
+}
+
+// divide divides the two ints on the stack. i64.div_s traps on the smallest int divided by -1, so that case is worked out as 0 - a instead
+func (g *wasmGen) divide() {
+	a, b := g.temp(wasmI64), g.temp(wasmI64)
+	g.emitUint(wasmLocalSet, b)
+	g.emitUint(wasmLocalSet, a)
+	g.emitUint(wasmLocalGet, b)
+	g.emit(wasmI64Const, 0x7f, wasmIntCodes[OpEql]) // -1 in signed LEB128
+	g.emit(wasmIf, wasmI64)
+	g.emit(wasmI64Const, 0)
+	g.emitUint(wasmLocalGet, a)
+	g.emit(wasmIntCodes[OpSub], wasmElse)
+	g.emitUint(wasmLocalGet, a)
+	g.emitUint(wasmLocalGet, b)
+	g.emit(wasmIntCodes[OpDiv], wasmEnd)
+}
+
+func (g *wasmGen) resolve(name string) (uint32, bool) {
+	for i := len(g.scopes) - 1; i >= 0; i-- {
+		if local, ok := g.scopes[i][name]; ok {
+			return local, true
+		}
+	}
+	return 0, false
+}
+
+// typeOf gives the type the checker found for an expression
+func (g *wasmGen) typeOf(node Node) *Type {
+	if t, ok := g.checker.exprTypes[node]; ok {
+		return t
+	}
+	return TypeInvalid
+}
+
+// open starts a block, loop or if, which wasm's branches count outwards from
+func (g *wasmGen) open(op byte, blockType byte) {
+	g.emit(op, blockType)
+	g.depth++
+}
+
+func (g *wasmGen) close() {
+	g.emit(wasmEnd)
+	g.depth--
+}
+
+func (g *wasmGen) stmt(node Node) {
+	switch n := node.(type) {
+	case *CurlyScope:
+		g.scopes = append(g.scopes, make(map[string]uint32))
+		for i := range n.nodes {
+			g.stmt(n.nodes[i])
+		}
+		g.scopes = g.scopes[:len(g.scopes)-1]
+	case *ReturnNode:
+		if n.expr != nil {
+			g.expr(n.expr)
+		}
+		g.emit(wasmReturn)
+	case *ExprStmtNode:
+		g.expr(n.expr)
+		if g.typeOf(n.expr) != typeNone {
+			g.emit(wasmDrop)
+		}
+	case *VarNode:
+		var t *Type
+		if n.kind != "" {
+			t = g.checker.resolveTypeQuiet(n.kind)
+		} else {
+			t = defaultType(g.typeOf(n.expr))
+		}
+		vt := g.valueType(t, n.pos)
+		if n.expr != nil {
+			g.expr(n.expr)
+		} else {
+			g.zero(vt)
+		}
+		// Declared after the expression so that `x := x + 1` in a new block reads the outer x
+		g.emitUint(wasmLocalSet, g.declare(n.name, vt))
+	case *AssignNode:
+		g.expr(n.expr)
+		local, ok := g.resolve(n.name)
+		if !ok {
+			g.errorf(n.pos, "undefined: %s", n.name)
+		}
+		g.emitUint(wasmLocalSet, local)
+	case *IfNode:
+		g.expr(n.cond)
+		g.open(wasmIf, wasmEmpty)
+		g.stmt(n.then)
+		if n.els != nil {
+			g.emit(wasmElse)
+			g.stmt(n.els)
+		}
+		g.close()
+	case *ForNode:
+		// block { loop { br_if cond false to the end of the block; body; br to the loop } }
+		g.open(wasmBlock, wasmEmpty)
+		g.open(wasmLoop, wasmEmpty)
+		g.loops = append(g.loops, wasmLabels{brk: g.depth - 1, cont: g.depth})
+		if n.cond != nil {
+			g.expr(n.cond)
+			g.emit(wasmI32Eqz)
+			g.emitUint(wasmBrIf, 1)
+		}
+		g.stmt(n.body)
+		g.emitUint(wasmBr, 0)
+		g.loops = g.loops[:len(g.loops)-1]
+		g.close()
+		g.close()
+	case *BranchNode:
+		if len(g.loops) == 0 {
+			g.errorf(n.pos, "%s is not in a loop", n.keyword)
+			return
+		}
+		l := g.loops[len(g.loops)-1]
+		target := l.brk
+		if n.keyword == "continue" {
+			target = l.cont
+		}
+		g.emitUint(wasmBr, uint32(g.depth-target))
+	case *FieldAssignNode:
+		g.errorf(n.Pos(), "struct values are not supported by the wasm backend")
+	default:
+		g.errorf(node.Pos(), "cannot compile %T", node)
+	}
+}
+
+// zero pushes the zero value of a type
+func (g *wasmGen) zero(vt byte) {
+	switch vt {
+	case wasmF64:
+		g.float(0)
+	case wasmI32:
+		g.emit(wasmI32Const, 0)
+	default:
+		g.emit(wasmI64Const, 0)
+	}
+}
+
+func (g *wasmGen) float(f float64) {
+	g.emit(wasmF64Const)
+	bits := math.Float64bits(f)
+	for i := 0; i < 8; i++ {
+		g.emit(byte(bits >> (8 * i)))
+	}
+}
+
+// isFloat reports whether the checker found node to be a float
+func (g *wasmGen) isFloat(node Node) bool {
+	t := g.typeOf(node)
+	return !g.intConst && (t.kind == KindFloat || t.kind == KindUntypedFloat)
+}
+
+func (g *wasmGen) expr(node Node) {
+	// Untyped int constants are worked out with ints and only the result is converted, the same as the VM does
+	if _, lit := node.(*UnaryNode); !lit && !g.intConst && g.checker.untyped[node] == TypeUntypedInt && g.isFloat(node) {
+		g.intConst = true
+		g.expr(node)
+		g.intConst = false
+		g.emit(wasmF64ConvertI64)
+		return
+	}
+
+	switch n := node.(type) {
+	case *UnaryNode:
+		switch n.token.token {
+		case INT:
+			v, err := strconv.ParseInt(n.token.str, 10, 64)
+			if err != nil {
+				g.errorf(n.token.pos, "invalid integer %s", n.token.str)
+			}
+			if g.isFloat(n) {
+				g.float(float64(v)) // An untyped constant used as a float
+				return
+			}
+			g.code.Write(wasmInt([]byte{wasmI64Const}, v))
+		case FLOAT:
+			f, err := strconv.ParseFloat(n.token.str, 64)
+			if err != nil {
+				g.errorf(n.token.pos, "invalid float %s", n.token.str)
+			}
+			g.float(f)
+		case IDENT:
+			local, ok := g.resolve(n.token.str)
+			if ok {
+				g.emitUint(wasmLocalGet, local)
+				return
+			}
+			switch n.token.str {
+			case "true":
+				g.emit(wasmI32Const, 1)
+			case "false":
+				g.emit(wasmI32Const, 0)
+			default:
+				g.errorf(n.token.pos, "undefined: %s", n.token.str)
+			}
+		case STRING:
+			g.errorf(n.token.pos, "string values are not supported by the wasm backend")
+		default:
+			g.errorf(n.token.pos, "cannot compile %s", n.token)
+		}
+	case *ExprNode:
+		g.expr(n.expr)
+	case *PrefixExprNode:
+		switch {
+		case n.op == OpNot:
+			g.expr(n.expr)
+			g.emit(wasmI32Eqz)
+		case g.isFloat(n.expr):
+			g.expr(n.expr)
+			g.emit(wasmF64Neg)
+		default:
+			g.emit(wasmI64Const, 0) // There is no i64.neg, so -x is 0 - x
+			g.expr(n.expr)
+			g.emit(wasmIntCodes[OpSub])
+		}
+	case *BinaryExprNode:
+		if n.op == OpAnd || n.op == OpOr {
+			g.logical(n)
+			return
+		}
+		g.expr(n.left)
+		g.expr(n.right)
+		switch {
+		case g.isFloat(n.left):
+			g.emit(wasmFloatCodes[n.op])
+		case g.typeOf(n.left).kind == KindBool && n.op == OpEql:
+			g.emit(wasmI32Eq)
+		case g.typeOf(n.left).kind == KindBool:
+			g.emit(wasmI32Ne)
+		case n.op == OpDiv && !isIntLiteral(n.right):
+			g.divide()
+		default:
+			g.emit(wasmIntCodes[n.op])
+		}
+	case *CallExprNode:
+		for i := range n.args {
+			g.expr(n.args[i])
+		}
+		if _, ok := n.fn.(*SelectorNode); ok {
+			g.errorf(n.pos, "method calls are not supported by the wasm backend")
+			return
+		}
+		ident, _ := n.fn.(*UnaryNode)
+		if ident == nil {
+			g.errorf(n.pos, "cannot call non-function")
+			return
+		}
+		fn, ok := g.index[ident.token.str]
+		if !ok {
+			g.errorf(ident.token.pos, "undefined: %s", ident.token.str)
+			return
+		}
+		g.emitUint(wasmCall, fn)
+	case *SelectorNode, *CompositeLitNode:
+		g.errorf(n.Pos(), "struct values are not supported by the wasm backend")
+	default:
+		g.errorf(node.Pos(), "cannot compile %T", node)
+	}
+}
+
+// logical compiles && and || so that the right hand side is only evaluated when it is needed
+//   a && b:  a; if (result i32) b else i32.const 0 end
+//   a || b:  a; if (result i32) i32.const 1 else b end
+func (g *wasmGen) logical(n *BinaryExprNode) {
+	g.expr(n.left)
+	g.open(wasmIf, wasmI32)
+	if n.op == OpAnd {
+		g.expr(n.right)
+		g.emit(wasmElse, wasmI32Const, 0)
+	} else {
+		g.emit(wasmI32Const, 1, wasmElse)
+		g.expr(n.right)
+	}
+	g.close()
+}
@@ -0,0 +1,284 @@
+package noot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// wasmSections checks the header of a module and gives the ids of its sections, in order, along with their contents
+func wasmSections(data []byte) ([]byte, [][]byte, error) {
+	if len(data) < 8 || string(data[:8]) != "\x00asm\x01\x00\x00\x00" {
+		return nil, nil, fmt.Errorf("bad header")
+	}
+	ids, contents := []byte{}, [][]byte{}
+	for pos := 8; pos < len(data); {
+		id := data[pos]
+		size, n := readWasmUint(data[pos+1:])
+		start := pos + 1 + n
+		if n == 0 || start+int(size) > len(data) {
+			return nil, nil, fmt.Errorf("section %d runs past the end of the module", id)
+		}
+		ids = append(ids, id)
+		contents = append(contents, data[start:start+int(size)])
+		pos = start + int(size)
+	}
+	return ids, contents, nil
+}
+
+// readWasmUint decodes an unsigned LEB128 number, and gives how many bytes it took. 0 bytes means it was cut off
+func readWasmUint(buf []byte) (uint64, int) {
+	v := uint64(0)
+	for i, shift := 0, 0; i < len(buf); i, shift = i+1, shift+7 {
+		v |= uint64(buf[i]&0x7f) << shift
+		if buf[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// instantiateWasm runs a module on wazero, which validates it against the specification first
+func instantiateWasm(t *testing.T, name string, out []byte) (api.Module, wazero.CompiledModule) {
+	t.Helper()
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter().WithCloseOnContextDone(true))
+	t.Cleanup(func() { r.Close(ctx) })
+	compiled, err := r.CompileModule(ctx, out)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	m, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName(name))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return m, compiled
+}
+
+// callWasm calls an exported function with VM values as the arguments, and gives the result as one
+func callWasm(m api.Module, name string, args []int) (int, error) {
+	fn := m.ExportedFunction(name)
+	if fn == nil {
+		return 0, fmt.Errorf("%s is not exported", name)
+	}
+	params := make([]uint64, len(args))
+	for i, t := range fn.Definition().ParamTypes() {
+		params[i] = uint64(args[i])
+		if t == api.ValueTypeI32 {
+			params[i] = api.EncodeI32(int32(args[i]))
+		}
+	}
+	// Loops that never end are stopped instead of hanging the test
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	results, err := fn.Call(ctx, params...)
+	if err != nil || len(results) == 0 {
+		return 0, err
+	}
+	return int(results[0]), nil
+}
+
+const wasmFloats = `func avg(a float, b float) float {
+	var sum float
+	sum = a + b
+	return -(-sum / 2)
+}
+
+func clamp(x int, limit float) float {
+	var f float = 1.5
+	for x > 0 {
+		f = f * 2
+		x = x - 1
+	}
+	if f > limit {
+		return limit
+	}
+	return f
+}
+
+func logic(n int) bool {
+	ok := n > 0 && n / 2 * 2 == n || !(n != -4)
+	return ok == true != false
+}
+
+func count(n int) int {
+	total := 0
+	for {
+		n = n - 1
+		if n < 0 {
+			break
+		}
+		if n / 3 * 3 == n {
+			continue
+		}
+		total = total + n
+	}
+	sink(total)
+	return total
+}
+
+func sink(n int) {
+	if n > 0 {
+		return
+	}
+}
+`
+
+// generateWasm checks the source and compiles it to a module
+func generateWasm(t *testing.T, name, src string) (*FileNode, []byte) {
+	t.Helper()
+	file, checker, errs := (&source{name: name, data: []byte(src)}).check()
+	if len(errs) > 0 {
+		t.Fatalf("%s: %v", name, errs)
+	}
+	out, errs := GenerateWasm(file, checker)
+	if len(errs) > 0 {
+		t.Fatalf("%s: %v", name, errs)
+	}
+	return file, out
+}
+
+func TestWasmModule(t *testing.T) {
+	file, out := generateWasm(t, "program.noot", gogenProgram)
+	ids, contents, err := wasmSections(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []byte{1, 3, 7, 10}) {
+		t.Errorf("got sections %v, want type, function, export and code", ids)
+	}
+	// fib, collatz and shadow share a type
+	if types, _ := readWasmUint(contents[0]); types != 3 {
+		t.Errorf("got %d types, want 3", types)
+	}
+
+	_, compiled := instantiateWasm(t, "program.noot", out)
+	i64, i32 := []api.ValueType{api.ValueTypeI64}, []api.ValueType{api.ValueTypeI32}
+	want := map[string][2][]api.ValueType{
+		"fib": {i64, i64},
+		"collatz": {i64, i64},
+		"isPrime": {i64, i32},
+		"shadow": {i64, i64},
+		"nothing": {i64, nil},
+	}
+	exports := compiled.ExportedFunctions()
+	if len(exports) != len(want) {
+		t.Errorf("got %d exports, want %d", len(exports), len(want))
+	}
+	for i, node := range file.nodes {
+		f, ok := node.(*FuncNode)
+		if !ok {
+			continue
+		}
+		def, ok := exports[f.funcName]
+		if !ok {
+			t.Errorf("%s is not exported", f.funcName)
+			continue
+		}
+		if int(def.Index()) != i-1 { // After the type declaration
+			t.Errorf("%s is function %d, want %d", f.funcName, def.Index(), i-1)
+		}
+		got := [2][]api.ValueType{def.ParamTypes(), def.ResultTypes()}
+		if len(got[1]) == 0 {
+			got[1] = nil
+		}
+		if !reflect.DeepEqual(got, want[f.funcName]) {
+			t.Errorf("%s has type %v, want %v", f.funcName, got, want[f.funcName])
+		}
+	}
+}
+
+// i64.div_s traps on the smallest int divided by -1, where the other backends wrap around
+const wasmDivide = `func quot(a int, b int) int {
+	return a / b
+}
+
+func flip(a int, b int) int {
+	return a / -1 + b / 3
+}
+`
+
+// The module has to compute what the interpreter does
+func TestWasmMatchesInterpreter(t *testing.T) {
+	data, err := os.ReadFile("input.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		src string
+		calls [][]int
+		funcs []string
+	}{
+		{"input.test", string(data), [][]int{{-3, 7}, {0, 0}, {1, 2}, {10, 0}}, []string{"FunctionA", "FunctionB", "FunctionC"}},
+		{"program.noot", gogenProgram, [][]int{{1}, {2}, {7}, {12}, {-4}}, []string{"fib", "collatz", "isPrime", "shadow", "nothing"}},
+		{"floats.noot", wasmFloats, [][]int{{-3, floatToValue(0.25)}, {0, floatToValue(-1)}, {4, floatToValue(1e10)}}, []string{"avg", "clamp"}},
+		{"logic.noot", wasmFloats, [][]int{{-4}, {-1}, {0}, {6}, {9}}, []string{"logic", "count", "sink"}},
+		{"consts.noot", untypedConsts, [][]int{{floatToValue(0)}, {floatToValue(3.25)}, {floatToValue(-1.5)}}, []string{"half", "mixed", "scale", "above"}},
+		{"div.noot", wasmDivide, [][]int{{math.MinInt, -1}, {math.MinInt, 2}, {7, -1}, {-7, 2}, {math.MaxInt, -1}}, []string{"quot", "flip"}},
+	}
+
+	for _, test := range tests {
+		file, out := generateWasm(t, test.name, test.src)
+		m, _ := instantiateWasm(t, test.name, out)
+		in := NewInterpreter(file)
+		for _, name := range test.funcs {
+			for _, args := range test.calls {
+				want, err := in.Call(name, args...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := callWasm(m, name, args)
+				if err != nil {
+					t.Errorf("%s%v: %v", name, args, err)
+					continue
+				}
+				if got != want {
+					t.Errorf("%s: %s%v = %d, want %d", test.name, name, args, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestWasmTraps(t *testing.T) {
+	_, out := generateWasm(t, "div.noot", "func div(a int, b int) int {\n\treturn a / b\n}\n")
+	m, _ := instantiateWasm(t, "div.noot", out)
+	if _, err := callWasm(m, "div", []int{1, 0}); err == nil || !strings.Contains(err.Error(), "integer divide by zero") {
+		t.Errorf("got %v, want a trap", err)
+	}
+}
+
+func TestWasmUnsupported(t *testing.T) {
+	src, err := os.ReadFile("testdata/golden/decls.noot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, checker, errs := (&source{name: "decls.noot", data: src}).check()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	out, errs := GenerateWasm(file, checker)
+	if out != nil {
+		t.Error("expected no module")
+	}
+	got := []string{}
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"3:1: struct types are not supported by the wasm backend",
+		"8:15: method Len2: methods are not supported by the wasm backend",
+	}
+	if len(got) < len(want) || !reflect.DeepEqual(got[:len(want)], want) {
+		t.Errorf("got:\n%s", strings.Join(got, "\n"))
+	}
+}